@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+func runMerge(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("merge", "", e)
+	db.register(fs, true)
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+
+	store, err := db.open(false)
+	if err != nil {
+		return e.fail("merge", err)
+	}
+
+	if err := store.Merge(); err != nil {
+		_ = store.Close()
+		return e.fail("merge", err)
+	}
+
+	if err := store.Close(); err != nil {
+		return e.fail("merge", err)
+	}
+
+	return exitOK
+}
+
+func runStats(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("stats", "", e)
+	db.register(fs, false)
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+
+	store, err := db.open(true)
+	if err != nil {
+		return e.fail("stats", err)
+	}
+	defer store.Close()
+
+	stats, err := store.Stats()
+	if err != nil {
+		return e.fail("stats", err)
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "ID\tSIZE\tLIVE\tDEAD\tDEAD %\t")
+	var size, live, dead int64
+	for _, s := range stats {
+		id := strconv.FormatUint(uint64(s.ID), 10)
+		if s.Active {
+			id += " (active)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.1f\t\n", id, s.Size, s.LiveBytes, s.DeadBytes, 100*s.DeadRatio())
+		size, live, dead = size+s.Size, live+s.LiveBytes, dead+s.DeadBytes
+	}
+	var ratio float64
+	if size > 0 {
+		ratio = float64(dead) / float64(size)
+	}
+	fmt.Fprintf(tw, "total\t%d\t%d\t%d\t%.1f\t\n", size, live, dead, 100*ratio)
+
+	if err := tw.Flush(); err != nil {
+		return e.fail("stats", err)
+	}
+
+	return exitOK
+}
+
+func runVerify(e *env, args []string) int {
+	fs := newFlagSet("verify", "", e)
+	dir := fs.String("dir", kv.DefaultDBPath, "database directory")
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+
+	report, err := log.Verify(*dir)
+	if err != nil {
+		return e.fail("verify", err)
+	}
+
+	if _, err := report.WriteTo(e.stdout); err != nil {
+		return e.fail("verify", err)
+	}
+
+	if !report.OK() {
+		return exitCorrupt
+	}
+
+	return exitOK
+}
+
+func runDump(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("dump", "", e)
+	db.register(fs, false)
+	values := fs.Bool("values", false, "print the values too")
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+
+	opts, err := db.logOptions(true)
+	if err != nil {
+		return e.fail("dump", err)
+	}
+
+	tw := tabwriter.NewWriter(e.stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tOFFSET\tSIZE\tTIME\tEXPIRY\tFLAGS\tKEY\tVALUE")
+	err = log.Dump(db.dir, func(r log.DumpRecord) error {
+		value := fmt.Sprintf("%d bytes", r.ValueSize)
+		switch {
+		case r.ValueSize == 0:
+			value = "tombstone"
+		case *values && !r.Sealed:
+			value = strconv.Quote(string(r.Value))
+		}
+
+		_, err := fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			r.FileID, r.Offset, r.Size,
+			record.Time(r.Timestamp).UTC().Format(time.RFC3339),
+			formatExpiry(r.Expiry), formatFlags(r.Flags, r.Sealed),
+			strconv.Quote(string(r.Key)), value)
+		return err
+	}, opts...)
+	if err != nil {
+		return e.fail("dump", err)
+	}
+
+	if err := tw.Flush(); err != nil {
+		return e.fail("dump", err)
+	}
+
+	return exitOK
+}
+
+func formatExpiry(expiry uint32) string {
+	if expiry == 0 {
+		return "-"
+	}
+	return record.Time(expiry).UTC().Format(time.RFC3339)
+}
+
+// formatFlags lists the flags of a record, sealed records keep the key and
+// value they were stored with.
+func formatFlags(f record.Flag, sealed bool) string {
+	var s []byte
+	add := func(name string) {
+		if len(s) > 0 {
+			s = append(s, ',')
+		}
+		s = append(s, name...)
+	}
+
+	if f&record.FlagBatch != 0 {
+		add("batch")
+	}
+	if f&record.FlagBatchCommit != 0 {
+		add("commit")
+	}
+	if f&record.FlagEncrypted != 0 {
+		if sealed {
+			add("sealed")
+		} else {
+			add("encrypted")
+		}
+	}
+	if f&record.FlagMeta != 0 {
+		add("meta")
+	}
+	if id := f.Codec(); id != record.NoCodec {
+		add("codec=" + strconv.Itoa(int(id)))
+	}
+
+	if len(s) == 0 {
+		return "-"
+	}
+	return string(s)
+}
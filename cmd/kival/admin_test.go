@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillDir writes keys overwritten a few times so merge has something to drop.
+func fillDir(t *testing.T, dir string) {
+	t.Helper()
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 5; i++ {
+			mustKival(t, "", "put", "--dir", dir, fmt.Sprintf("k%d", i), fmt.Sprintf("v%d-%d", i, round))
+		}
+	}
+	mustKival(t, "", "del", "--dir", dir, "k0")
+}
+
+func TestStats(t *testing.T) {
+	dir := t.TempDir()
+	fillDir(t, dir)
+
+	lines := strings.Split(strings.TrimSpace(mustKival(t, "", "stats", "--dir", dir)), "\n")
+	require.Len(t, lines, 3)
+	assert.Regexp(t, `^\s*ID\s+SIZE\s+LIVE\s+DEAD\s+DEAD %$`, lines[0])
+	assert.Regexp(t, `^\s+1 \(active\)\s+\d+\s+\d+\s+\d+\s+\d+\.\d$`, lines[1])
+	assert.Regexp(t, `^\s+total\s`, lines[2])
+	assert.NotRegexp(t, ` 0\.0$`, lines[2], "overwrites leave dead bytes")
+}
+
+func TestMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	// small log files, so there are sealed ones for merge to compact
+	db, err := kv.New(dir, kv.WithLogOptions(log.WithMaxSegmentSize(log.MinSegmentSize)))
+	require.NoError(t, err)
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 10; i++ {
+			require.NoError(t, db.Put(fmt.Appendf(nil, "k%d", i), fmt.Appendf(nil, "v%d-%d", i, round)))
+		}
+	}
+	require.NoError(t, db.Del([]byte("k0")))
+	before, err := db.Stats()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	mustKival(t, "", "merge", "--dir", dir)
+
+	db, err = kv.New(dir, kv.WithLogOptions(log.WithReadOnly()))
+	require.NoError(t, err)
+	after, err := db.Stats()
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+	assert.Less(t, len(after), len(before))
+
+	for i := 1; i < 10; i++ {
+		assert.Equal(t, fmt.Sprintf("v%d-9", i), mustKival(t, "", "get", "--dir", dir, fmt.Sprintf("k%d", i)))
+	}
+	code, _, _ := kival("", "get", "--dir", dir, "k0")
+	assert.Equal(t, exitNotFound, code)
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	fillDir(t, dir)
+
+	assert.Contains(t, mustKival(t, "", "verify", "--dir", dir), "no problem found")
+
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	data[log.SegmentHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	code, stdout, _ := kival("", "verify", "--dir", dir)
+	assert.Equal(t, exitCorrupt, code)
+	assert.Contains(t, stdout, "problem: 1.data:")
+
+	code, _, _ = kival("", "verify", "--dir", filepath.Join(dir, "missing"))
+	assert.Equal(t, exitError, code)
+}
+
+func TestDump(t *testing.T) {
+	dir := t.TempDir()
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1")
+	mustKival(t, "", "put", "--dir", dir, "--ttl", "1h", "k2", "v2")
+	mustKival(t, "", "del", "--dir", dir, "k1")
+
+	lines := strings.Split(strings.TrimSpace(mustKival(t, "", "dump", "--dir", dir)), "\n")
+	require.Len(t, lines, 4)
+	assert.Regexp(t, `^FILE\s+OFFSET\s+SIZE\s+TIME\s+EXPIRY\s+FLAGS\s+KEY\s+VALUE$`, lines[0])
+	assert.Regexp(t, fmt.Sprintf(`^1\s+%d\s+\d+\s+\S+\s+-\s+-\s+"k1"\s+2 bytes$`, log.SegmentHeaderSize), lines[1])
+	assert.Regexp(t, `\s\S+Z\s+\S+Z\s+-\s+"k2"\s+2 bytes$`, lines[2], "k2 expires")
+	assert.Regexp(t, `"k1"\s+tombstone$`, lines[3])
+
+	out := mustKival(t, "", "dump", "--dir", dir, "--values")
+	assert.Contains(t, out, `"v1"`)
+}
+
+func TestDump_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyring := filepath.Join(t.TempDir(), "keyring")
+	require.NoError(t, os.WriteFile(keyring, []byte("1 "+strings.Repeat("ab", 32)+"\n"), 0o600))
+
+	mustKival(t, "", "put", "--dir", dir, "--keyring", keyring, "secret", "value")
+	assert.Equal(t, "value", mustKival(t, "", "get", "--dir", dir, "--keyring", keyring, "secret"))
+
+	out := mustKival(t, "", "dump", "--dir", dir, "--keyring", keyring, "--values")
+	assert.Contains(t, out, `"secret"`)
+	assert.Contains(t, out, "encrypted")
+	assert.Contains(t, out, `"value"`)
+
+	out = mustKival(t, "", "dump", "--dir", dir, "--values")
+	assert.NotContains(t, out, "secret")
+	assert.Contains(t, out, "sealed")
+}
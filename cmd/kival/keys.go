@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/1garo/kival/kv"
+)
+
+func runGet(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("get", "<key>", e)
+	db.register(fs, false)
+	newline := fs.Bool("n", false, "print a newline after the value")
+	if code, stop := parseFlags(fs, args, 1); stop {
+		return code
+	}
+
+	store, err := db.open(true)
+	if err != nil {
+		return e.fail("get", err)
+	}
+	defer store.Close()
+
+	val, err := store.Get([]byte(fs.Arg(0)))
+	if err != nil {
+		return e.fail("get", err)
+	}
+
+	if *newline {
+		val = append(val, '\n')
+	}
+	if _, err := e.stdout.Write(val); err != nil {
+		return e.fail("get", err)
+	}
+
+	return exitOK
+}
+
+func runPut(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("put", "<key> [value]", e)
+	db.register(fs, true)
+	file := fs.String("file", "", "read the value from this file")
+	ttl := fs.Duration("ttl", 0, "expire the key after this long, 0 keeps it forever")
+	if code, stop := parseFlags(fs, args, 1, 2); stop {
+		return code
+	}
+
+	val, err := readValue(e, fs.Args()[1:], *file)
+	if err != nil {
+		return e.fail("put", err)
+	}
+
+	store, err := db.open(false)
+	if err != nil {
+		return e.fail("put", err)
+	}
+
+	key := []byte(fs.Arg(0))
+	if *ttl != 0 {
+		err = store.PutWithTTL(key, val, *ttl)
+	} else {
+		err = store.Put(key, val)
+	}
+	if err != nil {
+		_ = store.Close()
+		return e.fail("put", err)
+	}
+
+	if err := store.Close(); err != nil {
+		return e.fail("put", err)
+	}
+
+	return exitOK
+}
+
+// readValue returns the value of put: the argument if there is one, the
+// content of file if set, stdin otherwise.
+func readValue(e *env, args []string, file string) ([]byte, error) {
+	switch {
+	case len(args) > 0 && file != "":
+		return nil, errors.New("give the value as an argument or with -file, not both")
+	case len(args) > 0:
+		return []byte(args[0]), nil
+	case file != "":
+		return os.ReadFile(file)
+	default:
+		return io.ReadAll(e.stdin)
+	}
+}
+
+func runDel(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("del", "<key>", e)
+	db.register(fs, true)
+	if code, stop := parseFlags(fs, args, 1); stop {
+		return code
+	}
+
+	store, err := db.open(false)
+	if err != nil {
+		return e.fail("del", err)
+	}
+
+	if err := store.Del([]byte(fs.Arg(0))); err != nil {
+		_ = store.Close()
+		return e.fail("del", err)
+	}
+
+	if err := store.Close(); err != nil {
+		return e.fail("del", err)
+	}
+
+	return exitOK
+}
+
+func runScan(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("scan", "", e)
+	db.register(fs, false)
+	prefix := fs.String("prefix", "", "only keys starting with this prefix")
+	start := fs.String("start", "", "first key of the range, inclusive")
+	end := fs.String("end", "", "end of the range, exclusive")
+	reverse := fs.Bool("reverse", false, "go from the greatest key to the smallest one")
+	keysOnly := fs.Bool("keys", false, "print the keys only")
+	quote := fs.Bool("q", false, "quote keys and values as Go strings, for binary data")
+	limit := fs.Int("limit", 0, "stop after this many keys, 0 means no limit")
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+	if *prefix != "" && (*start != "" || *end != "") {
+		fmt.Fprintln(e.stderr, "kival scan: -prefix cannot be used with -start or -end")
+		return exitUsage
+	}
+
+	store, err := db.open(true)
+	if err != nil {
+		return e.fail("scan", err)
+	}
+	defer store.Close()
+
+	var opts []kv.ScanOption
+	if *reverse {
+		opts = append(opts, kv.Reverse())
+	}
+	if *keysOnly {
+		opts = append(opts, kv.KeysOnly())
+	}
+
+	var it *kv.Iterator
+	if *prefix != "" {
+		it = store.ScanPrefix([]byte(*prefix), opts...)
+	} else {
+		it = store.Scan(optional(*start), optional(*end), opts...)
+	}
+
+	format := func(b []byte) string { return string(b) }
+	if *quote {
+		format = func(b []byte) string { return strconv.Quote(string(b)) }
+	}
+
+	for n := 0; (*limit <= 0 || n < *limit) && it.Next(); n++ {
+		var err error
+		if *keysOnly {
+			_, err = fmt.Fprintln(e.stdout, format(it.Key()))
+		} else {
+			_, err = fmt.Fprintf(e.stdout, "%s\t%s\n", format(it.Key()), format(it.Value()))
+		}
+		if err != nil {
+			return e.fail("scan", err)
+		}
+	}
+	if err := it.Err(); err != nil {
+		return e.fail("scan", err)
+	}
+
+	return exitOK
+}
+
+// optional returns nil for an empty bound, which leaves that side of a scan open.
+func optional(s string) []byte {
+	if s == "" {
+		return nil
+	}
+	return []byte(s)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPutGet(t *testing.T) {
+	dir := t.TempDir()
+
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1")
+	assert.Equal(t, "v1", mustKival(t, "", "get", "--dir", dir, "k1"))
+	assert.Equal(t, "v1\n", mustKival(t, "", "get", "--dir", dir, "-n", "k1"))
+
+	mustKival(t, "from\nstdin\x00", "put", "--dir", dir, "k2")
+	assert.Equal(t, "from\nstdin\x00", mustKival(t, "", "get", "--dir", dir, "k2"))
+
+	file := filepath.Join(t.TempDir(), "value")
+	require.NoError(t, os.WriteFile(file, []byte("from file"), 0o644))
+	mustKival(t, "", "put", "--dir", dir, "--file", file, "k3")
+	assert.Equal(t, "from file", mustKival(t, "", "get", "--dir", dir, "k3"))
+
+	code, _, stderr := kival("", "put", "--dir", dir, "--file", file, "k3", "v3")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "not both")
+}
+
+func TestPut_TTL(t *testing.T) {
+	dir := t.TempDir()
+
+	mustKival(t, "", "put", "--dir", dir, "--ttl", "1h", "k1", "v1")
+	assert.Equal(t, "v1", mustKival(t, "", "get", "--dir", dir, "k1"))
+
+	code, _, _ := kival("", "put", "--dir", dir, "--ttl", "-1s", "k1", "v1")
+	assert.Equal(t, exitError, code)
+
+}
+
+func TestGetDel_NotFound(t *testing.T) {
+	dir := t.TempDir()
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1")
+
+	code, stdout, stderr := kival("", "get", "--dir", dir, "missing")
+	assert.Equal(t, exitNotFound, code)
+	assert.Empty(t, stdout)
+	assert.Contains(t, stderr, "key not found")
+
+	mustKival(t, "", "del", "--dir", dir, "k1")
+	code, _, _ = kival("", "get", "--dir", dir, "k1")
+	assert.Equal(t, exitNotFound, code)
+
+	code, _, _ = kival("", "del", "--dir", dir, "k1")
+	assert.Equal(t, exitNotFound, code)
+}
+
+func TestGet_LockedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db, err := kv.New(dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	code, _, stderr := kival("", "get", "--dir", dir, "k1")
+	assert.Equal(t, exitLocked, code)
+	assert.Contains(t, stderr, "locked")
+
+	code, _, _ = kival("", "put", "--dir", dir, "k1", "v1")
+	assert.Equal(t, exitLocked, code)
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	for _, k := range []string{"a1", "b1", "b2", "b3", "c1"} {
+		mustKival(t, "", "put", "--dir", dir, k, "v"+k)
+	}
+	mustKival(t, "", "put", "--dir", dir, "bin", "\x00\n")
+
+	assert.Equal(t, "a1\tva1\nb1\tvb1\nb2\tvb2\nb3\tvb3\nbin\t\x00\n\nc1\tvc1\n", mustKival(t, "", "scan", "--dir", dir))
+	assert.Equal(t, "b1\nb2\nb3\nbin\n", mustKival(t, "", "scan", "--dir", dir, "--prefix", "b", "--keys"))
+	assert.Equal(t, "b2\nb3\n", mustKival(t, "", "scan", "--dir", dir, "--start", "b2", "--end", "bin", "--keys"))
+	assert.Equal(t, "c1\nbin\n", mustKival(t, "", "scan", "--dir", dir, "--reverse", "--limit", "2", "--keys"))
+	assert.Equal(t, "\"bin\"\t\"\\x00\\n\"\n", mustKival(t, "", "scan", "--dir", dir, "--prefix", "bin", "-q"))
+}
@@ -0,0 +1,275 @@
+// Command kival inspects and maintains kival databases.
+//
+//	kival put --dir ./data user:1 alice
+//	kival get --dir ./data user:1
+//	kival scan --dir ./data --prefix user:
+//
+// Run kival help for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+// Exit codes of kival.
+const (
+	exitOK       = 0
+	exitError    = 1 // the command failed
+	exitUsage    = 2 // the command line is invalid
+	exitNotFound = 3 // the key does not exist
+	exitCorrupt  = 4 // the database is damaged
+	exitLocked   = 5 // another process has the database open
+)
+
+// command is a kival subcommand.
+type command struct {
+	name    string
+	summary string
+	run     func(env *env, args []string) int
+}
+
+// env is what a command runs with. ctx is canceled when kival is asked to
+// stop, long running commands like serve watch it.
+type env struct {
+	ctx    context.Context
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"get", "print the value of a key", runGet},
+		{"put", "set the value of a key, read from stdin when not given", runPut},
+		{"del", "delete a key", runDel},
+		{"scan", "list keys and values in order", runScan},
+		{"merge", "compact the log files", runMerge},
+		{"stats", "show how much of each log file is live", runStats},
+		{"verify", "check the integrity of a database without changing it", runVerify},
+		{"dump", "list every record of the log files as stored", runDump},
+		{"repair", "salvage the records of a corrupt database", runRepair},
+		{"serve", "serve a database to Redis, HTTP or memcached clients", runServe},
+		{"help", "show this help", runHelp},
+	}
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(os.Args[1:], &env{ctx: ctx, stdin: os.Stdin, stdout: os.Stdout, stderr: os.Stderr})
+	stop()
+	os.Exit(code)
+}
+
+// run runs the subcommand named by args[0] and returns the exit code.
+func run(args []string, e *env) int {
+	if len(args) == 0 {
+		usage(e.stderr)
+		return exitUsage
+	}
+
+	name := args[0]
+	if name == "-h" || name == "--help" {
+		name = "help"
+	}
+
+	for _, c := range commands {
+		if c.name == name {
+			return c.run(e, args[1:])
+		}
+	}
+
+	fmt.Fprintf(e.stderr, "kival: unknown command %q\n", name)
+	usage(e.stderr)
+	return exitUsage
+}
+
+func runHelp(e *env, _ []string) int {
+	usage(e.stdout)
+	return exitOK
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: kival <command> [flags] [args]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "run kival <command> -h for the flags of a command")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "exit codes:")
+	fmt.Fprintln(w, "  1 the command failed")
+	fmt.Fprintln(w, "  2 the command line is invalid")
+	fmt.Fprintln(w, "  3 the key does not exist")
+	fmt.Fprintln(w, "  4 the database is damaged")
+	fmt.Fprintln(w, "  5 another process has the database open")
+}
+
+// fail reports err for command name and returns the exit code that goes with it.
+func (e *env) fail(name string, err error) int {
+	fmt.Fprintf(e.stderr, "kival %s: %v\n", name, err)
+	return exitCode(err)
+}
+
+// exitCode maps err to the exit code of kival.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, kv.ErrKeyNotFound):
+		return exitNotFound
+	case errors.Is(err, log.ErrDatabaseLocked):
+		return exitLocked
+	case errors.Is(err, log.ErrCorruptSegmentHeader),
+		errors.Is(err, record.ErrCorruptRecord),
+		errors.Is(err, record.ErrUnknownVersion):
+		return exitCorrupt
+	default:
+		return exitError
+	}
+}
+
+// newFlagSet returns the flag set of command name, writing errors to stderr.
+func newFlagSet(name, args string, e *env) *flag.FlagSet {
+	fs := flag.NewFlagSet("kival "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(e.stderr, "usage: kival %s [flags] %s\n\nflags:\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and checks it is left with nargs arguments,
+// or between nargs[0] and nargs[1]. It returns the exit code to stop with, if
+// any.
+func parseFlags(fs *flag.FlagSet, args []string, nargs ...int) (int, bool) {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, true
+		}
+		return exitUsage, true
+	}
+
+	minArgs, maxArgs := 0, 0
+	switch len(nargs) {
+	case 1:
+		minArgs, maxArgs = nargs[0], nargs[0]
+	case 2:
+		minArgs, maxArgs = nargs[0], nargs[1]
+	}
+	if fs.NArg() < minArgs || fs.NArg() > maxArgs {
+		fmt.Fprintf(fs.Output(), "%s: expected %s, got %q\n", fs.Name(), argCount(minArgs, maxArgs), fs.Args())
+		fs.Usage()
+		return exitUsage, true
+	}
+
+	return exitOK, false
+}
+
+func argCount(minArgs, maxArgs int) string {
+	plural := func(n int) string {
+		if n == 1 {
+			return "1 argument"
+		}
+		return fmt.Sprintf("%d arguments", n)
+	}
+
+	if minArgs == maxArgs {
+		return plural(minArgs)
+	}
+	return fmt.Sprintf("%d to %s", minArgs, plural(maxArgs))
+}
+
+// dbFlags are the flags of the commands that open a database.
+type dbFlags struct {
+	dir          string
+	sync         string
+	syncEveryN   int
+	syncInterval time.Duration
+	keyring      string
+}
+
+// register adds the database flags to fs. Commands that only read take no
+// sync flags.
+func (f *dbFlags) register(fs *flag.FlagSet, writes bool) {
+	fs.StringVar(&f.dir, "dir", kv.DefaultDBPath, "database directory")
+	fs.StringVar(&f.keyring, "keyring", "", "keyring file of an encrypted database, see record.LoadKeyring")
+	if !writes {
+		return
+	}
+
+	fs.StringVar(&f.sync, "sync", "always", "sync strategy: always, every-n, group, interval or never")
+	fs.IntVar(&f.syncEveryN, "sync-every", 1, "writes between syncs with -sync every-n")
+	fs.DurationVar(&f.syncInterval, "sync-interval", log.DefaultSyncInterval, "time between syncs with -sync interval")
+}
+
+// logOptions returns the log options the flags ask for.
+func (f *dbFlags) logOptions(readOnly bool) ([]log.Option, error) {
+	var opts []log.Option
+	if readOnly {
+		opts = append(opts, log.WithReadOnly())
+	} else {
+		strategy, err := parseSyncStrategy(f.sync)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts,
+			log.WithSyncStrategy(strategy),
+			log.WithSyncEveryN(int32(f.syncEveryN)),
+			log.WithSyncInterval(f.syncInterval),
+		)
+	}
+
+	if f.keyring != "" {
+		keys, err := record.LoadKeyring(f.keyring)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, log.WithEncryption(keys))
+	}
+
+	return opts, nil
+}
+
+// open opens the database of the flags, for reads only when readOnly is set.
+func (f *dbFlags) open(readOnly bool) (kv.KV, error) {
+	opts, err := f.logOptions(readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	return kv.New(f.dir, kv.WithLogOptions(opts...))
+}
+
+var syncStrategies = map[string]log.SyncStrategy{
+	"always":   log.Always,
+	"every-n":  log.EveryN,
+	"group":    log.GroupCommit,
+	"interval": log.Interval,
+	"never":    log.Never,
+}
+
+func parseSyncStrategy(s string) (log.SyncStrategy, error) {
+	strategy, ok := syncStrategies[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown sync strategy %q", s)
+	}
+	return strategy, nil
+}
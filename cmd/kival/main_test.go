@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kival runs the command line args with stdin and returns the exit code and
+// what was written to stdout and stderr.
+func kival(stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	code := run(args, &env{ctx: context.Background(), stdin: strings.NewReader(stdin), stdout: &stdout, stderr: &stderr})
+	return code, stdout.String(), stderr.String()
+}
+
+// mustKival runs kival and fails the test unless it exits with exitOK.
+func mustKival(t *testing.T, stdin string, args ...string) string {
+	t.Helper()
+
+	code, stdout, stderr := kival(stdin, args...)
+	require.Equal(t, exitOK, code, "kival %s: %s", strings.Join(args, " "), stderr)
+	return stdout
+}
+
+func TestRun_Usage(t *testing.T) {
+	code, _, stderr := kival("")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "usage: kival")
+
+	for _, args := range [][]string{{"help"}, {"-h"}, {"--help"}} {
+		code, stdout, _ := kival("", args...)
+		assert.Equal(t, exitOK, code)
+		for _, c := range []string{"get", "put", "del", "scan", "merge", "stats", "verify", "dump", "repair", "serve"} {
+			assert.Contains(t, stdout, "  "+c+" ")
+		}
+	}
+}
+
+func TestRun_UnknownCommand(t *testing.T) {
+	code, _, stderr := kival("", "nope")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, `unknown command "nope"`)
+}
+
+func TestRun_BadArguments(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, args := range [][]string{
+		{"get", "--nope"},
+		{"get", "--dir", dir},
+		{"get", "--dir", dir, "k1", "k2"},
+		{"put", "--dir", dir},
+		{"put", "--dir", dir, "k1", "v1", "extra"},
+		{"repair", "--dir", dir, "extra"},
+		{"scan", "--dir", dir, "--prefix", "a", "--start", "b"},
+	} {
+		code, _, stderr := kival("", args...)
+		assert.Equal(t, exitUsage, code, "%v: %s", args, stderr)
+	}
+
+	code, _, stderr := kival("", "get", "-h")
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, stderr, "usage: kival get [flags] <key>")
+}
+
+func TestRun_SyncFlags(t *testing.T) {
+	dir := t.TempDir()
+
+	for _, sync := range []string{"always", "every-n", "group", "interval", "never"} {
+		mustKival(t, "", "put", "--dir", dir, "--sync", sync, "--sync-every", "10", "--sync-interval", "5ms", sync, "v")
+		assert.Equal(t, "v", mustKival(t, "", "get", "--dir", dir, sync))
+	}
+
+	code, _, stderr := kival("", "put", "--dir", dir, "--sync", "sometimes", "k1", "v1")
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, `unknown sync strategy "sometimes"`)
+
+	code, _, _ = kival("", "put", "--dir", dir, "--sync", "interval", "--sync-interval", "0s", "k1", "v1")
+	assert.Equal(t, exitError, code)
+}
+
+func TestExitCode(t *testing.T) {
+	for err, want := range map[error]int{
+		nil:                                    exitOK,
+		kv.ErrKeyNotFound:                      exitNotFound,
+		log.ErrDatabaseLocked:                  exitLocked,
+		log.ErrCorruptSegmentHeader:            exitCorrupt,
+		record.ErrCorruptRecord:                exitCorrupt,
+		record.ErrUnknownVersion:               exitCorrupt,
+		log.ErrCapacityExceeded:                exitError,
+		fmt.Errorf("x: %w", kv.ErrKeyNotFound): exitNotFound,
+	} {
+		assert.Equal(t, want, exitCode(err), "%v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+)
+
+func runRepair(e *env, args []string) int {
+	fs := newFlagSet("repair", "", e)
+	dir := fs.String("dir", kv.DefaultDBPath, "database directory")
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+
+	report, err := log.Repair(*dir)
+	if err != nil {
+		return e.fail("repair", err)
+	}
+
+	if _, err := report.WriteTo(e.stdout); err != nil {
+		return e.fail("repair", err)
+	}
+
+	if err := report.Err(); err != nil {
+		return e.fail("repair", err)
+	}
+
+	return exitOK
+}
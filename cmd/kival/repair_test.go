@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepair_SalvagesRecords(t *testing.T) {
+	dir := t.TempDir()
+	for _, k := range []string{"k1", "k2", "k3"} {
+		mustKival(t, "", "put", "--dir", dir, k, "v"+k)
+	}
+
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	data[log.SegmentHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	stdout := mustKival(t, "", "repair", "--dir", dir)
+	assert.Contains(t, stdout, "log file 1: 2 records kept, 2 of them recovered after damage")
+
+	code, _, _ := kival("", "get", "--dir", dir, "k1")
+	assert.Equal(t, exitNotFound, code)
+	for _, k := range []string{"k2", "k3"} {
+		assert.Equal(t, "v"+k, mustKival(t, "", "get", "--dir", dir, k))
+	}
+}
+
+func TestRepair_UnreadableHeader(t *testing.T) {
+	dir := t.TempDir()
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1")
+
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	data[10] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	code, stdout, _ := kival("", "repair", "--dir", dir)
+	assert.Equal(t, exitCorrupt, code)
+	assert.Contains(t, stdout, "log file 1: not repaired")
+}
+
+func TestRepair_LockedDatabase(t *testing.T) {
+	dir := t.TempDir()
+	db, err := kv.New(dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	code, _, stderr := kival("", "repair", "--dir", dir)
+	assert.Equal(t, exitLocked, code)
+	assert.Contains(t, stderr, "locked")
+}
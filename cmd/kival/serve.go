@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/1garo/kival/server"
+)
+
+// defaultServeAddr is where serve listens by default, next to the 6379 of
+// Redis so both can run on one machine. Only local clients reach it.
+const defaultServeAddr = "127.0.0.1:6380"
+
+// shutdownTimeout bounds how long serve waits for HTTP requests in flight when
+// it stops.
+const shutdownTimeout = 10 * time.Second
+
+// protocol is a protocol serve speaks, on its own address.
+type protocol struct {
+	name  string
+	addr  string
+	serve func(net.Listener) error
+	l     net.Listener
+}
+
+func runServe(e *env, args []string) int {
+	var db dbFlags
+	fs := newFlagSet("serve", "", e)
+	db.register(fs, true)
+	addr := fs.String("addr", defaultServeAddr, "address to serve the Redis protocol on, empty to turn it off")
+	httpAddr := fs.String("http", "", "address to serve the HTTP API on, empty to turn it off")
+	memcachedAddr := fs.String("memcached", "", "address to serve the memcached protocol on, empty to turn it off")
+	if code, stop := parseFlags(fs, args); stop {
+		return code
+	}
+	if *addr == "" && *httpAddr == "" && *memcachedAddr == "" {
+		fmt.Fprintln(e.stderr, "kival serve: nothing to serve, -addr, -http and -memcached are all empty")
+		return exitUsage
+	}
+
+	store, err := db.open(false)
+	if err != nil {
+		return e.fail("serve", err)
+	}
+
+	logger := slog.New(slog.NewTextHandler(e.stderr, nil))
+	srv, err := server.New(store, server.WithLogger(logger))
+	if err != nil {
+		_ = store.Close()
+		return e.fail("serve", err)
+	}
+
+	hs := &http.Server{
+		Handler:           srv.HTTPHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelDebug),
+	}
+
+	protocols := []*protocol{
+		{name: "redis", addr: *addr, serve: srv.Serve},
+		{name: "http", addr: *httpAddr, serve: hs.Serve},
+		{name: "memcached", addr: *memcachedAddr, serve: srv.ServeMemcached},
+	}
+
+	// listen on every address before serving any, so a taken one fails early
+	for _, p := range protocols {
+		if p.addr == "" {
+			continue
+		}
+
+		if p.l, err = net.Listen("tcp", p.addr); err != nil {
+			for _, p := range protocols {
+				if p.l != nil {
+					_ = p.l.Close()
+				}
+			}
+			_ = store.Close()
+			return e.fail("serve", err)
+		}
+	}
+
+	served := make(chan error, len(protocols))
+	running := 0
+	for _, p := range protocols {
+		if p.l == nil {
+			continue
+		}
+
+		fmt.Fprintf(e.stdout, "serving %s on %s\n", p.name, p.l.Addr())
+		running++
+		go func() { served <- p.serve(p.l) }()
+	}
+
+	select {
+	case <-e.ctx.Done():
+	case err = <-served:
+		running--
+	}
+
+	// requests in flight finish before the db closes
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	shutdownErr := hs.Shutdown(ctx)
+	if shutdownErr != nil {
+		_ = hs.Close()
+	}
+	errs := []error{err, shutdownErr, srv.Close()}
+	for ; running > 0; running-- {
+		errs = append(errs, <-served)
+	}
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, server.ErrServerClosed) && !errors.Is(err, http.ErrServerClosed) {
+			_ = store.Close()
+			return e.fail("serve", err)
+		}
+	}
+
+	if err := store.Close(); err != nil {
+		return e.fail("serve", err)
+	}
+
+	return exitOK
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServe runs kival serve with args until the test stops it. It returns
+// the address of each protocol serve announces, like "redis", and a function
+// that stops serve and returns its exit code.
+func startServe(t *testing.T, protocols []string, args ...string) (map[string]string, func() int) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stdout, w := io.Pipe()
+	var stderr bytes.Buffer
+
+	exited := make(chan int, 1)
+	go func() {
+		exited <- run(append([]string{"serve"}, args...), &env{ctx: ctx, stdin: strings.NewReader(""), stdout: w, stderr: &stderr})
+		_ = w.Close()
+	}()
+
+	// serving <protocol> on <addr>
+	addrs := make(map[string]string)
+	r := bufio.NewReader(stdout)
+	for range protocols {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			cancel()
+			require.FailNow(t, "serve exited", "exit code %d: %s", <-exited, stderr.String())
+		}
+		fields := strings.Fields(line)
+		require.Len(t, fields, 4, line)
+		addrs[fields[1]] = fields[3]
+	}
+	for _, p := range protocols {
+		require.Contains(t, addrs, p)
+	}
+
+	return addrs, func() int {
+		cancel()
+		return <-exited
+	}
+}
+
+func TestServe(t *testing.T) {
+	dir := t.TempDir()
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1")
+
+	addrs, stop := startServe(t, []string{"redis"}, "--dir", dir, "--addr", "127.0.0.1:0")
+
+	nc, err := net.Dial("tcp", addrs["redis"])
+	require.NoError(t, err)
+	defer nc.Close()
+	require.NoError(t, nc.SetDeadline(time.Now().Add(10*time.Second)))
+
+	_, err = io.WriteString(nc, "GET k1\r\nSET k2 v2\r\n")
+	require.NoError(t, err)
+	r := bufio.NewReader(nc)
+	for _, want := range []string{"$2\r\n", "v1\r\n", "+OK\r\n"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+
+	code, _, _ := kival("", "get", "--dir", dir, "k1")
+	assert.Equal(t, exitLocked, code, "serve holds the database")
+
+	assert.Equal(t, exitOK, stop())
+	assert.Equal(t, "v2", mustKival(t, "", "get", "--dir", dir, "k2"))
+}
+
+func TestServe_HTTP(t *testing.T) {
+	dir := t.TempDir()
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1")
+
+	addrs, stop := startServe(t, []string{"http"}, "--dir", dir, "--addr", "", "--http", "127.0.0.1:0")
+	base := "http://" + addrs["http"]
+
+	resp, err := http.Get(base + "/v1/keys/k1")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "v1", string(body))
+
+	req, err := http.NewRequest(http.MethodPut, base+"/v1/keys/k2", strings.NewReader("v2"))
+	require.NoError(t, err)
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	assert.Equal(t, exitOK, stop())
+	assert.Equal(t, "v2", mustKival(t, "", "get", "--dir", dir, "k2"))
+}
+
+func TestServe_Memcached(t *testing.T) {
+	dir := t.TempDir()
+	addrs, stop := startServe(t, []string{"memcached"}, "--dir", dir, "--addr", "", "--memcached", "127.0.0.1:0")
+
+	nc, err := net.Dial("tcp", addrs["memcached"])
+	require.NoError(t, err)
+	defer nc.Close()
+	require.NoError(t, nc.SetDeadline(time.Now().Add(10*time.Second)))
+
+	_, err = io.WriteString(nc, "set k1 3 0 2\r\nv1\r\nget k1\r\n")
+	require.NoError(t, err)
+	r := bufio.NewReader(nc)
+	for _, want := range []string{"STORED\r\n", "VALUE k1 3 2\r\n", "v1\r\n", "END\r\n"} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+
+	assert.Equal(t, exitOK, stop())
+}
+
+func TestServe_All(t *testing.T) {
+	addrs, stop := startServe(t, []string{"redis", "http", "memcached"}, "--dir", t.TempDir(),
+		"--addr", "127.0.0.1:0", "--http", "127.0.0.1:0", "--memcached", "127.0.0.1:0")
+	assert.Len(t, addrs, 3)
+	assert.NotEqual(t, addrs["redis"], addrs["http"])
+	assert.Equal(t, exitOK, stop())
+}
+
+func TestServe_NothingToServe(t *testing.T) {
+	code, _, stderr := kival("", "serve", "--dir", t.TempDir(), "--addr", "")
+	assert.Equal(t, exitUsage, code)
+	assert.Contains(t, stderr, "nothing to serve")
+}
+
+func TestServe_AddressInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	dir := t.TempDir()
+	code, _, stderr := kival("", "serve", "--dir", dir, "--addr", "127.0.0.1:0", "--http", l.Addr().String())
+	assert.Equal(t, exitError, code)
+	assert.Contains(t, stderr, "kival serve:")
+
+	mustKival(t, "", "put", "--dir", dir, "k1", "v1") // the database was closed
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	db, err := kv.New(kv.DefaultDBPath, log.WithSyncEveryN(100))
+	db, err := kv.New(kv.DefaultDBPath, kv.WithLogOptions(log.WithSyncEveryN(100)))
 	if err != nil {
 		l.Fatalf("failed to open the db: %v", err)
 	}
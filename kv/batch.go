@@ -0,0 +1,133 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+// Batch collects puts and deletes to be applied atomically by KV.Write.
+// The zero value is an empty batch ready to use.
+type Batch struct {
+	entries    []log.Entry
+	invalidTTL bool
+	emptyValue bool
+}
+
+// Put adds a write of key to the batch, key and val are copied.
+// An empty val makes KV.Write fail with ErrEmptyValue, use Delete instead.
+func (b *Batch) Put(key, val []byte) {
+	if len(val) == 0 {
+		b.emptyValue = true
+	}
+
+	b.entries = append(b.entries, log.Entry{
+		Key:   append([]byte(nil), key...),
+		Value: append([]byte(nil), val...),
+	})
+}
+
+// PutWithTTL adds a write of key that expires after ttl, key and val are copied.
+// A ttl that is not positive makes KV.Write fail with ErrInvalidTTL and an
+// empty val with ErrEmptyValue.
+func (b *Batch) PutWithTTL(key, val []byte, ttl time.Duration) {
+	if len(val) == 0 {
+		b.emptyValue = true
+	}
+
+	expiry := uint32(0)
+	if ttl > 0 {
+		expiry = record.Expiry(time.Now().Add(ttl))
+	} else {
+		b.invalidTTL = true
+	}
+
+	b.entries = append(b.entries, log.Entry{
+		Key:    append([]byte(nil), key...),
+		Value:  append([]byte(nil), val...),
+		Expiry: expiry,
+	})
+}
+
+// Delete adds a delete of key to the batch, key is copied.
+func (b *Batch) Delete(key []byte) {
+	b.entries = append(b.entries, log.Entry{
+		Key: append([]byte(nil), key...),
+	})
+}
+
+// Len returns the number of operations in the batch.
+func (b *Batch) Len() int {
+	return len(b.entries)
+}
+
+// Reset empties the batch so it can be reused.
+func (b *Batch) Reset() {
+	b.entries = b.entries[:0]
+	b.invalidTTL = false
+	b.emptyValue = false
+}
+
+// Write applies every operation of the batch in order. The batch is written
+// to a single log file with one write, so after a crash either all of it or
+// none of it is recovered.
+func (m *kv) Write(b *Batch) error {
+	defer m.publish()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	if b.invalidTTL {
+		return ErrInvalidTTL
+	}
+
+	if b.emptyValue {
+		return ErrEmptyValue
+	}
+
+	if b.Len() == 0 {
+		return nil
+	}
+
+	positions, err := m.activeLog.AppendBatch(b.entries)
+	if err != nil {
+		if !errors.Is(err, log.ErrCapacityExceeded) {
+			return fmt.Errorf("cannot append batch into db: %w", err)
+		}
+
+		// never split a batch across log files, start a new one instead
+		if err := m.rotate(); err != nil {
+			return err
+		}
+
+		positions, err = m.activeLog.AppendBatch(b.entries)
+		if err != nil {
+			return fmt.Errorf("failed to append batch to rotated log: %w", err)
+		}
+	}
+
+	// a batch is a single write already, it has nothing to share a sync with
+	if m.groupCommit {
+		if err := m.activeLog.Sync(); err != nil {
+			return err
+		}
+	}
+
+	for i, e := range b.entries {
+		m.notify(e, positions[i])
+		if len(e.Value) == 0 {
+			m.deleteKey(string(e.Key))
+			continue
+		}
+
+		m.setKey(string(e.Key), positions[i])
+	}
+
+	return nil
+}
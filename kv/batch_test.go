@@ -0,0 +1,274 @@
+package kv_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lastDataFile returns the path of the data file with the highest id.
+func lastDataFile(t *testing.T, dir string) string {
+	t.Helper()
+
+	files := listDataFiles(dir)
+	require.NotEmpty(t, files)
+	sort.Slice(files, func(i, j int) bool {
+		var a, b int
+		fmt.Sscanf(files[i], "%d.data", &a)
+		fmt.Sscanf(files[j], "%d.data", &b)
+		return a < b
+	})
+	return filepath.Join(dir, files[len(files)-1])
+}
+
+func TestKV_Write_AppliesAllOps(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	require.NoError(t, db.Put([]byte("old"), []byte("value")))
+
+	var b kv.Batch
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k2"), []byte("v2"))
+	b.Delete([]byte("old"))
+	b.Put([]byte("k1"), []byte("v1-updated"))
+	require.Equal(t, 4, b.Len())
+
+	require.NoError(t, db.Write(&b))
+
+	val, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1-updated", string(val))
+
+	val, err = db.Get([]byte("k2"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(val))
+
+	_, err = db.Get([]byte("old"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+}
+
+func TestKV_Write_CopiesInput(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	key := []byte("k1")
+	val := []byte("v1")
+	var b kv.Batch
+	b.Put(key, val)
+	val[0] = 'x'
+	key[0] = 'x'
+
+	require.NoError(t, db.Write(&b))
+
+	got, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(got))
+}
+
+func TestKV_Write_EmptyBatch(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	var b kv.Batch
+	assert.NoError(t, db.Write(&b))
+}
+
+func TestKV_Write_Persistence(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	var b kv.Batch
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k2"), []byte("v2"))
+	require.NoError(t, db.Write(&b))
+
+	b.Reset()
+	b.Delete([]byte("k1"))
+	require.NoError(t, db.Write(&b))
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	_, err = reopened.Get([]byte("k1"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+
+	val, err := reopened.Get([]byte("k2"))
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(val))
+}
+
+// assertTornBatchDropped cuts the batch at the end of path at every possible
+// length and checks that reopening recovers none of it.
+func assertTornBatchDropped(t *testing.T, dir, path string, batchStart int64, batchKeys, keptKeys []string) {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	for cut := batchStart + 1; cut < int64(len(data)); cut++ {
+		require.NoError(t, os.WriteFile(path, data[:cut], 0o644))
+
+		db, err := openKV(dir)
+		require.NoError(t, err)
+
+		for _, k := range batchKeys {
+			_, err := db.Get([]byte(k))
+			assert.ErrorIs(t, err, kv.ErrKeyNotFound, "cut at %d: %s should not be recovered", cut, k)
+		}
+		for _, k := range keptKeys {
+			_, err := db.Get([]byte(k))
+			assert.NoError(t, err, "cut at %d: %s should be recovered", cut, k)
+		}
+
+		require.NoError(t, db.Close())
+	}
+}
+
+func TestKV_Write_TornBatchIsDropped(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("before"), []byte("value")))
+
+	path := lastDataFile(t, dir)
+	stat, err := os.Stat(path)
+	require.NoError(t, err)
+
+	var b kv.Batch
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k2"), []byte("v2"))
+	b.Put([]byte("k3"), []byte("v3"))
+	require.NoError(t, db.Write(&b))
+	require.NoError(t, db.Close())
+
+	assertTornBatchDropped(t, dir, path, stat.Size(), []string{"k1", "k2", "k3"}, []string{"before"})
+}
+
+func TestKV_Write_RotatesInsteadOfSplitting(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	// leave less room in the active log than the batch needs
+	filler := bytes.Repeat([]byte("x"), testSegmentSize-200)
+	require.NoError(t, db.Put([]byte("filler"), filler))
+
+	var b kv.Batch
+	val := bytes.Repeat([]byte("v"), 100)
+	b.Put([]byte("k1"), val)
+	b.Put([]byte("k2"), val)
+	b.Put([]byte("k3"), val)
+	require.NoError(t, db.Write(&b))
+
+	files := listDataFiles(dir)
+	require.Len(t, files, 2, "batch should start a new log file")
+	require.NoError(t, db.Close())
+
+	assertTornBatchDropped(t, dir, lastDataFile(t, dir), log.SegmentHeaderSize, []string{"k1", "k2", "k3"}, []string{"filler"})
+}
+
+func TestKV_Write_LargerThanLogFile(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("before"), []byte("value")))
+
+	var b kv.Batch
+	val := bytes.Repeat([]byte("v"), testSegmentSize/2)
+	b.Put([]byte("k1"), val)
+	b.Put([]byte("k2"), val)
+	b.Put([]byte("k3"), val)
+	require.NoError(t, db.Write(&b))
+	assert.Len(t, listDataFiles(dir), 2, "the batch should get a log file of its own")
+
+	for _, k := range []string{"k1", "k2", "k3"} {
+		got, err := db.Get([]byte(k))
+		require.NoError(t, err)
+		assert.Equal(t, val, got)
+	}
+	require.NoError(t, db.Close())
+
+	assertTornBatchDropped(t, dir, lastDataFile(t, dir), log.SegmentHeaderSize, []string{"k1", "k2", "k3"}, []string{"before"})
+}
+
+func TestKV_Write_PutWithTTL(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	var b kv.Batch
+	b.PutWithTTL([]byte("k1"), []byte("v1"), time.Hour)
+	require.NoError(t, db.Write(&b))
+
+	val, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val))
+
+	b.Reset()
+	b.Put([]byte("k2"), []byte("v2"))
+	b.PutWithTTL([]byte("k3"), []byte("v3"), 0)
+	assert.ErrorIs(t, db.Write(&b), kv.ErrInvalidTTL)
+
+	_, err = db.Get([]byte("k2"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound, "nothing should be applied")
+}
+
+func TestKV_Write_EmptyValue(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+
+	var b kv.Batch
+	b.Put([]byte("k2"), []byte("v2"))
+	b.Put([]byte("k1"), nil)
+	assert.ErrorIs(t, db.Write(&b), kv.ErrEmptyValue)
+
+	b.Reset()
+	b.PutWithTTL([]byte("k1"), nil, time.Hour)
+	assert.ErrorIs(t, db.Write(&b), kv.ErrEmptyValue)
+
+	val, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val), "nothing should be applied")
+	_, err = db.Get([]byte("k2"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+}
+
+func TestKV_Write_ClosedDB(t *testing.T) {
+	db, err := openKV(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	var b kv.Batch
+	b.Put([]byte("k1"), []byte("v1"))
+	assert.ErrorIs(t, db.Write(&b), kv.ErrClosed)
+}
+
+func TestKV_Del_RotatesWhenLogIsFull(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestKV(t, dir)
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+
+	// leave less room than a tombstone needs
+	used := log.SegmentHeaderSize + int(record.HeaderSize)*2 + len("k1") + len("v1") + len("filler")
+	filler := bytes.Repeat([]byte("x"), testSegmentSize-used-5)
+	require.NoError(t, db.Put([]byte("filler"), filler))
+
+	require.NoError(t, db.Del([]byte("k1")))
+	assert.Len(t, listDataFiles(dir), 2, "tombstone should go to a new log file")
+
+	_, err := db.Get([]byte("k1"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+}
@@ -0,0 +1,52 @@
+package kv_test
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+)
+
+// BenchmarkPut_Concurrent compares the sync strategies with many writers
+// putting at once. Writers only overlap when GOMAXPROCS is above 1, so on a
+// single CPU run it with -cpu 4 or so to see group commit at work.
+func BenchmarkPut_Concurrent(b *testing.B) {
+	strategies := []struct {
+		name string
+		opts []log.Option
+	}{
+		{"Always", []log.Option{log.WithSyncStrategy(log.Always)}},
+		{"EveryN", []log.Option{log.WithSyncStrategy(log.EveryN), log.WithSyncEveryN(100)}},
+		{"GroupCommit", []log.Option{log.WithSyncStrategy(log.GroupCommit)}},
+		{"Interval", []log.Option{log.WithSyncStrategy(log.Interval), log.WithSyncInterval(10 * time.Millisecond)}},
+		{"Never", []log.Option{log.WithSyncStrategy(log.Never)}},
+	}
+
+	val := make([]byte, 128)
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			db, err := kv.New(b.TempDir(), kv.WithLogOptions(s.opts...))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+
+			var n atomic.Int64
+			b.SetParallelism(8)
+			b.SetBytes(int64(len(val)))
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					key := fmt.Appendf(nil, "key%d", n.Add(1))
+					if err := db.Put(key, val); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
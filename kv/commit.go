@@ -0,0 +1,169 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+// commitRequest is a Put or a Del waiting in the commit queue.
+type commitRequest struct {
+	entry  log.Entry
+	del    bool // fails with ErrKeyNotFound when the key is missing
+	result chan commitResult
+}
+
+type commitResult struct {
+	err  error
+	lead bool // the request heads the queue and has to commit it
+}
+
+// commitQueue lets concurrent writers share a write and a sync when the log
+// uses log.GroupCommit.
+//
+// The first writer to find the queue idle leads: it takes every request
+// queued so far, writes them at once, syncs, updates the index and answers
+// them. Writers arriving meanwhile queue up behind it, and the leader hands
+// over to the first of them once it is done.
+type commitQueue struct {
+	mu      sync.Mutex
+	pending []*commitRequest
+	leading bool
+}
+
+// commit writes e through the commit queue and returns once it is on disk.
+func (m *kv) commit(e log.Entry, del bool) error {
+	if len(e.Key) == 0 {
+		// one bad entry would fail the write of the whole group
+		return fmt.Errorf("cannot append encoded data into db: %w", record.ErrEncodeInput)
+	}
+
+	req := &commitRequest{entry: e, del: del, result: make(chan commitResult, 1)}
+
+	q := &m.commits
+	q.mu.Lock()
+	q.pending = append(q.pending, req)
+	lead := !q.leading
+	q.leading = true
+	q.mu.Unlock()
+
+	if !lead {
+		res := <-req.result
+		if !res.lead {
+			return res.err
+		}
+	}
+
+	q.mu.Lock()
+	group := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	m.commitGroup(group)
+	m.publish()
+
+	q.mu.Lock()
+	if len(q.pending) > 0 {
+		q.pending[0].result <- commitResult{lead: true}
+	} else {
+		q.leading = false
+	}
+	q.mu.Unlock()
+
+	return (<-req.result).err
+}
+
+// commitGroup appends the entries of group with one write and one sync, and
+// only then points the index at them, so readers never see a value that is
+// not on disk yet.
+func (m *kv) commitGroup(group []*commitRequest) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]error, len(group))
+	defer func() {
+		for i, req := range group {
+			req.result <- commitResult{err: errs[i]}
+		}
+	}()
+
+	if m.closed {
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+		return
+	}
+
+	// a delete is only written when its key exists at that point of the
+	// group, earlier requests of the group included.
+	now := time.Now()
+	exists := make(map[string]bool)
+	var entries []log.Entry
+	var written []int
+	for i, req := range group {
+		key := string(req.entry.Key)
+		if req.del {
+			found, ok := exists[key]
+			if !ok {
+				pos, inIndex := m.keyDir.Get(key)
+				found = inIndex && !pos.Expired(now)
+			}
+			if !found {
+				errs[i] = ErrKeyNotFound
+				continue
+			}
+		}
+
+		exists[key] = !req.del
+		entries = append(entries, req.entry)
+		written = append(written, i)
+	}
+
+	if len(entries) == 0 {
+		return
+	}
+
+	positions, err := m.appendEntries(entries)
+	if err == nil {
+		err = m.activeLog.Sync()
+	}
+	if err != nil {
+		for _, i := range written {
+			errs[i] = err
+		}
+		return
+	}
+
+	for j, i := range written {
+		m.notify(group[i].entry, positions[j])
+		key := string(group[i].entry.Key)
+		if group[i].del {
+			m.deleteKey(key)
+			continue
+		}
+
+		m.setKey(key, positions[j])
+	}
+}
+
+// appendEntries appends entries to the active log with a single write,
+// rotating it first when they do not fit.
+func (m *kv) appendEntries(entries []log.Entry) ([]log.LogPosition, error) {
+	positions, err := m.activeLog.AppendEntries(entries)
+	if errors.Is(err, log.ErrCapacityExceeded) {
+		if err := m.rotate(); err != nil {
+			return nil, err
+		}
+
+		positions, err = m.activeLog.AppendEntries(entries)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot append encoded data into db: %w", err)
+	}
+
+	return positions, nil
+}
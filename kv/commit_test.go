@@ -0,0 +1,144 @@
+package kv_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withGroupCommit() kv.Option {
+	return kv.WithLogOptions(log.WithSyncStrategy(log.GroupCommit))
+}
+
+func newGroupCommitKV(t *testing.T, dir string) kv.KV {
+	t.Helper()
+
+	db, err := openKV(dir, withGroupCommit())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestKV_GroupCommit_ConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openKV(dir, withGroupCommit())
+	require.NoError(t, err)
+
+	const writers = 8
+	const rounds = 40
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Appendf(nil, "w%d-key%02d", w, i)
+				if !assert.NoError(t, db.Put(key, fmt.Appendf(nil, "w%d-val%02d", w, i))) {
+					return
+				}
+
+				// a put is visible as soon as it returns
+				got, err := db.Get(key)
+				if assert.NoError(t, err) {
+					assert.Equal(t, fmt.Sprintf("w%d-val%02d", w, i), string(got))
+				}
+
+				if i%5 == 0 {
+					assert.NoError(t, db.Del(key))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	require.NoError(t, db.Close())
+	assert.Greater(t, len(listDataFiles(dir)), 1, "groups should rotate the log like single writes")
+
+	reopened := newTestKV(t, dir)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < rounds; i++ {
+			key := fmt.Sprintf("w%d-key%02d", w, i)
+			got, err := reopened.Get([]byte(key))
+			if i%5 == 0 {
+				assert.ErrorIs(t, err, kv.ErrKeyNotFound, key)
+				continue
+			}
+			require.NoError(t, err, key)
+			assert.Equal(t, fmt.Sprintf("w%d-val%02d", w, i), string(got))
+		}
+	}
+}
+
+func TestKV_GroupCommit_DelMissingKey(t *testing.T) {
+	db := newGroupCommitKV(t, t.TempDir())
+
+	assert.ErrorIs(t, db.Del([]byte("missing")), kv.ErrKeyNotFound)
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+	require.NoError(t, db.Del([]byte("k1")))
+	assert.ErrorIs(t, db.Del([]byte("k1")), kv.ErrKeyNotFound)
+
+	_, err := db.Get([]byte("k1"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+}
+
+func TestKV_GroupCommit_InvalidEntryFailsAlone(t *testing.T) {
+	db := newGroupCommitKV(t, t.TempDir())
+
+	var wg sync.WaitGroup
+	errs := make([]error, 10)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Appendf(nil, "k%d", i)
+			if i == 0 {
+				key = nil
+			}
+			errs[i] = db.Put(key, []byte("v"))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.ErrorIs(t, errs[0], record.ErrEncodeInput)
+	for i := 1; i < len(errs); i++ {
+		require.NoError(t, errs[i])
+		_, err := db.Get(fmt.Appendf(nil, "k%d", i))
+		assert.NoError(t, err)
+	}
+}
+
+func TestKV_GroupCommit_PutWithTTLAndBatch(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openKV(dir, withGroupCommit())
+	require.NoError(t, err)
+
+	require.NoError(t, db.PutWithTTL([]byte("ttl"), []byte("v"), time.Hour))
+
+	var b kv.Batch
+	b.Put([]byte("b1"), []byte("v1"))
+	b.Put([]byte("b2"), []byte("v2"))
+	require.NoError(t, db.Write(&b))
+	require.NoError(t, db.Close())
+
+	reopened := newTestKV(t, dir)
+	for _, k := range []string{"ttl", "b1", "b2"} {
+		_, err := reopened.Get([]byte(k))
+		assert.NoError(t, err, k)
+	}
+}
+
+func TestKV_GroupCommit_ClosedDB(t *testing.T) {
+	db, err := openKV(t.TempDir(), withGroupCommit())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	assert.ErrorIs(t, db.Put([]byte("k1"), []byte("v1")), kv.ErrClosed)
+	assert.ErrorIs(t, db.Del([]byte("k1")), kv.ErrClosed)
+}
@@ -0,0 +1,229 @@
+package kv
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const defaultCompactionMinSegments = 4
+
+var ErrInvalidCompaction = errors.New("invalid compaction option")
+
+// compactionConfig holds the triggers of the background compactor.
+type compactionConfig struct {
+	interval    time.Duration
+	minSegments int
+	deadRatio   float64
+}
+
+// WithCompaction starts a background compactor that checks every interval
+// whether a merge is due. See WithCompactionMinSegments and
+// WithCompactionDeadRatio for the triggers, when none is set it merges every
+// 4 sealed log files.
+func WithCompaction(interval time.Duration) Option {
+	return func(m *kv) error {
+		if interval <= 0 {
+			return fmt.Errorf("%w: interval must be positive, got %s", ErrInvalidCompaction, interval)
+		}
+
+		m.compaction.interval = interval
+		return nil
+	}
+}
+
+// WithCompactionMinSegments makes the background compactor merge every sealed
+// log file once n of them were sealed since the last merge. New fails with
+// ErrInvalidCompaction when it is given without WithCompaction.
+func WithCompactionMinSegments(n int) Option {
+	return func(m *kv) error {
+		if n <= 0 {
+			return fmt.Errorf("%w: min segments must be positive, got %d", ErrInvalidCompaction, n)
+		}
+
+		m.compaction.minSegments = n
+		return nil
+	}
+}
+
+// WithCompactionDeadRatio makes the background compactor merge once dead
+// records take at least ratio of a sealed log file, see SegmentStats. The log
+// files before it are merged too. Like WithCompactionMinSegments, it needs
+// WithCompaction.
+func WithCompactionDeadRatio(ratio float64) Option {
+	return func(m *kv) error {
+		if ratio <= 0 || ratio > 1 {
+			return fmt.Errorf("%w: dead ratio must be in (0, 1], got %v", ErrInvalidCompaction, ratio)
+		}
+
+		m.compaction.deadRatio = ratio
+		return nil
+	}
+}
+
+// validate makes sure the triggers come with a compactor to fire them.
+func (c compactionConfig) validate() error {
+	if c.interval == 0 && (c.minSegments != 0 || c.deadRatio != 0) {
+		return fmt.Errorf("%w: triggers are set without WithCompaction", ErrInvalidCompaction)
+	}
+
+	return nil
+}
+
+// compactor runs Merge in its own goroutine whenever a trigger fires.
+type compactor struct {
+	db  *kv
+	cfg compactionConfig
+
+	// mu is held for a whole run, so Pause waits for a running merge
+	mu     sync.Mutex
+	paused bool
+	since  uint32 // log files from this id on were sealed after the last merge
+	next   uint32 // since, once the running merge is over
+
+	kick     chan chan error
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func newCompactor(db *kv, cfg compactionConfig) *compactor {
+	if cfg.minSegments == 0 && cfg.deadRatio == 0 {
+		cfg.minSegments = defaultCompactionMinSegments
+	}
+
+	c := &compactor{
+		db:      db,
+		cfg:     cfg,
+		kick:    make(chan chan error),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+
+	go c.loop()
+	return c
+}
+
+func (c *compactor) loop() {
+	defer close(c.stopped)
+
+	ticker := time.NewTicker(c.cfg.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			// a failed run is retried on the next tick
+			_ = c.run()
+		case res := <-c.kick:
+			res <- c.run()
+		}
+	}
+}
+
+// run merges if a trigger fired and the compactor is not paused.
+func (c *compactor) run() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused {
+		return nil
+	}
+
+	c.next = c.since
+	if err := c.db.merge(c.cutoff); err != nil {
+		return err
+	}
+
+	c.since = c.next
+	return nil
+}
+
+// cutoff picks the last sealed log file to merge, or 0 when no trigger fired.
+func (c *compactor) cutoff(stats []SegmentStats) uint32 {
+	var active, last, dirty, sealedSince uint32
+	for _, s := range stats {
+		if s.Active {
+			active = s.ID
+			continue
+		}
+
+		last = s.ID
+		if s.ID >= c.since {
+			sealedSince++
+		}
+		if c.cfg.deadRatio > 0 && s.DeadRatio() >= c.cfg.deadRatio {
+			dirty = s.ID
+		}
+	}
+
+	if c.cfg.minSegments > 0 && sealedSince >= uint32(c.cfg.minSegments) {
+		c.next = active
+		return last
+	}
+
+	return dirty
+}
+
+func (c *compactor) pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = true
+}
+
+func (c *compactor) resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = false
+}
+
+// wait runs the compactor now and returns once that run is over.
+func (c *compactor) wait() error {
+	res := make(chan error, 1)
+	select {
+	case c.kick <- res:
+		return <-res
+	case <-c.stopped:
+		return ErrClosed
+	}
+}
+
+// stop ends the compactor goroutine, waiting for a running merge.
+func (c *compactor) stop() {
+	c.stopOnce.Do(func() {
+		close(c.done)
+	})
+	<-c.stopped
+}
+
+// PauseCompaction stops the background compactor from starting new merges,
+// it returns once a running one is over. It does nothing when compaction is
+// not enabled.
+func (m *kv) PauseCompaction() {
+	if m.compactor != nil {
+		m.compactor.pause()
+	}
+}
+
+// ResumeCompaction lets a paused background compactor merge again.
+func (m *kv) ResumeCompaction() {
+	if m.compactor != nil {
+		m.compactor.resume()
+	}
+}
+
+// WaitCompaction checks the compaction triggers right away and returns once
+// the merge they started, if any, is over. It does nothing when compaction is
+// not enabled or paused.
+func (m *kv) WaitCompaction() error {
+	if m.compactor == nil {
+		return nil
+	}
+
+	return m.compactor.wait()
+}
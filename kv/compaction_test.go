@@ -0,0 +1,228 @@
+package kv_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCompactingKV(t *testing.T, dir string, opts ...kv.Option) kv.KV {
+	t.Helper()
+
+	db, err := openKV(dir, opts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = db.Close() })
+	return db
+}
+
+func TestCompaction_MergesSealedSegments(t *testing.T) {
+	dir := t.TempDir()
+	db := newCompactingKV(t, dir, kv.WithCompaction(time.Hour), kv.WithCompactionMinSegments(2))
+
+	forceRotation(db, 60)
+	filesBefore := listDataFiles(dir)
+	require.Len(t, filesBefore, 3)
+
+	require.NoError(t, db.WaitCompaction())
+	assert.Less(t, len(listDataFiles(dir)), len(filesBefore))
+
+	keys := collectKeys(t, db.ScanPrefix([]byte("key")))
+	assert.Len(t, keys, 26)
+}
+
+func TestCompaction_WaitsForEnoughSegments(t *testing.T) {
+	dir := t.TempDir()
+	db := newCompactingKV(t, dir, kv.WithCompaction(time.Hour), kv.WithCompactionMinSegments(3))
+
+	forceRotation(db, 60)
+	require.NoError(t, db.WaitCompaction())
+	assert.Len(t, listDataFiles(dir), 3, "two sealed segments should not trigger a merge")
+
+	// the segments left by a merge do not count towards the next one
+	forceRotation(db, 50)
+	require.NoError(t, db.WaitCompaction())
+	filesAfter := listDataFiles(dir)
+	require.NoError(t, db.WaitCompaction())
+	assert.Equal(t, filesAfter, listDataFiles(dir))
+}
+
+func TestCompaction_RunsOnInterval(t *testing.T) {
+	dir := t.TempDir()
+	db := newCompactingKV(t, dir, kv.WithCompaction(10*time.Millisecond), kv.WithCompactionMinSegments(2))
+
+	db.PauseCompaction()
+	forceRotation(db, 60)
+	require.Len(t, listDataFiles(dir), 3)
+	db.ResumeCompaction()
+
+	assert.Eventually(t, func() bool {
+		return len(listDataFiles(dir)) < 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCompaction_PauseAndResume(t *testing.T) {
+	dir := t.TempDir()
+	db := newCompactingKV(t, dir, kv.WithCompaction(time.Hour), kv.WithCompactionMinSegments(2))
+
+	forceRotation(db, 60)
+
+	db.PauseCompaction()
+	require.NoError(t, db.WaitCompaction())
+	assert.Len(t, listDataFiles(dir), 3, "a paused compactor should not merge")
+
+	db.ResumeCompaction()
+	require.NoError(t, db.WaitCompaction())
+	assert.Less(t, len(listDataFiles(dir)), 3)
+}
+
+func TestCompaction_ReadsAndWritesDuringCompaction(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openKV(dir, kv.WithCompaction(time.Millisecond), kv.WithCompactionMinSegments(1))
+	require.NoError(t, err)
+
+	const writers = 4
+	const rounds = 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				key := fmt.Appendf(nil, "w%d-key%d", w, i%10)
+				val := fmt.Appendf(nil, "w%d-val%d-with-some-padding", w, i)
+				if !assert.NoError(t, db.Put(key, val)) {
+					return
+				}
+
+				got, err := db.Get(key)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, string(val), string(got))
+
+				if i%7 == 0 {
+					assert.NoError(t, db.Del(key))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	require.NoError(t, db.WaitCompaction())
+
+	want := make(map[string]string)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < rounds; i++ {
+			key := fmt.Sprintf("w%d-key%d", w, i%10)
+			if i%7 == 0 {
+				delete(want, key)
+				continue
+			}
+			want[key] = fmt.Sprintf("w%d-val%d-with-some-padding", w, i)
+		}
+	}
+	require.NoError(t, db.Close())
+
+	reopened := newTestKV(t, dir)
+	for w := 0; w < writers; w++ {
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("w%d-key%d", w, i)
+			val, err := reopened.Get([]byte(key))
+			if expected, ok := want[key]; ok {
+				require.NoError(t, err, key)
+				assert.Equal(t, expected, string(val))
+			} else {
+				assert.ErrorIs(t, err, kv.ErrKeyNotFound, key)
+			}
+		}
+	}
+}
+
+func TestCompaction_InvalidOptions(t *testing.T) {
+	_, err := openKV(t.TempDir(), kv.WithCompaction(0))
+	assert.ErrorIs(t, err, kv.ErrInvalidCompaction)
+
+	_, err = openKV(t.TempDir(), kv.WithCompaction(time.Second), kv.WithCompactionMinSegments(0))
+	assert.ErrorIs(t, err, kv.ErrInvalidCompaction)
+
+	_, err = openKV(t.TempDir(), kv.WithCompaction(time.Second), kv.WithCompactionDeadRatio(1.5))
+	assert.ErrorIs(t, err, kv.ErrInvalidCompaction)
+
+	_, err = openKV(t.TempDir(), kv.WithCompactionMinSegments(2))
+	assert.ErrorIs(t, err, kv.ErrInvalidCompaction, "a trigger needs a compactor")
+
+	_, err = openKV(t.TempDir(), kv.WithCompactionDeadRatio(0.5))
+	assert.ErrorIs(t, err, kv.ErrInvalidCompaction, "a trigger needs a compactor")
+}
+
+func TestCompaction_Disabled(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestKV(t, dir)
+
+	forceRotation(db, 60)
+	db.PauseCompaction()
+	db.ResumeCompaction()
+	require.NoError(t, db.WaitCompaction())
+	assert.Len(t, listDataFiles(dir), 3, "no compactor, no merge")
+}
+
+func TestCompaction_ClosedDB(t *testing.T) {
+	db, err := openKV(t.TempDir(), kv.WithCompaction(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	assert.ErrorIs(t, db.WaitCompaction(), kv.ErrClosed)
+}
+
+func TestCompaction_DeadRatioMergesDirtyPrefix(t *testing.T) {
+	dir := t.TempDir()
+	db := newCompactingKV(t, dir, kv.WithCompaction(time.Hour), kv.WithCompactionDeadRatio(0.5))
+
+	val := "a value long enough to fill a few log files"
+	for i := 0; i < 80; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "u%03d", i), []byte(val)))
+	}
+
+	// overwrite every key of the second log file
+	var rewrite []string
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	require.Greater(t, len(stats), 3)
+	it := db.ScanPrefix([]byte("u"))
+	for it.Next() {
+		rewrite = append(rewrite, string(it.Key()))
+	}
+	require.NoError(t, it.Err())
+	perFile := int(stats[0].Size / recordSize("u000", val))
+	for _, k := range rewrite[perFile : 2*perFile] {
+		require.NoError(t, db.Put([]byte(k), []byte("new")))
+	}
+
+	require.GreaterOrEqual(t, segmentStats(t, db, 2).DeadRatio(), 0.5)
+	third := segmentStats(t, db, 3)
+
+	require.NoError(t, db.WaitCompaction())
+
+	stats, err = db.Stats()
+	require.NoError(t, err)
+	for _, s := range stats {
+		if !s.Active {
+			assert.Less(t, s.DeadRatio(), 0.5, "log file %d should have been merged", s.ID)
+		}
+	}
+	assert.Equal(t, third, segmentStats(t, db, 3), "log files after the dirty one are left alone")
+
+	for i, k := range rewrite {
+		got, err := db.Get([]byte(k))
+		require.NoError(t, err)
+		if i >= perFile && i < 2*perFile {
+			assert.Equal(t, "new", string(got))
+		} else {
+			assert.Equal(t, val, string(got))
+		}
+	}
+}
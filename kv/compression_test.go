@@ -0,0 +1,155 @@
+package kv_test
+
+import (
+	"compress/flate"
+	"crypto/rand"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withFlate() kv.Option {
+	return kv.WithLogOptions(log.WithCompression(record.Flate))
+}
+
+func jsonValue(i int) string {
+	return fmt.Sprintf(`{"id":%d,"name":"user %d","tags":["a","b","c"],"active":true,"bio":"%s"}`,
+		i, i, strings.Repeat("lorem ipsum dolor sit amet ", 4))
+}
+
+// dataSize returns the bytes taken by records over every log file of db.
+func dataSize(t *testing.T, db kv.KV) int64 {
+	t.Helper()
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+
+	var size int64
+	for _, s := range stats {
+		size += s.Size
+	}
+	return size
+}
+
+func TestKV_Compression_StoresCompressedValues(t *testing.T) {
+	db, err := openKV(t.TempDir(), withFlate())
+	require.NoError(t, err)
+	defer db.Close()
+
+	val := jsonValue(1)
+	require.NoError(t, db.Put([]byte("user:1"), []byte(val)))
+
+	got, err := db.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, val, string(got))
+	assert.Less(t, dataSize(t, db), recordSize("user:1", val))
+}
+
+func TestKV_Compression_KeepsIncompressibleValues(t *testing.T) {
+	db, err := openKV(t.TempDir(), withFlate())
+	require.NoError(t, err)
+	defer db.Close()
+
+	val := make([]byte, 200)
+	_, err = rand.Read(val)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("random"), val))
+
+	got, err := db.Get([]byte("random"))
+	require.NoError(t, err)
+	assert.Equal(t, val, got)
+	assert.Equal(t, recordSize("random", string(val)), dataSize(t, db), "value should be stored as is")
+}
+
+func TestKV_Compression_MixedRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("plain"), []byte(jsonValue(1))))
+	require.NoError(t, db.Close())
+
+	db, err = openKV(dir, withFlate())
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("compressed"), []byte(jsonValue(2))))
+	require.NoError(t, db.Close())
+
+	// reading compressed values does not need the option
+	db = newTestKV(t, dir)
+	for i, k := range []string{"plain", "compressed"} {
+		got, err := db.Get([]byte(k))
+		require.NoError(t, err)
+		assert.Equal(t, jsonValue(i+1), string(got))
+	}
+}
+
+func TestKV_Merge_Recompresses(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	for i := 0; i < 40; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "user:%02d", i), []byte(jsonValue(i))))
+	}
+	require.NoError(t, db.Close())
+
+	db, err = openKV(dir, withFlate())
+	require.NoError(t, err)
+	defer db.Close()
+
+	before := dataSize(t, db)
+	require.NoError(t, db.Merge())
+	assert.Less(t, dataSize(t, db), before, "merged values should be compressed")
+
+	for i := 0; i < 40; i++ {
+		got, err := db.Get(fmt.Appendf(nil, "user:%02d", i))
+		require.NoError(t, err)
+		assert.Equal(t, jsonValue(i), string(got))
+	}
+}
+
+// bestSpeed is flate at its fastest level, registered under its own ID.
+type bestSpeed struct {
+	record.Codec
+}
+
+func (bestSpeed) ID() record.CodecID {
+	return 7
+}
+
+func TestKV_Compression_RegisteredCodec(t *testing.T) {
+	fast, err := record.NewFlate(flate.BestSpeed)
+	require.NoError(t, err)
+	codec := bestSpeed{fast}
+
+	_, err = openKV(t.TempDir(), kv.WithLogOptions(log.WithCompression(codec)))
+	require.ErrorIs(t, err, record.ErrUnknownCodec, "an unregistered codec could not read its values back")
+
+	require.NoError(t, record.RegisterCodec(codec))
+	dir := t.TempDir()
+	db, err := openKV(dir, kv.WithLogOptions(log.WithCompression(codec)))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("user:1"), []byte(jsonValue(1))))
+	require.NoError(t, db.Close())
+
+	db = newTestKV(t, dir)
+	got, err := db.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, jsonValue(1), string(got))
+}
+
+func TestKV_Compression_InvalidCodec(t *testing.T) {
+	_, err := openKV(t.TempDir(), kv.WithLogOptions(log.WithCompression(nil)))
+	assert.ErrorIs(t, err, record.ErrInvalidCodec)
+
+	_, err = record.NewFlate(42)
+	assert.ErrorIs(t, err, record.ErrInvalidCodec)
+
+	assert.ErrorIs(t, record.RegisterCodec(nil), record.ErrInvalidCodec)
+}
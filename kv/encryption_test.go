@@ -0,0 +1,230 @@
+package kv_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func newKeyring(t *testing.T, keys map[uint32][]byte) *record.Keyring {
+	t.Helper()
+
+	ring := record.NewKeyring()
+	for id, key := range keys {
+		require.NoError(t, ring.Add(id, key))
+	}
+	return ring
+}
+
+func withKeys(keys record.KeyProvider) kv.Option {
+	return kv.WithLogOptions(log.WithEncryption(keys))
+}
+
+// assertNoPlaintext checks that no file of dir holds any of the given strings.
+func assertNoPlaintext(t *testing.T, dir string, secrets ...string) {
+	t.Helper()
+
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, f.Name()))
+		require.NoError(t, err)
+		for _, s := range secrets {
+			assert.False(t, bytes.Contains(data, []byte(s)), "%s holds %q", f.Name(), s)
+		}
+	}
+}
+
+func TestKV_Encryption_StoresNoPlaintext(t *testing.T) {
+	dir := t.TempDir()
+	ring := newKeyring(t, map[uint32][]byte{1: testKey(1)})
+
+	db, err := openKV(dir, withKeys(ring))
+	require.NoError(t, err)
+	for i := 0; i < 60; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "secret-key-%02d", i), fmt.Appendf(nil, "secret-value-%02d", i)))
+	}
+	require.NoError(t, db.Del([]byte("secret-key-00")))
+	require.NoError(t, db.Close())
+
+	require.Greater(t, len(listDataFiles(dir)), 1, "hint files should be written too")
+	assertNoPlaintext(t, dir, "secret-key", "secret-value")
+
+	db, err = openKV(dir, withKeys(ring))
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Get([]byte("secret-key-00"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+	for i := 1; i < 60; i++ {
+		got, err := db.Get(fmt.Appendf(nil, "secret-key-%02d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("secret-value-%02d", i), string(got))
+	}
+}
+
+func TestKV_Encryption_WithCompression(t *testing.T) {
+	dir := t.TempDir()
+	ring := newKeyring(t, map[uint32][]byte{1: testKey(1)})
+
+	db, err := openKV(dir, withKeys(ring), withFlate())
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("user:1"), []byte(jsonValue(1))))
+	require.NoError(t, db.Close())
+
+	assertNoPlaintext(t, dir, "user:1")
+
+	db, err = openKV(dir, withKeys(ring))
+	require.NoError(t, err)
+	defer db.Close()
+
+	got, err := db.Get([]byte("user:1"))
+	require.NoError(t, err)
+	assert.Equal(t, jsonValue(1), string(got))
+}
+
+func TestKV_Encryption_NeedsTheKey(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir, withKeys(newKeyring(t, map[uint32][]byte{1: testKey(1)})))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+	require.NoError(t, db.Close())
+
+	_, err = openKV(dir)
+	assert.ErrorIs(t, err, record.ErrNoKeyProvider)
+
+	_, err = openKV(dir, withKeys(newKeyring(t, map[uint32][]byte{2: testKey(2)})))
+	assert.ErrorIs(t, err, record.ErrKeyNotFound)
+
+	_, err = openKV(dir, withKeys(newKeyring(t, map[uint32][]byte{1: testKey(2)})))
+	assert.ErrorIs(t, err, record.ErrDecrypt)
+}
+
+func TestKV_Encryption_AuthenticatesHeader(t *testing.T) {
+	dir := t.TempDir()
+	ring := newKeyring(t, map[uint32][]byte{1: testKey(1)})
+
+	db, err := openKV(dir, withKeys(ring))
+	require.NoError(t, err)
+	require.NoError(t, db.PutWithTTL([]byte("k1"), []byte("v1"), time.Hour))
+	require.NoError(t, db.Close())
+
+	// push the expiry back and fix the crc, as someone editing the file would
+	path := lastDataFile(t, dir)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	rec := data[log.SegmentHeaderSize:]
+	binary.LittleEndian.PutUint32(rec[8:12], binary.LittleEndian.Uint32(rec[8:12])+1000)
+	keySize := binary.LittleEndian.Uint32(rec[13:17])
+	valSize := binary.LittleEndian.Uint32(rec[17:21])
+	end := record.HeaderSize + keySize + valSize
+	crc := record.GenerateCRC(rec[8:record.HeaderSize], rec[record.HeaderSize:record.HeaderSize+keySize], rec[record.HeaderSize+keySize:end])
+	binary.LittleEndian.PutUint32(rec[0:4], crc)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	_, err = openKV(dir, withKeys(ring))
+	assert.ErrorIs(t, err, record.ErrDecrypt)
+}
+
+func TestKV_Encryption_RejectsForgedTombstone(t *testing.T) {
+	dir := t.TempDir()
+	ring := newKeyring(t, map[uint32][]byte{1: testKey(1)})
+
+	db, err := openKV(dir, withKeys(ring))
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+	require.NoError(t, db.Close())
+
+	// append a tombstone reusing the sealed key of the put, with a valid crc
+	path := lastDataFile(t, dir)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	rec := data[log.SegmentHeaderSize:]
+	keySize := binary.LittleEndian.Uint32(rec[13:17])
+	forged := record.EncodeRecord(record.Record{
+		Key:       rec[record.HeaderSize : record.HeaderSize+keySize],
+		Timestamp: binary.LittleEndian.Uint32(rec[4:8]),
+		Expiry:    binary.LittleEndian.Uint32(rec[8:12]),
+		Flags:     record.Flag(rec[12]),
+	})
+	require.NoError(t, os.WriteFile(path, append(data, forged...), 0o644))
+
+	_, err = openKV(dir, withKeys(ring))
+	assert.ErrorIs(t, err, record.ErrDecrypt, "a put cannot be turned into a delete")
+}
+
+func TestKV_Merge_RotatesKey(t *testing.T) {
+	dir := t.TempDir()
+	ring := newKeyring(t, map[uint32][]byte{1: testKey(1)})
+
+	db, err := openKV(dir, withKeys(ring))
+	require.NoError(t, err)
+	for i := 0; i < 30; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "old%02d", i), []byte("written with key 1")))
+	}
+
+	// new writes use key 2, fill the active log file so every record
+	// written with key 1 ends up in a sealed one
+	require.NoError(t, ring.Add(2, testKey(2)))
+	before := listDataFiles(dir)
+	for i := 0; len(listDataFiles(dir)) < len(before)+2; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "new%03d", i), []byte("written with key 2")))
+	}
+
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	// key 1 is no longer needed
+	db, err = openKV(dir, withKeys(newKeyring(t, map[uint32][]byte{2: testKey(2)})))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for i := 0; i < 30; i++ {
+		got, err := db.Get(fmt.Appendf(nil, "old%02d", i))
+		require.NoError(t, err)
+		assert.Equal(t, "written with key 1", string(got))
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keyring")
+	content := fmt.Sprintf("# kival keys\n1 %x\n\n3 %x\n2 %x\n", testKey(1), testKey(3), testKey(2))
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	ring, err := record.LoadKeyring(path)
+	require.NoError(t, err)
+
+	id, key, err := ring.CurrentKey()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(3), id, "the highest ID is the current key")
+	assert.Equal(t, testKey(3), key)
+
+	key, err = ring.Key(1)
+	require.NoError(t, err)
+	assert.Equal(t, testKey(1), key)
+
+	for _, bad := range []string{"1\n", "x 00\n", "1 zz\n", "1 0011\n", fmt.Sprintf("1 %x\n1 %x\n", testKey(1), testKey(2))} {
+		require.NoError(t, os.WriteFile(path, []byte(bad), 0o600))
+		_, err := record.LoadKeyring(path)
+		assert.ErrorIs(t, err, record.ErrInvalidKey, "%q", bad)
+	}
+}
@@ -0,0 +1,166 @@
+package kv
+
+import (
+	"math/rand/v2"
+
+	"github.com/1garo/kival/log"
+)
+
+const (
+	keyDirMaxLevel = 24
+	keyDirP        = 4 // 1 in keyDirP nodes is promoted to the next level
+)
+
+// keyDirNode is an entry of the keyDir skip list.
+type keyDirNode struct {
+	key  string
+	pos  log.LogPosition
+	next []*keyDirNode
+}
+
+// keyDir is the in-memory index, a skip list keeping the keys sorted so they
+// can be scanned in order. It is not safe for concurrent use, kv.mu guards it.
+type keyDir struct {
+	head  *keyDirNode
+	level int
+	size  int
+	rnd   *rand.Rand
+}
+
+func newKeyDir() *keyDir {
+	return &keyDir{
+		head:  &keyDirNode{next: make([]*keyDirNode, keyDirMaxLevel)},
+		level: 1,
+		rnd:   rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+}
+
+// newKeyDirFrom builds a keyDir from the index recovered by log.Open.
+func newKeyDirFrom(index log.Index) *keyDir {
+	k := newKeyDir()
+	for key, pos := range index {
+		k.Put(key, pos)
+	}
+	return k
+}
+
+func (k *keyDir) randomLevel() int {
+	level := 1
+	for level < keyDirMaxLevel && k.rnd.IntN(keyDirP) == 0 {
+		level++
+	}
+	return level
+}
+
+// seek returns the first node with a key greater than or equal to key.
+// When prev is not nil it is filled with the last node before key on every level.
+func (k *keyDir) seek(key string, prev []*keyDirNode) *keyDirNode {
+	x := k.head
+	for i := k.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+		if prev != nil {
+			prev[i] = x
+		}
+	}
+	return x.next[0]
+}
+
+// seekBefore returns the last node with a key lower than key, or nil if there is none.
+func (k *keyDir) seekBefore(key string) *keyDirNode {
+	x := k.head
+	for i := k.level - 1; i >= 0; i-- {
+		for x.next[i] != nil && x.next[i].key < key {
+			x = x.next[i]
+		}
+	}
+
+	if x == k.head {
+		return nil
+	}
+	return x
+}
+
+// last returns the node with the greatest key, or nil if the keyDir is empty.
+func (k *keyDir) last() *keyDirNode {
+	x := k.head
+	for i := k.level - 1; i >= 0; i-- {
+		for x.next[i] != nil {
+			x = x.next[i]
+		}
+	}
+
+	if x == k.head {
+		return nil
+	}
+	return x
+}
+
+// Get returns the position of key.
+func (k *keyDir) Get(key string) (log.LogPosition, bool) {
+	n := k.seek(key, nil)
+	if n == nil || n.key != key {
+		return log.LogPosition{}, false
+	}
+	return n.pos, true
+}
+
+// Put inserts key or updates its position.
+func (k *keyDir) Put(key string, pos log.LogPosition) {
+	prev := make([]*keyDirNode, keyDirMaxLevel)
+	n := k.seek(key, prev)
+	if n != nil && n.key == key {
+		n.pos = pos
+		return
+	}
+
+	level := k.randomLevel()
+	if level > k.level {
+		for i := k.level; i < level; i++ {
+			prev[i] = k.head
+		}
+		k.level = level
+	}
+
+	n = &keyDirNode{key: key, pos: pos, next: make([]*keyDirNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+	k.size++
+}
+
+// Delete removes key, reporting whether it was present.
+func (k *keyDir) Delete(key string) bool {
+	prev := make([]*keyDirNode, keyDirMaxLevel)
+	n := k.seek(key, prev)
+	if n == nil || n.key != key {
+		return false
+	}
+
+	for i := 0; i < len(n.next); i++ {
+		prev[i].next[i] = n.next[i]
+	}
+
+	for k.level > 1 && k.head.next[k.level-1] == nil {
+		k.level--
+	}
+	k.size--
+	return true
+}
+
+// Len returns the number of keys.
+func (k *keyDir) Len() int {
+	return k.size
+}
+
+// Ascend calls fn for every key in order until fn returns false.
+// fn may update the position of the current key but must not insert or delete keys.
+func (k *keyDir) Ascend(fn func(key string, pos log.LogPosition) bool) {
+	for n := k.head.next[0]; n != nil; n = n.next[0] {
+		if !fn(n.key, n.pos) {
+			return
+		}
+	}
+}
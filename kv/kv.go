@@ -2,65 +2,138 @@
 package kv
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
-	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
 )
 
 const DefaultDBPath = "./data"
 
-var ErrKeyNotFound = errors.New("key not found in db")
+var (
+	ErrKeyNotFound = errors.New("key not found in db")
+	ErrClosed      = errors.New("db is closed")
+	ErrInvalidTTL  = errors.New("ttl must be positive")
+	ErrEmptyValue  = errors.New("value must not be empty, use Del to remove a key")
+)
 
+// KV is the database handle. Once Close is called every method returns ErrClosed.
 type KV interface {
 	Put(key []byte, data []byte) error
+	PutWithTTL(key []byte, data []byte, ttl time.Duration) error
+	PutMeta(key, data, meta []byte, ttl time.Duration) error
 	Get(key []byte) ([]byte, error)
+	GetMeta(key []byte) (data, meta []byte, err error)
 	Del(key []byte) error
+	Write(b *Batch) error
 	Merge() error
+	PauseCompaction()
+	ResumeCompaction()
+	WaitCompaction() error
+	Stats() ([]SegmentStats, error)
+	Scan(start, end []byte, opts ...ScanOption) *Iterator
+	ScanPrefix(prefix []byte, opts ...ScanOption) *Iterator
+	Watch(prefix []byte, opts ...WatchOption) (*Watcher, error)
+	Sync() error
+	Close() error
 }
 
+// kv is safe for concurrent use. Readers share mu while Put and Del take it
+// exclusively, so writes to the active log are serialized. With
+// log.GroupCommit, Put and Del go through commits instead, which takes mu once
+// for a whole group of writes. Merge only takes
+// mu to look at the index and to swap in its output, mergeMu keeps a single
+// merge running at a time.
 type kv struct {
+	mu        sync.RWMutex
+	mergeMu   sync.Mutex
 	activeLog log.Log
-	keyDir    map[string]log.LogPosition
+	keyDir    *keyDir
+	liveBytes map[uint32]int64 // bytes of each log file the index points at
 	logs      map[uint32]log.Log
 	dbPath    string
+	opts      []log.Option
+	closed    bool
+
+	groupCommit bool // the log uses log.GroupCommit, see commitQueue
+	commits     commitQueue
+
+	compaction compactionConfig
+	compactor  *compactor
+
+	watch watchHub
+}
+
+// Option configures a db opened by New.
+type Option func(*kv) error
+
+// WithLogOptions passes options down to every log file of the db.
+func WithLogOptions(opts ...log.Option) Option {
+	return func(m *kv) error {
+		m.opts = append(m.opts, opts...)
+		return nil
+	}
 }
 
 // New creates a new database or sync based on data into path
-func New(path string, opts ...log.Option) (*kv, error) {
-	activeLog, logs, index, err := log.Open(path, opts...)
+func New(path string, opts ...Option) (*kv, error) {
+	m := &kv{dbPath: path}
+	for _, opt := range opts {
+		if err := opt(m); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.compaction.validate(); err != nil {
+		return nil, err
+	}
+
+	activeLog, logs, index, err := log.Open(path, m.opts...)
 	if err != nil {
 		return nil, err
 	}
 
-	l := make(map[uint32]log.Log, len(logs))
+	m.activeLog = activeLog
+	m.groupCommit = activeLog.SyncStrategy() == log.GroupCommit
+	m.keyDir = newKeyDirFrom(index)
+	m.countLiveBytes()
+	m.logs = make(map[uint32]log.Log, len(logs))
 	for id, lf := range logs {
-		l[id] = lf
+		m.logs[id] = lf
+	}
+
+	if m.compaction.interval > 0 {
+		m.compactor = newCompactor(m, m.compaction)
 	}
-	return &kv{
-		activeLog: activeLog,
-		keyDir:    index,
-		logs:      l,
-		dbPath:    path,
-	}, nil
+
+	return m, nil
 }
 
 var _ KV = (*kv)(nil)
 
-// rotateActiveLog rotates the active log file, appends data, and returns the position.
-func (m *kv) rotateActiveLog(key, data []byte) (log.LogPosition, error) {
-	m.activeLog.MarkReadOnly()
-	m.logs[m.activeLog.ID()] = m.activeLog
-
-	newLog, err := log.New(m.activeLog.ID()+1, m.dbPath)
+// rotate seals the active log file and replaces it with a new one.
+func (m *kv) rotate() error {
+	newLog, err := m.activeLog.Rotate()
 	if err != nil {
-		return log.LogPosition{}, fmt.Errorf("cannot create new log: %w", err)
+		return fmt.Errorf("cannot create new log: %w", err)
 	}
 
+	m.logs[m.activeLog.ID()] = m.activeLog
 	m.activeLog = newLog
+	return nil
+}
+
+// rotateActiveLog rotates the active log file, appends e, and returns the position.
+func (m *kv) rotateActiveLog(e log.Entry) (log.LogPosition, error) {
+	if err := m.rotate(); err != nil {
+		return log.LogPosition{}, err
+	}
 
-	pos, err := newLog.Append(key, data)
+	pos, err := m.activeLog.AppendEntry(e)
 	if err != nil {
 		return log.LogPosition{}, fmt.Errorf("failed to append to rotated log: %w", err)
 	}
@@ -68,32 +141,135 @@ func (m *kv) rotateActiveLog(key, data []byte) (log.LogPosition, error) {
 	return pos, nil
 }
 
+// appendEntry appends e to the active log, rotating it when it is full.
+func (m *kv) appendEntry(e log.Entry) (log.LogPosition, error) {
+	pos, err := m.activeLog.AppendEntry(e)
+	if err != nil {
+		if !errors.Is(err, log.ErrCapacityExceeded) {
+			return log.LogPosition{}, fmt.Errorf("cannot append encoded data into db: %w", err)
+		}
+
+		return m.rotateActiveLog(e)
+	}
+
+	return pos, nil
+}
+
 // Put add a new key and value to the active log
 func (m *kv) Put(key []byte, data []byte) error {
-	pos, err := m.activeLog.Append(key, data)
+	return m.put(key, data, 0)
+}
+
+// PutWithTTL add a new key and value that expires after ttl.
+// Once expired the key behaves as if it was deleted.
+func (m *kv) PutWithTTL(key []byte, data []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return m.put(key, data, record.Expiry(time.Now().Add(ttl)))
+}
+
+// PutMeta adds a key and value along with metadata of the caller, like flags
+// it needs to interpret the value, which GetMeta returns with it. The value
+// may be empty. A ttl of 0 never expires, otherwise it behaves as PutWithTTL.
+func (m *kv) PutMeta(key, data, meta []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	var expiry uint32
+	if ttl > 0 {
+		expiry = record.Expiry(time.Now().Add(ttl))
+	}
+
+	return m.putEntry(log.Entry{Key: key, Value: record.JoinMeta(meta, data), Expiry: expiry, Meta: true})
+}
+
+// put writes key with an expiry, see record.Expiry, 0 never expires.
+// An empty data would be stored as a tombstone, so it is rejected with
+// ErrEmptyValue.
+func (m *kv) put(key []byte, data []byte, expiry uint32) error {
+	if len(data) == 0 {
+		return ErrEmptyValue
+	}
+
+	return m.putEntry(log.Entry{Key: key, Value: data, Expiry: expiry})
+}
+
+// putEntry writes e and points its key at it.
+func (m *kv) putEntry(e log.Entry) error {
+	if m.groupCommit {
+		return m.commit(e, false)
+	}
+
+	defer m.publish()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	pos, err := m.appendEntry(e)
 	if err != nil {
-		if errors.Is(err, log.ErrCapacityExceeded) {
-			p, err := m.rotateActiveLog(key, data)
-			if err != nil {
-				return err
-			}
-			pos = p
-		} else {
-			return fmt.Errorf("cannot append encoded data into db: %v", err)
-		}
+		return err
 	}
 
-	m.keyDir[string(key)] = pos
+	m.setKey(string(e.Key), pos)
+	m.notify(e, pos)
 	return nil
 }
 
 // Get a value from the log based on the key
 func (m *kv) Get(key []byte) ([]byte, error) {
-	pos, ok := m.keyDir[string(key)]
-	if !ok {
-		return nil, ErrKeyNotFound
+	data, _, err := m.GetMeta(key)
+	return data, err
+}
+
+// GetMeta returns the value of key and the metadata it was written with by
+// PutMeta, nil when it was written some other way.
+func (m *kv) GetMeta(key []byte) (data, meta []byte, err error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, nil, ErrClosed
+	}
+
+	pos, ok := m.keyDir.Get(string(key))
+	if !ok || pos.Expired(time.Now()) {
+		return nil, nil, ErrKeyNotFound
+	}
+
+	return m.readData(pos)
+}
+
+// readData reads the value stored at pos and splits off its metadata, the
+// caller must hold mu.
+func (m *kv) readData(pos log.LogPosition) (data, meta []byte, err error) {
+	val, err := m.readAt(pos)
+	if err != nil {
+		return nil, nil, err
 	}
 
+	return splitValue(val, pos.Meta)
+}
+
+// splitValue splits the metadata off the value of a record whose flags carry
+// record.FlagMeta, as told by hasMeta.
+func splitValue(val []byte, hasMeta bool) (data, meta []byte, err error) {
+	if !hasMeta {
+		return val, nil, nil
+	}
+
+	meta, data, err = record.SplitMeta(val)
+	return data, meta, err
+}
+
+// readAt reads the value stored at pos as it is in the log, the caller must
+// hold mu.
+func (m *kv) readAt(pos log.LogPosition) ([]byte, error) {
 	if active, ok := m.logs[pos.FileID]; ok {
 		return active.ReadAt(pos)
 	}
@@ -102,66 +278,220 @@ func (m *kv) Get(key []byte) ([]byte, error) {
 
 // Del a key from the active log
 func (m *kv) Del(key []byte) error {
-	if _, ok := m.keyDir[string(key)]; !ok {
+	e := log.Entry{Key: key}
+	if m.groupCommit {
+		return m.commit(e, true)
+	}
+
+	defer m.publish()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	pos, ok := m.keyDir.Get(string(key))
+	if !ok || pos.Expired(time.Now()) {
 		return ErrKeyNotFound
 	}
 
-	if _, err := m.activeLog.Append(key, nil); err != nil {
-		return fmt.Errorf("cannot append encoded data into db: %w", err)
+	tombstone, err := m.appendEntry(e)
+	if err != nil {
+		return err
 	}
 
-	delete(m.keyDir, string(key))
+	m.deleteKey(string(key))
+	m.notify(e, tombstone)
 	return nil
 }
 
-// Merge merges all the logs in the db into a single log file
+// Merge rewrites the live keys of the sealed log files into as few new ones
+// as possible, dropping overwritten, deleted and expired records. The active
+// log is left as is. The new files only replace the old ones once they are
+// fully on disk, so a crash during Merge never loses data.
+//
+// Reads and writes go on while the new files are written, the db is only
+// locked to pick the keys to copy and to swap the new files in. Keys written
+// in the meantime keep their newer value.
 func (m *kv) Merge() error {
-	if len(m.logs) == 0 {
+	return m.merge(func([]SegmentStats) uint32 {
+		return m.activeLog.ID() - 1
+	})
+}
+
+// merge compacts the sealed log files with an id up to the one returned by
+// cutoff, which is called with mu held. A cutoff of 0 merges nothing.
+//
+// Only a prefix of the log files can be merged: a tombstone is dropped along
+// with the older values it hides, and a rewritten key must not end up before
+// an older value of it left in another log file.
+func (m *kv) merge(cutoff func(stats []SegmentStats) uint32) error {
+	m.mergeMu.Lock()
+	defer m.mergeMu.Unlock()
+
+	m.mu.RLock()
+	if m.closed {
+		m.mu.RUnlock()
+		return ErrClosed
+	}
+
+	last := cutoff(m.stats())
+
+	// sealed log files never change, so they can be read without holding mu
+	inputs := make(map[uint32]log.Log, len(m.logs))
+	maxID := m.activeLog.ID() - 1
+	for id, l := range m.logs {
+		if id <= last {
+			inputs[id] = l
+		} else if id <= maxID {
+			maxID = id - 1
+		}
+	}
+	live, expired := m.liveKeys(inputs)
+	m.mu.RUnlock()
+
+	if len(inputs) == 0 {
 		return nil
 	}
 
-	var compactedLog log.Log
-	var err error
-	compactedLog, err = log.New(m.activeLog.ID()+1, m.dbPath)
+	ids := make([]uint32, 0, len(inputs))
+	for id := range inputs {
+		ids = append(ids, id)
+	}
+
+	mg, err := log.NewMerge(m.dbPath, ids, maxID, m.opts...)
 	if err != nil {
-		return fmt.Errorf("cannot create new compacted log: %w", err)
+		return fmt.Errorf("cannot start merge: %w", err)
 	}
 
-	for key := range m.keyDir {
-		val, err := m.Get([]byte(key))
+	newPos := make([]log.LogPosition, len(live))
+	for i, lk := range live {
+		val, err := inputs[lk.pos.FileID].ReadAt(lk.pos)
+		if err == nil {
+			newPos[i], err = mg.Append(log.Entry{Key: []byte(lk.key), Value: val, Expiry: lk.pos.Expiry, Meta: lk.pos.Meta})
+		}
 		if err != nil {
-			return fmt.Errorf("failed to get value: %w", err)
+			return errors.Join(fmt.Errorf("cannot merge key %q: %w", lk.key, err), mg.Abort())
 		}
+	}
 
-		pos, err := compactedLog.Append([]byte(key), val)
-		if err != nil {
-			if errors.Is(err, log.ErrCapacityExceeded) {
-				pos, err = m.rotateActiveLog([]byte(key), val)
-				if err != nil {
-					return err
-				}
-				compactedLog = m.activeLog
-			} else {
-				return fmt.Errorf("failed to append: %w", err)
-			}
+	outputs, err := mg.Commit()
+	if err != nil {
+		return fmt.Errorf("cannot commit merge: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the inputs are gone from disk, their open descriptors are all that is left
+	for id, l := range inputs {
+		_ = l.Close()
+		delete(m.logs, id)
+		delete(m.liveBytes, id)
+	}
+
+	// a key written since it was picked already points past the merge. The
+	// output may reuse the id of an input, so live bytes are counted afresh.
+	for i, lk := range live {
+		if pos, ok := m.keyDir.Get(lk.key); ok && pos == lk.pos {
+			m.keyDir.Put(lk.key, newPos[i])
+			m.liveBytes[newPos[i].FileID] += recordSize(newPos[i])
+		}
+	}
+	for _, lk := range expired {
+		if pos, ok := m.keyDir.Get(lk.key); ok && pos == lk.pos {
+			m.keyDir.Delete(lk.key)
 		}
+	}
 
-		m.keyDir[key] = pos
+	for id, l := range outputs {
+		m.logs[id] = l
 	}
 
-	for _, l := range m.logs {
-		l.MarkReadOnly()
+	return nil
+}
+
+type liveKey struct {
+	key string
+	pos log.LogPosition
+}
+
+// liveKeys returns the keys whose latest value lives in one of the inputs, in
+// the order they were written so the output never needs more files than the
+// input, and the ones among them that expired. The caller must hold mu.
+func (m *kv) liveKeys(inputs map[uint32]log.Log) (live, expired []liveKey) {
+	now := time.Now()
+	m.keyDir.Ascend(func(key string, pos log.LogPosition) bool {
+		if _, ok := inputs[pos.FileID]; !ok {
+			return true
+		}
+		// expired keys are not rewritten, so their space is reclaimed
+		if pos.Expired(now) {
+			expired = append(expired, liveKey{key: key, pos: pos})
+			return true
+		}
+		live = append(live, liveKey{key: key, pos: pos})
+		return true
+	})
+
+	slices.SortFunc(live, func(a, b liveKey) int {
+		if c := cmp.Compare(a.pos.FileID, b.pos.FileID); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.pos.ValuePos, b.pos.ValuePos)
+	})
+
+	return live, expired
+}
+
+// Sync flushes the active log to disk, useful to force durability when
+// running with log.EveryN.
+func (m *kv) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	return m.activeLog.Sync()
+}
+
+// Close stops the background compactor and the watchers, waits for a running
+// merge, syncs the active log and closes every log file held by the db.
+func (m *kv) Close() error {
+	if m.compactor != nil {
+		m.compactor.stop()
 	}
 
-	m.activeLog = compactedLog
+	m.mergeMu.Lock()
+	defer m.mergeMu.Unlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+	m.closed = true
+	m.stopWatchers(ErrClosed)
+
+	var errs []error
+	if err := m.activeLog.Sync(); err != nil {
+		errs = append(errs, fmt.Errorf("cannot sync active log: %w", err))
+	}
 
 	for id, l := range m.logs {
-		_ = l.Close()
-		filename := fmt.Sprintf("%s/%d.data", m.dbPath, id)
-		_ = os.Remove(filename)
+		if err := l.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("cannot close log %d: %w", id, err))
+		}
 	}
 
-	m.logs = make(map[uint32]log.Log)
+	// the active log holds the directory lock, so it goes last
+	if err := m.activeLog.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("cannot close active log: %w", err))
+	}
 
-	return nil
+	return errors.Join(errs...)
 }
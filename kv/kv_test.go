@@ -1,21 +1,36 @@
 package kv_test
 
 import (
+	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// testSegmentSize keeps log files small so tests rotate them quickly.
+const testSegmentSize = 1500
+
+// openKV opens the db in dir with log files of testSegmentSize bytes.
+func openKV(dir string, opts ...kv.Option) (kv.KV, error) {
+	opts = append([]kv.Option{kv.WithLogOptions(log.WithMaxSegmentSize(testSegmentSize))}, opts...)
+	return kv.New(dir, opts...)
+}
+
 func newTestKV(t *testing.T, dir string) kv.KV {
 	t.Helper()
-	db, err := kv.New(dir)
+	db, err := openKV(dir)
 	require.NoError(t, err)
 	t.Cleanup(func() {
 		if db != nil {
+			_ = db.Close()
 			_ = os.RemoveAll(dir)
 		}
 	})
@@ -100,16 +115,18 @@ func TestKV_Del_NotFound(t *testing.T) {
 func TestKV_Persistence(t *testing.T) {
 	dir := t.TempDir()
 
-	db1, err := kv.New(dir)
+	db1, err := openKV(dir)
 	require.NoError(t, err)
 
 	err = db1.Put([]byte("key1"), []byte("value1"))
 	require.NoError(t, err)
 	err = db1.Put([]byte("key2"), []byte("value2"))
 	require.NoError(t, err)
+	require.NoError(t, db1.Close())
 
-	db2, err := kv.New(dir)
+	db2, err := openKV(dir)
 	require.NoError(t, err)
+	defer db2.Close()
 
 	val1, err := db2.Get([]byte("key1"))
 	require.NoError(t, err)
@@ -120,6 +137,259 @@ func TestKV_Persistence(t *testing.T) {
 	assert.Equal(t, "value2", string(val2))
 }
 
+func TestKV_Put_EmptyValue(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	assert.ErrorIs(t, db.Put([]byte("key1"), nil), kv.ErrEmptyValue)
+	assert.ErrorIs(t, db.PutWithTTL([]byte("key1"), []byte{}, time.Hour), kv.ErrEmptyValue)
+	assert.ErrorIs(t, db.Put([]byte("key2"), nil), kv.ErrEmptyValue)
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	val, err := reopened.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, "value1", string(val), "the rejected puts should not be written")
+
+	_, err = reopened.Get([]byte("key2"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+}
+
+func TestKV_Close_OperationsReturnErrClosed(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+
+	require.NoError(t, db.Close())
+
+	assert.ErrorIs(t, db.Put([]byte("key1"), []byte("value1")), kv.ErrClosed)
+	_, err = db.Get([]byte("key1"))
+	assert.ErrorIs(t, err, kv.ErrClosed)
+	assert.ErrorIs(t, db.Del([]byte("key1")), kv.ErrClosed)
+	assert.ErrorIs(t, db.Merge(), kv.ErrClosed)
+	assert.ErrorIs(t, db.Sync(), kv.ErrClosed)
+	assert.ErrorIs(t, db.Close(), kv.ErrClosed)
+}
+
+func TestKV_Close_PersistsPendingEveryNWrites(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir, kv.WithLogOptions(log.WithSyncStrategy(log.EveryN), log.WithSyncEveryN(100)))
+	require.NoError(t, err)
+
+	forceRotation(db, 40)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	val, err := reopened.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, "value1", string(val))
+}
+
+func TestKV_Close_PersistsIntervalAndNeverWrites(t *testing.T) {
+	strategies := map[string][]log.Option{
+		"Interval": {log.WithSyncStrategy(log.Interval), log.WithSyncInterval(time.Hour)},
+		"Never":    {log.WithSyncStrategy(log.Never)},
+	}
+
+	for name, opts := range strategies {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+
+			db, err := openKV(dir, kv.WithLogOptions(opts...))
+			require.NoError(t, err)
+
+			forceRotation(db, 40)
+			require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+			require.NoError(t, db.Close())
+
+			reopened := newTestKV(t, dir)
+			val, err := reopened.Get([]byte("key1"))
+			require.NoError(t, err)
+			assert.Equal(t, "value1", string(val))
+		})
+	}
+}
+
+func TestKV_Sync(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir, kv.WithLogOptions(log.WithSyncStrategy(log.EveryN), log.WithSyncEveryN(100)))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	assert.NoError(t, db.Sync())
+}
+
+func TestKV_New_LockedDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	_, err = openKV(dir)
+	assert.ErrorIs(t, err, log.ErrDatabaseLocked, "second opener should be rejected")
+
+	forceRotation(db, 60)
+	require.NoError(t, db.Merge())
+
+	_, err = openKV(dir)
+	assert.ErrorIs(t, err, log.ErrDatabaseLocked, "lock should survive rotation and merge")
+
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err, "lock should be released on close")
+	require.NoError(t, reopened.Close())
+}
+
+func TestKV_New_MaxSegmentSizePerDB(t *testing.T) {
+	smallDir, largeDir := t.TempDir(), t.TempDir()
+	small := newTestKV(t, smallDir)
+
+	large, err := kv.New(largeDir)
+	require.NoError(t, err)
+	defer large.Close()
+
+	forceRotation(small, 60)
+	forceRotation(large, 60)
+
+	assert.Greater(t, len(listDataFiles(smallDir)), 1)
+	assert.Len(t, listDataFiles(largeDir), 1, "the default size should hold every key")
+}
+
+func TestKV_Put_LargerThanLogFile(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	big := make(map[string][]byte)
+	for i, size := range []int{3 << 20, 5 << 20} {
+		val := bytes.Repeat([]byte{byte('a' + i)}, size)
+		key := fmt.Sprintf("big%d", i)
+		big[key] = val
+
+		require.NoError(t, db.Put([]byte("small"), []byte("value")))
+		require.NoError(t, db.Put([]byte(key), val))
+	}
+	require.NoError(t, db.Put([]byte("after"), []byte("value")))
+
+	for key, val := range big {
+		got, err := db.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, val, got)
+	}
+
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	reopened := newTestKV(t, dir)
+	for key, val := range big {
+		got, err := reopened.Get([]byte(key))
+		require.NoError(t, err)
+		assert.Equal(t, val, got, "%s should survive a merge and a reopen", key)
+	}
+
+	got, err := reopened.Get([]byte("after"))
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(got))
+}
+
+func TestKV_New_InvalidMaxSegmentSize(t *testing.T) {
+	_, err := kv.New(t.TempDir(), kv.WithLogOptions(log.WithMaxSegmentSize(10)))
+	assert.ErrorIs(t, err, log.ErrInvalidSegmentSize)
+}
+
+func TestKV_New_SharedReadOnlyOpeners(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, db.Close())
+
+	r1, err := openKV(dir, kv.WithLogOptions(log.WithReadOnly()))
+	require.NoError(t, err)
+	defer r1.Close()
+
+	r2, err := openKV(dir, kv.WithLogOptions(log.WithReadOnly()))
+	require.NoError(t, err)
+	defer r2.Close()
+
+	val, err := r2.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, "value1", string(val))
+
+	assert.ErrorIs(t, r1.Put([]byte("key2"), []byte("value2")), log.ErrReadOnlySegment)
+
+	_, err = openKV(dir)
+	assert.ErrorIs(t, err, log.ErrDatabaseLocked, "writer should wait for readers to close")
+}
+
+func TestKV_New_ReadOnlyLeavesDirectoryAlone(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("key1"), []byte("value1")))
+	require.NoError(t, db.Close())
+
+	// a copy of the database, made without its LOCK, on a read-only directory
+	require.NoError(t, os.Remove(filepath.Join(dir, "LOCK")))
+	require.NoError(t, os.Chmod(dir, 0o555))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0o755) })
+
+	r, err := openKV(dir, kv.WithLogOptions(log.WithReadOnly()))
+	require.NoError(t, err)
+	val, err := r.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, "value1", string(val))
+	require.NoError(t, r.Close())
+	assert.NoFileExists(t, filepath.Join(dir, "LOCK"))
+
+	missing := filepath.Join(t.TempDir(), "missing")
+	_, err = openKV(missing, kv.WithLogOptions(log.WithReadOnly()))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	assert.NoDirExists(t, missing)
+}
+
+func TestKV_Rotation_WritesHintFiles(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestKV(t, dir)
+
+	forceRotation(db, 60)
+
+	dataFiles := listDataFiles(dir)
+	require.Greater(t, len(dataFiles), 1, "needs multiple log files")
+
+	hints, err := filepath.Glob(filepath.Join(dir, "*.hint"))
+	require.NoError(t, err)
+	assert.Len(t, hints, len(dataFiles)-1, "every sealed log should have a hint file")
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	val, err := reopened.Get([]byte("keya"))
+	require.NoError(t, err)
+	assert.Equal(t, "this is a long value that will fill the log", string(val))
+}
+
 func TestKV_Merge_CreatesCompactedLog(t *testing.T) {
 	dir := t.TempDir()
 	db := newTestKV(t, dir)
@@ -184,3 +454,249 @@ func TestKV_Merge_UpdatesKeyPositions(t *testing.T) {
 	files := listDataFiles(dir)
 	assert.Equal(t, 1, len(files), "should have only compacted log")
 }
+
+func TestKV_Concurrent_PutGet(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestKV(t, dir)
+
+	const writers = 8
+	const keysPerWriter = 50
+
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keysPerWriter; i++ {
+				key := fmt.Appendf(nil, "w%d-key%d", w, i)
+				val := fmt.Appendf(nil, "w%d-val%d", w, i)
+				if !assert.NoError(t, db.Put(key, val)) {
+					return
+				}
+
+				got, err := db.Get(key)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, val, got)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keysPerWriter; i++ {
+			val, err := db.Get(fmt.Appendf(nil, "w%d-key%d", w, i))
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("w%d-val%d", w, i), string(val))
+		}
+	}
+}
+
+func TestKV_Concurrent_ReadsDuringMerge(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestKV(t, dir)
+
+	const keys = 40
+	for i := 0; i < keys; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "key%d", i), fmt.Appendf(nil, "val%d", i)))
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				for i := 0; i < keys; i++ {
+					val, err := db.Get(fmt.Appendf(nil, "key%d", i))
+					if !assert.NoError(t, err) {
+						return
+					}
+					assert.Equal(t, fmt.Sprintf("val%d", i), string(val))
+				}
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			key := fmt.Appendf(nil, "churn%d", i%10)
+			if !assert.NoError(t, db.Put(key, []byte("some churn value"))) {
+				return
+			}
+			if i%3 == 0 {
+				assert.NoError(t, db.Del(key))
+			}
+		}
+	}()
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, db.Merge())
+	}
+
+	close(done)
+	wg.Wait()
+
+	for i := 0; i < keys; i++ {
+		val, err := db.Get(fmt.Appendf(nil, "key%d", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("val%d", i), string(val))
+	}
+}
+
+func TestKV_PutWithTTL_InvalidTTL(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	assert.ErrorIs(t, db.PutWithTTL([]byte("key1"), []byte("value1"), 0), kv.ErrInvalidTTL)
+	assert.ErrorIs(t, db.PutWithTTL([]byte("key1"), []byte("value1"), -time.Second), kv.ErrInvalidTTL)
+}
+
+func TestKV_PutWithTTL_LiveKeySurvivesReopenAndMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, db.PutWithTTL([]byte("key1"), []byte("value1"), time.Hour))
+	forceRotation(db, 60)
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	val, err := reopened.Get([]byte("key1"))
+	require.NoError(t, err)
+	assert.Equal(t, "value1", string(val))
+}
+
+func TestKV_PutMeta_SurvivesReopenAndMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, db.PutMeta([]byte("meta:1"), []byte("value1"), []byte("meta1"), 0))
+	require.NoError(t, db.PutMeta([]byte("meta:2"), nil, []byte("meta2"), time.Hour))
+	require.NoError(t, db.PutMeta([]byte("meta:3"), []byte("value3"), []byte("meta3"), 0))
+	require.NoError(t, db.Put([]byte("meta:3"), []byte("plain")))
+	assert.ErrorIs(t, db.PutMeta([]byte("meta:4"), nil, nil, -time.Second), kv.ErrInvalidTTL)
+
+	check := func(db kv.KV) {
+		t.Helper()
+
+		val, err := db.Get([]byte("meta:1"))
+		require.NoError(t, err)
+		assert.Equal(t, "value1", string(val), "Get leaves the metadata out")
+
+		for key, want := range map[string][2]string{
+			"meta:1": {"value1", "meta1"},
+			"meta:2": {"", "meta2"},
+			"meta:3": {"plain", ""},
+		} {
+			data, meta, err := db.GetMeta([]byte(key))
+			require.NoError(t, err, key)
+			assert.Equal(t, want, [2]string{string(data), string(meta)}, key)
+		}
+
+		it := db.ScanPrefix([]byte("meta:"))
+		var got []string
+		for it.Next() {
+			got = append(got, string(it.Key())+"="+string(it.Value())+"/"+string(it.Meta()))
+		}
+		require.NoError(t, it.Err())
+		assert.Equal(t, []string{"meta:1=value1/meta1", "meta:2=/meta2", "meta:3=plain/"}, got)
+	}
+
+	check(db)
+	forceRotation(db, 60)
+	require.NoError(t, db.Merge())
+	check(db)
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+	check(reopened)
+}
+
+func TestKV_PutWithTTL_Expires(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Put([]byte("shadowed"), []byte("old")))
+	require.NoError(t, db.PutWithTTL([]byte("shadowed"), []byte("new"), time.Second))
+	require.NoError(t, db.PutWithTTL([]byte("ttl"), []byte("value"), time.Second))
+	require.NoError(t, db.Put([]byte("plain"), []byte("value")))
+
+	val, err := db.Get([]byte("ttl"))
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(val))
+
+	// expiry has a one second resolution
+	time.Sleep(2100 * time.Millisecond)
+
+	_, err = db.Get([]byte("ttl"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+	_, err = db.Get([]byte("shadowed"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound, "expired value should not reveal the older one")
+	assert.ErrorIs(t, db.Del([]byte("ttl")), kv.ErrKeyNotFound)
+
+	var keys []string
+	it := db.Scan(nil, nil)
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	require.NoError(t, it.Err())
+	assert.Equal(t, []string{"plain"}, keys)
+
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	_, err = reopened.Get([]byte("shadowed"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound, "recovery should skip expired records")
+
+	val, err = reopened.Get([]byte("plain"))
+	require.NoError(t, err)
+	assert.Equal(t, "value", string(val))
+}
+
+func TestKV_Merge_DropsExpiredKeys(t *testing.T) {
+	dir := t.TempDir()
+	db := newTestKV(t, dir)
+
+	big := make([]byte, 400)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, db.PutWithTTL(fmt.Appendf(nil, "ttl%d", i), big, time.Second))
+	}
+	// too big to share a log file with the last ttl key, so all of them are sealed
+	plain := bytes.Repeat([]byte("v"), testSegmentSize-100)
+	require.NoError(t, db.Put([]byte("plain"), plain))
+	require.Greater(t, len(listDataFiles(dir)), 1, "needs multiple log files")
+
+	time.Sleep(2100 * time.Millisecond)
+	require.NoError(t, db.Merge())
+
+	assert.Len(t, listDataFiles(dir), 1, "expired values should not be rewritten")
+
+	val, err := db.Get([]byte("plain"))
+	require.NoError(t, err)
+	assert.Equal(t, plain, val)
+}
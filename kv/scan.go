@@ -0,0 +1,189 @@
+package kv
+
+import "time"
+
+// ScanOption configures a scan.
+type ScanOption func(*Iterator)
+
+// Reverse makes the scan go from the greatest key to the smallest one.
+func Reverse() ScanOption {
+	return func(it *Iterator) {
+		it.reverse = true
+	}
+}
+
+// KeysOnly makes the scan skip reading values, Value returns nil.
+func KeysOnly() ScanOption {
+	return func(it *Iterator) {
+		it.keysOnly = true
+	}
+}
+
+// Iterator walks the keys of a range in order, reading each value lazily.
+//
+// It does not hold a snapshot of the db: every call to Next looks up the key
+// following the previous one, so writes done while iterating may or may not
+// be observed, but a key is never returned twice.
+//
+//	it := db.ScanPrefix([]byte("user:"))
+//	for it.Next() {
+//		fmt.Println(string(it.Key()), string(it.Value()))
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator struct {
+	db       *kv
+	start    []byte // inclusive, nil means from the first key
+	end      []byte // exclusive, nil means up to the last key
+	reverse  bool
+	keysOnly bool
+	started  bool
+	done     bool
+	key      []byte
+	value    []byte
+	meta     []byte
+	err      error
+}
+
+// Scan iterates over the keys in [start, end). A nil start or end leaves that
+// side of the range open.
+func (m *kv) Scan(start, end []byte, opts ...ScanOption) *Iterator {
+	it := &Iterator{
+		db:    m,
+		start: start,
+		end:   end,
+	}
+
+	for _, opt := range opts {
+		opt(it)
+	}
+
+	return it
+}
+
+// ScanPrefix iterates over the keys starting with prefix.
+func (m *kv) ScanPrefix(prefix []byte, opts ...ScanOption) *Iterator {
+	return m.Scan(prefix, prefixEnd(prefix), opts...)
+}
+
+// prefixEnd returns the smallest key greater than every key starting with prefix,
+// or nil when there is no such key.
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+
+	return nil
+}
+
+// Next advances the iterator, it returns false once the range is exhausted or
+// an error happened, check Err to tell them apart.
+func (it *Iterator) Next() bool {
+	if it.done {
+		return false
+	}
+
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+
+	if it.db.closed {
+		return it.fail(ErrClosed)
+	}
+
+	n := it.nextNode()
+	now := time.Now()
+	for n != nil && n.pos.Expired(now) {
+		it.started = true
+		it.key = []byte(n.key)
+		n = it.nextNode()
+	}
+
+	if n == nil {
+		it.done = true
+		it.key, it.value, it.meta = nil, nil, nil
+		return false
+	}
+
+	var val, meta []byte
+	if !it.keysOnly {
+		var err error
+		if val, meta, err = it.db.readData(n.pos); err != nil {
+			return it.fail(err)
+		}
+	}
+
+	it.started = true
+	it.key = []byte(n.key)
+	it.value, it.meta = val, meta
+	return true
+}
+
+// nextNode finds the node after the current key in the scan direction, the
+// caller must hold db.mu.
+func (it *Iterator) nextNode() *keyDirNode {
+	keys := it.db.keyDir
+
+	if !it.reverse {
+		from := string(it.start)
+		if it.started {
+			// the smallest key greater than it.key
+			from = string(it.key) + "\x00"
+		}
+
+		n := keys.seek(from, nil)
+		if n == nil || (it.end != nil && n.key >= string(it.end)) {
+			return nil
+		}
+		return n
+	}
+
+	var n *keyDirNode
+	switch {
+	case it.started:
+		n = keys.seekBefore(string(it.key))
+	case it.end != nil:
+		n = keys.seekBefore(string(it.end))
+	default:
+		n = keys.last()
+	}
+
+	if n == nil || (it.start != nil && n.key < string(it.start)) {
+		return nil
+	}
+	return n
+}
+
+func (it *Iterator) fail(err error) bool {
+	it.err = err
+	it.done = true
+	it.key, it.value, it.meta = nil, nil, nil
+	return false
+}
+
+// Key returns the key at the current position.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value at the current position.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Meta returns the metadata of the value at the current position, see
+// KV.GetMeta.
+func (it *Iterator) Meta() []byte {
+	return it.meta
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,175 @@
+package kv_test
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectKeys(t *testing.T, it *kv.Iterator) []string {
+	t.Helper()
+
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	require.NoError(t, it.Err())
+	return keys
+}
+
+func putKeys(t *testing.T, db kv.KV, keys ...string) {
+	t.Helper()
+
+	for _, k := range keys {
+		require.NoError(t, db.Put([]byte(k), []byte("val-"+k)))
+	}
+}
+
+func TestKV_Scan_Range(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "d", "a", "c", "e", "b")
+
+	assert.Equal(t, []string{"b", "c", "d"}, collectKeys(t, db.Scan([]byte("b"), []byte("e"))))
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, collectKeys(t, db.Scan(nil, nil)))
+	assert.Equal(t, []string{"c", "d", "e"}, collectKeys(t, db.Scan([]byte("bb"), nil)))
+	assert.Empty(t, collectKeys(t, db.Scan([]byte("x"), nil)))
+}
+
+func TestKV_Scan_Reverse(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "d", "a", "c", "e", "b")
+
+	assert.Equal(t, []string{"d", "c", "b"}, collectKeys(t, db.Scan([]byte("b"), []byte("e"), kv.Reverse())))
+	assert.Equal(t, []string{"e", "d", "c", "b", "a"}, collectKeys(t, db.Scan(nil, nil, kv.Reverse())))
+	assert.Equal(t, []string{"b", "a"}, collectKeys(t, db.Scan(nil, []byte("bb"), kv.Reverse())))
+}
+
+func TestKV_Scan_ReturnsValues(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "a", "b")
+
+	it := db.Scan(nil, nil)
+	require.True(t, it.Next())
+	assert.Equal(t, "a", string(it.Key()))
+	assert.Equal(t, "val-a", string(it.Value()))
+	require.True(t, it.Next())
+	assert.Equal(t, "val-b", string(it.Value()))
+	assert.False(t, it.Next())
+	assert.NoError(t, it.Err())
+}
+
+func TestKV_Scan_KeysOnly(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "a", "b")
+
+	it := db.Scan(nil, nil, kv.KeysOnly())
+	require.True(t, it.Next())
+	assert.Equal(t, "a", string(it.Key()))
+	assert.Nil(t, it.Value())
+	assert.Equal(t, []string{"b", "a"}, collectKeys(t, db.Scan(nil, nil, kv.KeysOnly(), kv.Reverse())))
+}
+
+func TestKV_ScanPrefix(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "user:1", "user:2", "users", "order:1", "user;", "user:10")
+
+	assert.Equal(t, []string{"user:1", "user:10", "user:2"}, collectKeys(t, db.ScanPrefix([]byte("user:"))))
+	assert.Equal(t, []string{"user:2", "user:10", "user:1"}, collectKeys(t, db.ScanPrefix([]byte("user:"), kv.Reverse())))
+	assert.Empty(t, collectKeys(t, db.ScanPrefix([]byte("nope"))))
+}
+
+func TestKV_ScanPrefix_AllFF(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "\xff\xff", "\xff\xff\x01", "\xfe")
+
+	assert.Equal(t, []string{"\xff\xff", "\xff\xff\x01"}, collectKeys(t, db.ScanPrefix([]byte("\xff\xff"))))
+}
+
+func TestKV_Scan_SkipsDeletedKeys(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "a", "b", "c")
+	require.NoError(t, db.Del([]byte("b")))
+
+	assert.Equal(t, []string{"a", "c"}, collectKeys(t, db.Scan(nil, nil)))
+}
+
+func TestKV_Scan_SeesKeysWrittenAhead(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	putKeys(t, db, "a", "c")
+
+	it := db.Scan(nil, nil)
+	require.True(t, it.Next())
+	assert.Equal(t, "a", string(it.Key()))
+
+	putKeys(t, db, "b")
+	require.NoError(t, db.Del([]byte("c")))
+
+	require.True(t, it.Next())
+	assert.Equal(t, "b", string(it.Key()))
+	assert.False(t, it.Next())
+}
+
+func TestKV_Scan_AfterReopenAndMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	forceRotation(db, 60)
+	require.NoError(t, db.Merge())
+	require.NoError(t, db.Close())
+
+	reopened, err := openKV(dir)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	keys := collectKeys(t, reopened.ScanPrefix([]byte("key")))
+	require.Len(t, keys, 26)
+	assert.True(t, sort.StringsAreSorted(keys))
+}
+
+func TestKV_Scan_ClosedDB(t *testing.T) {
+	db, err := openKV(t.TempDir())
+	require.NoError(t, err)
+	putKeys(t, db, "a", "b")
+
+	it := db.Scan(nil, nil)
+	require.True(t, it.Next())
+	require.NoError(t, db.Close())
+
+	assert.False(t, it.Next())
+	assert.ErrorIs(t, it.Err(), kv.ErrClosed)
+}
+
+func TestKV_Scan_MatchesSortedKeys(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	live := make(map[string]bool)
+	for i := 0; i < 300; i++ {
+		key := fmt.Sprintf("k%03d", rand.IntN(200))
+		if rand.IntN(4) == 0 && live[key] {
+			require.NoError(t, db.Del([]byte(key)))
+			delete(live, key)
+			continue
+		}
+
+		require.NoError(t, db.Put([]byte(key), []byte("v")))
+		live[key] = true
+	}
+
+	var want []string
+	for k := range live {
+		want = append(want, k)
+	}
+	sort.Strings(want)
+
+	assert.Equal(t, want, collectKeys(t, db.Scan(nil, nil)))
+
+	sort.Sort(sort.Reverse(sort.StringSlice(want)))
+	assert.Equal(t, want, collectKeys(t, db.Scan(nil, nil, kv.Reverse())))
+}
@@ -0,0 +1,102 @@
+package kv
+
+import (
+	"cmp"
+	"slices"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+// SegmentStats describes how much of a log file still holds live keys.
+//
+// A record turns dead once its key is overwritten or deleted. Tombstones are
+// dead from the start, they only matter until a merge drops them. Expired keys
+// count as live until a merge drops them.
+type SegmentStats struct {
+	ID        uint32
+	Size      int64 // bytes taken by records, the segment header is left out
+	LiveBytes int64
+	DeadBytes int64
+	Active    bool
+}
+
+// DeadRatio returns the share of the log file taken by dead records.
+func (s SegmentStats) DeadRatio() float64 {
+	if s.Size == 0 {
+		return 0
+	}
+	return float64(s.DeadBytes) / float64(s.Size)
+}
+
+// Stats returns the stats of every log file of the db, ordered by id.
+func (m *kv) Stats() ([]SegmentStats, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	return m.stats(), nil
+}
+
+// stats is Stats for a caller that holds mu.
+func (m *kv) stats() []SegmentStats {
+	stats := make([]SegmentStats, 0, len(m.logs)+1)
+	add := func(l log.Log, active bool) {
+		size := l.DataSize()
+		live := m.liveBytes[l.ID()]
+		stats = append(stats, SegmentStats{
+			ID:        l.ID(),
+			Size:      size,
+			LiveBytes: live,
+			DeadBytes: size - live,
+			Active:    active,
+		})
+	}
+
+	for _, l := range m.logs {
+		add(l, false)
+	}
+	add(m.activeLog, true)
+
+	slices.SortFunc(stats, func(a, b SegmentStats) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return stats
+}
+
+// recordSize returns how many bytes the record at pos takes on disk.
+func recordSize(pos log.LogPosition) int64 {
+	return int64(record.HeaderSizeOf(pos.Version)) + int64(pos.KeySize) + int64(pos.ValueSize)
+}
+
+// countLiveBytes rebuilds the live bytes of every log file from the index.
+func (m *kv) countLiveBytes() {
+	m.liveBytes = make(map[uint32]int64)
+	m.keyDir.Ascend(func(key string, pos log.LogPosition) bool {
+		m.liveBytes[pos.FileID] += recordSize(pos)
+		return true
+	})
+}
+
+// setKey points key at pos, moving its live bytes off the record it replaces.
+// The caller must hold mu.
+func (m *kv) setKey(key string, pos log.LogPosition) {
+	if old, ok := m.keyDir.Get(key); ok {
+		m.liveBytes[old.FileID] -= recordSize(old)
+	}
+
+	m.keyDir.Put(key, pos)
+	m.liveBytes[pos.FileID] += recordSize(pos)
+}
+
+// deleteKey removes key from the index, its record turns dead.
+// The caller must hold mu.
+func (m *kv) deleteKey(key string) {
+	if old, ok := m.keyDir.Get(key); ok {
+		m.liveBytes[old.FileID] -= recordSize(old)
+		m.keyDir.Delete(key)
+	}
+}
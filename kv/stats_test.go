@@ -0,0 +1,134 @@
+package kv_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func recordSize(key, val string) int64 {
+	return int64(record.HeaderSize) + int64(len(key)) + int64(len(val))
+}
+
+// segmentStats returns the stats of the log file id, failing if there is none.
+func segmentStats(t *testing.T, db kv.KV, id uint32) kv.SegmentStats {
+	t.Helper()
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	for _, s := range stats {
+		if s.ID == id {
+			return s
+		}
+	}
+
+	require.Failf(t, "missing segment", "no stats for log file %d", id)
+	return kv.SegmentStats{}
+}
+
+func TestKV_Stats_OverwriteAndDelete(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+	s := segmentStats(t, db, 1)
+	assert.True(t, s.Active)
+	assert.Equal(t, recordSize("k1", "v1"), s.Size)
+	assert.Equal(t, recordSize("k1", "v1"), s.LiveBytes)
+	assert.Zero(t, s.DeadBytes)
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("value2")))
+	s = segmentStats(t, db, 1)
+	assert.Equal(t, recordSize("k1", "value2"), s.LiveBytes)
+	assert.Equal(t, recordSize("k1", "v1"), s.DeadBytes)
+
+	require.NoError(t, db.Del([]byte("k1")))
+	s = segmentStats(t, db, 1)
+	assert.Zero(t, s.LiveBytes)
+	assert.Equal(t, s.Size, s.DeadBytes, "the tombstone is dead too")
+	assert.Equal(t, 1.0, s.DeadRatio())
+}
+
+func TestKV_Stats_Batch(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	var b kv.Batch
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Put([]byte("k1"), []byte("v2"))
+	b.Put([]byte("k2"), []byte("v2"))
+	b.Delete([]byte("k2"))
+	require.NoError(t, db.Write(&b))
+
+	s := segmentStats(t, db, 1)
+	assert.Equal(t, recordSize("k1", "v2"), s.LiveBytes)
+	assert.Equal(t, s.Size-s.LiveBytes, s.DeadBytes)
+}
+
+func TestKV_Stats_SurviveReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	forceRotation(db, 60)
+	require.NoError(t, db.Del([]byte("keya")))
+
+	before, err := db.Stats()
+	require.NoError(t, err)
+	require.Len(t, before, 3)
+	require.NoError(t, db.Close())
+
+	reopened := newTestKV(t, dir)
+	after, err := reopened.Stats()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+}
+
+func TestKV_Stats_AfterMerge(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+
+	forceRotation(db, 60)
+	require.NoError(t, db.Merge())
+
+	stats, err := db.Stats()
+	require.NoError(t, err)
+	for _, s := range stats {
+		if !s.Active {
+			assert.Zero(t, s.DeadBytes, "log file %d was just merged", s.ID)
+			assert.Positive(t, s.LiveBytes)
+		}
+	}
+}
+
+func TestKV_Stats_SegmentWithoutHeader(t *testing.T) {
+	dir := t.TempDir()
+	// a log file written before the segment header existed, its records have
+	// the 16 bytes header of record.V0: k1=v1 then k2=value2
+	baseline := "" +
+		"\x27\x1d\xa7\x13\xc0\xa4\x00\x03\x02\x00\x00\x00\x02\x00\x00\x00k1v1" +
+		"\xdc\x94\x21\x5d\xc0\xa4\x00\x03\x02\x00\x00\x00\x06\x00\x00\x00k2value2"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.data"), []byte(baseline), 0o644))
+
+	db := newTestKV(t, dir)
+	s := segmentStats(t, db, 1)
+	assert.Equal(t, int64(len(baseline)), s.Size)
+	assert.Equal(t, s.Size, s.LiveBytes)
+	assert.Zero(t, s.DeadBytes)
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("v2")))
+	s = segmentStats(t, db, 1)
+	assert.Equal(t, int64(record.V0HeaderSize+4), s.DeadBytes)
+}
+
+func TestKV_Stats_ClosedDB(t *testing.T) {
+	db, err := openKV(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	_, err = db.Stats()
+	assert.ErrorIs(t, err, kv.ErrClosed)
+}
@@ -0,0 +1,471 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+// DefaultWatchBuffer is how many events a watcher holds for its reader by
+// default, see WatchBuffer.
+const DefaultWatchBuffer = 1024
+
+var (
+	ErrWatcherTooSlow     = errors.New("watcher fell behind the writes")
+	ErrSeqCompacted       = errors.New("writes after seq were compacted by a merge")
+	ErrInvalidWatchBuffer = errors.New("watch buffer must be positive")
+)
+
+// Op is the kind of write an Event reports.
+type Op uint8
+
+const (
+	OpPut Op = iota + 1
+	OpDelete
+)
+
+func (o Op) String() string {
+	switch o {
+	case OpPut:
+		return "put"
+	case OpDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("Op(%d)", uint8(o))
+	}
+}
+
+// Event is a write to the db as a Watcher reports it.
+//
+// Seq is where the write lives in the log files: the id of the log file in
+// the upper 32 bits and the offset of the record in the lower ones. It grows
+// with every write, so it orders events and tells WatchFrom where to resume.
+// Offsets fit in 32 bits since log files never grow past log.MaxSegmentSize.
+//
+// Key, Value and Meta may be shared with other watchers and must not be
+// modified.
+type Event struct {
+	Op     Op
+	Key    []byte
+	Value  []byte    // nil for OpDelete
+	Meta   []byte    // the metadata written by PutMeta, nil otherwise
+	Expiry time.Time // zero when the key never expires
+	Seq    uint64
+}
+
+// newEvent returns the event of a write of value to key, as stored in the
+// log: hasMeta tells whether its metadata must be split off.
+func newEvent(key, value []byte, hasMeta bool, expiry uint32, seq uint64) (Event, error) {
+	ev := Event{Op: OpPut, Key: key, Seq: seq}
+	if len(value) == 0 {
+		// only tombstones, written by Del, have an empty value
+		ev.Op = OpDelete
+		return ev, nil
+	}
+
+	var err error
+	if ev.Value, ev.Meta, err = splitValue(value, hasMeta); err != nil {
+		return Event{}, err
+	}
+	if expiry != 0 {
+		ev.Expiry = record.Time(expiry)
+	}
+
+	return ev, nil
+}
+
+func seqOf(fileID uint32, offset int64) uint64 {
+	return uint64(fileID)<<32 | uint64(offset)
+}
+
+func splitSeq(seq uint64) (fileID uint32, offset int64) {
+	return uint32(seq >> 32), int64(seq & (1<<32 - 1))
+}
+
+// WatchOption configures a watcher.
+type WatchOption func(*Watcher)
+
+// WatchFrom makes the watcher first replay the writes made after seq, the
+// Seq of the last event a previous watcher delivered, from the records still
+// on disk. A seq of 0 replays every write on disk. Watch fails with
+// ErrSeqCompacted when a merge already rewrote some of them.
+func WatchFrom(seq uint64) WatchOption {
+	return func(w *Watcher) {
+		w.replay, w.since = true, seq
+	}
+}
+
+// WatchBuffer sets how many events the watcher holds while its reader falls
+// behind, DefaultWatchBuffer by default.
+func WatchBuffer(n int) WatchOption {
+	return func(w *Watcher) {
+		w.size = n
+	}
+}
+
+// WatchBlock makes writes wait for a watcher whose buffer is full, instead of
+// stopping it with ErrWatcherTooSlow. Every write of the db then goes at the
+// pace of the slowest such watcher, so its reader must never write to the db
+// itself.
+func WatchBlock() WatchOption {
+	return func(w *Watcher) {
+		w.block = true
+	}
+}
+
+// Watcher delivers the writes made to keys with a prefix, in the order they
+// were made. Writes only show up once they succeeded, merges and expiries are
+// not writes.
+//
+// Events go through a buffer, by default a watcher whose buffer is full is
+// stopped: Events is closed after the buffered events and Err returns
+// ErrWatcherTooSlow. A new watcher can pick up where it left off with
+// WatchFrom.
+//
+//	w, err := db.Watch([]byte("user:"))
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close()
+//	for ev := range w.Events() {
+//		fmt.Println(ev.Op, string(ev.Key))
+//	}
+//	if err := w.Err(); err != nil {
+//		return err
+//	}
+type Watcher struct {
+	db     *kv
+	prefix []byte
+	size   int
+	block  bool
+	replay bool
+	since  uint64 // replay the writes after it
+	from   uint64 // live events before it are replayed, or were made before Watch
+
+	events  chan Event
+	done    chan struct{} // closed by Close
+	stopped chan struct{} // closed once run returns
+
+	closeOnce sync.Once
+	mu        sync.Mutex
+	cond      *sync.Cond // signaled when queue, err or closed change
+	queue     []Event
+	err       error // why no event follows the queued ones
+	closed    bool
+}
+
+// watchHub holds the watchers of a db.
+//
+// Writes queue their events while holding kv.mu, so the queue is in the
+// order of the writes, and publish hands them over once kv.mu is released.
+// publishMu keeps a single publish running, so watchers get them in order too.
+type watchHub struct {
+	mu        sync.Mutex
+	watchers  map[*Watcher]struct{}
+	pending   []Event
+	publishMu sync.Mutex
+}
+
+// Watch returns a watcher of the writes made to keys starting with prefix
+// from now on, an empty prefix watches every key.
+func (m *kv) Watch(prefix []byte, opts ...WatchOption) (*Watcher, error) {
+	w := &Watcher{
+		db:      m,
+		prefix:  bytes.Clone(prefix),
+		size:    DefaultWatchBuffer,
+		events:  make(chan Event),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mu)
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	if w.size < 1 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidWatchBuffer, w.size)
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	// no write is in flight, the next one lands at head
+	head := seqOf(m.activeLog.ID(), m.activeLog.Size())
+
+	var replay *log.Replay
+	if w.replay && w.since < head {
+		var err error
+		if replay, err = m.openReplay(w.since, head); err != nil {
+			return nil, err
+		}
+	}
+
+	w.from = head
+	h := &m.watch
+	h.mu.Lock()
+	if h.watchers == nil {
+		h.watchers = make(map[*Watcher]struct{})
+	}
+	h.watchers[w] = struct{}{}
+	h.mu.Unlock()
+
+	go w.run(replay, head)
+
+	return w, nil
+}
+
+// openReplay opens the log files holding the writes after since up to head.
+// The caller must hold mu.
+func (m *kv) openReplay(since, head uint64) (*log.Replay, error) {
+	first, _ := splitSeq(since)
+	first = max(first, 1) // the first log file of a db
+
+	ids := []uint32{m.activeLog.ID()}
+	for id := range m.logs {
+		if id >= first {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+
+	// log files only go missing when a merge replaced them
+	for i, id := range ids {
+		if id != first+uint32(i) {
+			return nil, fmt.Errorf("%w: log file %d is gone", ErrSeqCompacted, first+uint32(i))
+		}
+	}
+
+	r, err := log.OpenReplay(m.dbPath, ids, m.opts...)
+	if errors.Is(err, log.ErrMergedSegment) || errors.Is(err, os.ErrNotExist) {
+		// a merge running meanwhile may have swapped files on disk already
+		return nil, fmt.Errorf("%w: %w", ErrSeqCompacted, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot replay log files: %w", err)
+	}
+
+	return r, nil
+}
+
+// Events returns the channel the events are delivered on. It is closed once
+// the watcher stops, see Err.
+func (w *Watcher) Events() <-chan Event {
+	return w.events
+}
+
+// Err returns why Events was closed: ErrWatcherTooSlow, ErrClosed when the db
+// was closed, or what failed the replay. It returns nil while the watcher
+// runs and after Close.
+func (w *Watcher) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	return w.err
+}
+
+// Close stops the watcher and drops the events it still holds. Events is
+// closed by the time it returns.
+func (w *Watcher) Close() {
+	w.closeOnce.Do(func() {
+		w.mu.Lock()
+		w.closed = true
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		close(w.done)
+	})
+
+	<-w.stopped
+}
+
+// run delivers the replayed events, then the live ones, until the watcher
+// stops.
+func (w *Watcher) run(replay *log.Replay, head uint64) {
+	defer close(w.stopped)
+	defer w.db.unwatch(w)
+	defer close(w.events)
+
+	if replay != nil {
+		err := w.replayEvents(replay, head)
+		if err = errors.Join(err, replay.Close()); err != nil {
+			w.stop(err)
+		}
+	}
+
+	for {
+		w.mu.Lock()
+		for len(w.queue) == 0 && w.err == nil && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed || len(w.queue) == 0 {
+			w.mu.Unlock()
+			return
+		}
+
+		ev := w.queue[0]
+		w.queue[0] = Event{}
+		w.queue = w.queue[1:]
+		w.cond.Broadcast()
+		w.mu.Unlock()
+
+		select {
+		case w.events <- ev:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// errWatcherClosed stops a replay once the watcher is closed.
+var errWatcherClosed = errors.New("watcher closed")
+
+// replayEvents delivers the writes after since up to head read from disk.
+func (w *Watcher) replayEvents(replay *log.Replay, head uint64) error {
+	_, start := splitSeq(w.since)
+	_, end := splitSeq(head)
+
+	err := replay.Read(start, end, func(r log.DumpRecord) error {
+		seq := seqOf(r.FileID, r.Offset)
+		if seq <= w.since || !bytes.HasPrefix(r.Key, w.prefix) {
+			return nil
+		}
+
+		ev, err := newEvent(r.Key, r.Value, r.Flags&record.FlagMeta != 0, r.Expiry, seq)
+		if err != nil {
+			return fmt.Errorf("log file %d: offset %d: %w", r.FileID, r.Offset, err)
+		}
+
+		select {
+		case w.events <- ev:
+			return nil
+		case <-w.done:
+			return errWatcherClosed
+		}
+	})
+	if errors.Is(err, errWatcherClosed) {
+		return nil
+	}
+
+	return err
+}
+
+// push queues ev for the reader. It returns false once the watcher stopped.
+func (w *Watcher) push(ev Event) bool {
+	if ev.Seq < w.from || !bytes.HasPrefix(ev.Key, w.prefix) {
+		return true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for len(w.queue) >= w.size && w.err == nil && !w.closed {
+		if !w.block {
+			w.err = ErrWatcherTooSlow
+			w.cond.Broadcast()
+			break
+		}
+		w.cond.Wait()
+	}
+	if w.err != nil || w.closed {
+		return false
+	}
+
+	w.queue = append(w.queue, ev)
+	w.cond.Broadcast()
+	return true
+}
+
+// stop ends the watcher with err once the queued events are delivered.
+func (w *Watcher) stop(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		w.err = err
+	}
+	w.cond.Broadcast()
+}
+
+// unwatch forgets w, it gets no more events.
+func (m *kv) unwatch(w *Watcher) {
+	h := &m.watch
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.watchers, w)
+}
+
+// stopWatchers ends every watcher with err.
+func (m *kv) stopWatchers(err error) {
+	h := &m.watch
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w := range h.watchers {
+		w.stop(err)
+	}
+}
+
+// notify queues the event of the write of e at pos for the watchers, publish
+// hands it over. The caller must hold mu.
+func (m *kv) notify(e log.Entry, pos log.LogPosition) {
+	h := &m.watch
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.watchers) == 0 {
+		return
+	}
+
+	// the caller may reuse its slices once the write returns, and the
+	// metadata of the value was joined to it by the db itself
+	ev, _ := newEvent(bytes.Clone(e.Key), bytes.Clone(e.Value), e.Meta, e.Expiry, seqOf(pos.FileID, pos.ValuePos))
+	h.pending = append(h.pending, ev)
+}
+
+// publish hands the queued events over to the watchers. It must be called
+// without holding mu, since a watcher made with WatchBlock can hold it up.
+func (m *kv) publish() {
+	h := &m.watch
+	h.mu.Lock()
+	idle := len(h.pending) == 0
+	h.mu.Unlock()
+	if idle {
+		return
+	}
+
+	h.publishMu.Lock()
+	defer h.publishMu.Unlock()
+
+	h.mu.Lock()
+	events := h.pending
+	h.pending = nil
+	watchers := make([]*Watcher, 0, len(h.watchers))
+	for w := range h.watchers {
+		watchers = append(watchers, w)
+	}
+	h.mu.Unlock()
+
+	for _, w := range watchers {
+		for _, ev := range events {
+			if !w.push(ev) {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+package kv_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nextEvent returns the next event of w, failing the test when none comes.
+func nextEvent(t *testing.T, w *kv.Watcher) kv.Event {
+	t.Helper()
+
+	select {
+	case ev, ok := <-w.Events():
+		require.True(t, ok, "events closed: %v", w.Err())
+		return ev
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "no event")
+		return kv.Event{}
+	}
+}
+
+// drain returns the events of w until it stops.
+func drain(t *testing.T, w *kv.Watcher) []kv.Event {
+	t.Helper()
+
+	var events []kv.Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-w.Events():
+			if !ok {
+				return events
+			}
+			events = append(events, ev)
+		case <-timeout:
+			require.FailNow(t, "events not closed")
+		}
+	}
+}
+
+// describe returns events as op:key=value strings.
+func describe(events ...kv.Event) []string {
+	var out []string
+	for _, ev := range events {
+		out = append(out, fmt.Sprintf("%s:%s=%s", ev.Op, ev.Key, ev.Value))
+	}
+	return out
+}
+
+func TestKV_Watch_PutAndDel(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	require.NoError(t, db.Put([]byte("user:0"), []byte("before")))
+
+	w, err := db.Watch([]byte("user:"))
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, db.Put([]byte("user:1"), []byte("v1")))
+	require.NoError(t, db.Put([]byte("order:1"), []byte("ignored")))
+	require.NoError(t, db.PutWithTTL([]byte("user:2"), []byte("v2"), time.Hour))
+	require.NoError(t, db.Del([]byte("user:1")))
+	assert.ErrorIs(t, db.Del([]byte("user:9")), kv.ErrKeyNotFound)
+
+	events := []kv.Event{nextEvent(t, w), nextEvent(t, w), nextEvent(t, w)}
+	assert.Equal(t, []string{"put:user:1=v1", "put:user:2=v2", "delete:user:1="}, describe(events...))
+	assert.True(t, events[0].Expiry.IsZero())
+	assert.WithinDuration(t, time.Now().Add(time.Hour), events[1].Expiry, 2*time.Second)
+	assert.Less(t, events[0].Seq, events[1].Seq)
+	assert.Less(t, events[1].Seq, events[2].Seq)
+}
+
+func TestKV_Watch_Meta(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	require.NoError(t, db.PutMeta([]byte("k1"), []byte("v1"), []byte("m1"), 0))
+	require.NoError(t, db.PutMeta([]byte("k2"), nil, []byte("m2"), 0))
+
+	events := []kv.Event{nextEvent(t, w), nextEvent(t, w)}
+	assert.Equal(t, []string{"put:k1=v1", "put:k2="}, describe(events...))
+	assert.Equal(t, "m1", string(events[0].Meta))
+	assert.Equal(t, "m2", string(events[1].Meta))
+
+	replay, err := db.Watch(nil, kv.WatchFrom(0))
+	require.NoError(t, err)
+	defer replay.Close()
+	ev := nextEvent(t, replay)
+	assert.Equal(t, []string{"put:k1=v1"}, describe(ev))
+	assert.Equal(t, "m1", string(ev.Meta))
+}
+
+func TestKV_Watch_Batch(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	var b kv.Batch
+	b.Put([]byte("k1"), []byte("v1"))
+	b.Delete([]byte("k2"))
+	require.NoError(t, db.Write(&b))
+
+	assert.Equal(t, []string{"put:k1=v1", "delete:k2="}, describe(nextEvent(t, w), nextEvent(t, w)))
+}
+
+func TestKV_Watch_GroupCommit(t *testing.T) {
+	db := newGroupCommitKV(t, t.TempDir())
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	defer w.Close()
+
+	const writers = 4
+	const rounds = 25
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range rounds {
+				assert.NoError(t, db.Put(fmt.Appendf(nil, "w%d:%d", i, j), []byte("v")))
+			}
+		}()
+	}
+	wg.Wait()
+
+	seen := make(map[string]bool)
+	var last uint64
+	for range writers * rounds {
+		ev := nextEvent(t, w)
+		assert.Greater(t, ev.Seq, last, "events come in the order of the writes")
+		last = ev.Seq
+		seen[string(ev.Key)] = true
+	}
+	assert.Len(t, seen, writers*rounds)
+}
+
+func TestKV_Watch_SlowWatcherIsStopped(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	w, err := db.Watch(nil, kv.WatchBuffer(2))
+	require.NoError(t, err)
+	defer w.Close()
+
+	for i := range 5 {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "k%d", i), []byte("v")), "writers never wait")
+	}
+
+	// the run goroutine may hold one more event than the buffer
+	events := drain(t, w)
+	assert.GreaterOrEqual(t, len(events), 2)
+	assert.Less(t, len(events), 5)
+	assert.ErrorIs(t, w.Err(), kv.ErrWatcherTooSlow)
+
+	// a new watcher resumes where the slow one stopped
+	w2, err := db.Watch(nil, kv.WatchFrom(events[len(events)-1].Seq))
+	require.NoError(t, err)
+	defer w2.Close()
+
+	for i := len(events); i < 5; i++ {
+		assert.Equal(t, fmt.Sprintf("k%d", i), string(nextEvent(t, w2).Key))
+	}
+}
+
+func TestKV_Watch_Block(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	w, err := db.Watch(nil, kv.WatchBuffer(1), kv.WatchBlock())
+	require.NoError(t, err)
+	defer w.Close()
+
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for i := range 10 {
+			assert.NoError(t, db.Put(fmt.Appendf(nil, "k%d", i), []byte("v")))
+		}
+	}()
+
+	select {
+	case <-written:
+		require.FailNow(t, "writes did not wait for the watcher")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	for i := range 10 {
+		assert.Equal(t, fmt.Sprintf("k%d", i), string(nextEvent(t, w).Key))
+	}
+	<-written
+	assert.NoError(t, w.Err())
+}
+
+func TestKV_Watch_ResumeAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+	db, err := openKV(dir)
+	require.NoError(t, err)
+
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k0"), []byte("v0")))
+	seq := nextEvent(t, w).Seq
+	w.Close()
+
+	// enough writes to rotate log files
+	for i := 1; i <= 60; i++ {
+		require.NoError(t, db.Put(fmt.Appendf(nil, "k%d", i), []byte("a value to fill the log")))
+	}
+	require.NoError(t, db.Del([]byte("k1")))
+	require.NoError(t, db.Close())
+	require.Greater(t, len(listDataFiles(dir)), 1)
+
+	db, err = openKV(dir)
+	require.NoError(t, err)
+	defer db.Close()
+
+	w, err = db.Watch(nil, kv.WatchFrom(seq))
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, db.Put([]byte("live"), []byte("v")))
+
+	for i := 1; i <= 60; i++ {
+		assert.Equal(t, fmt.Sprintf("put:k%d=a value to fill the log", i), describe(nextEvent(t, w))[0])
+	}
+	assert.Equal(t, []string{"delete:k1=", "put:live=v"}, describe(nextEvent(t, w), nextEvent(t, w)))
+
+	all, err := db.Watch([]byte("k0"), kv.WatchFrom(0))
+	require.NoError(t, err)
+	defer all.Close()
+	assert.Equal(t, seq, nextEvent(t, all).Seq, "seq 0 replays every write")
+}
+
+func TestKV_Watch_ResumeAfterMerge(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k0"), []byte("v0")))
+	seq := nextEvent(t, w).Seq
+	w.Close()
+
+	forceRotation(db, 40)
+	require.NoError(t, db.Merge())
+
+	_, err = db.Watch(nil, kv.WatchFrom(seq))
+	assert.ErrorIs(t, err, kv.ErrSeqCompacted)
+	_, err = db.Watch(nil, kv.WatchFrom(0))
+	assert.ErrorIs(t, err, kv.ErrSeqCompacted)
+
+	// writes to the active log are past the merge
+	w, err = db.Watch(nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+	seq = nextEvent(t, w).Seq
+	w.Close()
+	require.NoError(t, db.Put([]byte("k2"), []byte("v2")))
+
+	w, err = db.Watch(nil, kv.WatchFrom(seq))
+	require.NoError(t, err)
+	defer w.Close()
+	assert.Equal(t, "put:k2=v2", describe(nextEvent(t, w))[0])
+}
+
+func TestKV_Watch_Close(t *testing.T) {
+	db := newTestKV(t, t.TempDir())
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+
+	w.Close()
+	w.Close()
+	drain(t, w)
+	assert.NoError(t, w.Err())
+	require.NoError(t, db.Put([]byte("k2"), []byte("v2")))
+
+	_, err = db.Watch(nil, kv.WatchBuffer(0))
+	assert.ErrorIs(t, err, kv.ErrInvalidWatchBuffer)
+}
+
+func TestKV_Watch_ClosedDB(t *testing.T) {
+	db, err := openKV(t.TempDir())
+	require.NoError(t, err)
+
+	w, err := db.Watch(nil)
+	require.NoError(t, err)
+	defer w.Close()
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")))
+	require.NoError(t, db.Close())
+
+	assert.Equal(t, []string{"put:k1=v1"}, describe(drain(t, w)...), "buffered events are still delivered")
+	assert.ErrorIs(t, w.Err(), kv.ErrClosed)
+
+	_, err = db.Watch(nil)
+	assert.ErrorIs(t, err, kv.ErrClosed)
+}
@@ -0,0 +1,102 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/1garo/kival/record"
+)
+
+// DumpRecord is a record of a log file as Dump reads it.
+type DumpRecord struct {
+	FileID uint32
+	Offset int64
+	Size   int64 // bytes the record takes in the log file
+	Sealed bool  // encrypted with no key to open it, Key and Value are as stored
+	record.Record
+}
+
+// Dump calls fn with every record of the log files in path, in the order they
+// were written: overwritten records, tombstones and records of uncommitted
+// batches included. Keys and values are decrypted with the keys passed with
+// WithEncryption, if any, and decompressed.
+//
+// Like Open, Dump stops reading a log file at the first record that does not
+// decode, Verify tells what follows. It takes no lock and opens files for reads
+// only. An error returned by fn stops Dump and is returned as is.
+func Dump(path string, fn func(DumpRecord) error, options ...Option) error {
+	files, err := filepath.Glob(filepath.Join(path, "*.data"))
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return parseFileID(files[i]) < parseFileID(files[j])
+	})
+
+	options = append(options[:len(options):len(options)], WithReadOnly())
+	for _, f := range files {
+		if err := dumpLogFile(parseFileID(f), path, fn, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func dumpLogFile(id uint32, dir string, fn func(DumpRecord) error, options []Option) error {
+	lf, err := openExisting(id, dir, options...)
+	if err != nil {
+		return err
+	}
+	defer lf.file.Close()
+
+	stat, err := lf.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	for offset := lf.dataStart; offset < stat.Size(); {
+		rec, n, err := record.DecodeVersion(lf.version, lf.file, offset)
+		if err != nil {
+			if isDecodeError(err) {
+				return nil
+			}
+			return fmt.Errorf("log file %d: %w", id, err)
+		}
+
+		r := DumpRecord{FileID: id, Offset: offset, Size: n, Record: rec}
+		if r.Record, err = lf.decodeForDump(rec); errors.Is(err, record.ErrNoKeyProvider) {
+			r.Record, r.Sealed = rec, true
+		} else if err != nil {
+			return fmt.Errorf("log file %d: offset %d: %w", id, offset, err)
+		}
+
+		if err := fn(r); err != nil {
+			return err
+		}
+		offset += n
+	}
+
+	return nil
+}
+
+// decodeForDump decrypts and decompresses the key and value of rec, their
+// sizes follow while the flags are left as stored.
+func (d *logFile) decodeForDump(rec record.Record) (record.Record, error) {
+	flags := rec.Flags
+
+	rec, err := record.Decrypt(rec, d.keys)
+	if err != nil {
+		return record.Record{}, err
+	}
+
+	if rec.Value, err = record.Decompress(rec); err != nil {
+		return record.Record{}, err
+	}
+	rec.ValueSize = uint32(len(rec.Value))
+
+	rec.Flags = flags
+	return rec, nil
+}
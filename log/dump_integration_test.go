@@ -0,0 +1,102 @@
+//go:build integration
+
+package log_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dumpAll returns every record Dump reads in dir.
+func dumpAll(t *testing.T, dir string, opts ...log.Option) []log.DumpRecord {
+	t.Helper()
+
+	var recs []log.DumpRecord
+	require.NoError(t, log.Dump(dir, func(r log.DumpRecord) error {
+		recs = append(recs, r)
+		return nil
+	}, opts...))
+	return recs
+}
+
+func TestDump_ReadsEveryRecord(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k1", "v2"}, {"k2", "v3"}})
+
+	l, err := newLog(2, dir)
+	require.NoError(t, err)
+	_, err = l.Append([]byte("k2"), nil)
+	require.NoError(t, err)
+	_, err = l.AppendBatch([]log.Entry{{Key: []byte("k3"), Value: []byte("v4")}})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	recs := dumpAll(t, dir)
+	require.Len(t, recs, 5)
+
+	var got []string
+	for _, r := range recs {
+		got = append(got, string(r.Key)+"="+string(r.Value))
+	}
+	assert.Equal(t, []string{"k1=v1", "k1=v2", "k2=v3", "k2=", "k3=v4"}, got, "stale records and tombstones are dumped too")
+
+	assert.Equal(t, uint32(1), recs[0].FileID)
+	assert.Equal(t, int64(log.SegmentHeaderSize), recs[0].Offset)
+	assert.Equal(t, recs[0].Offset+recs[0].Size, recs[1].Offset)
+	assert.Equal(t, uint32(2), recs[3].FileID)
+	assert.NotZero(t, recs[4].Flags&record.FlagBatchCommit)
+}
+
+func TestDump_DecodesValues(t *testing.T) {
+	dir := t.TempDir()
+	keys := record.NewKeyring()
+	require.NoError(t, keys.Add(1, bytes.Repeat([]byte{7}, 32)))
+
+	l, err := newLog(1, dir, log.WithCompression(record.Flate), log.WithEncryption(keys))
+	require.NoError(t, err)
+	value := bytes.Repeat([]byte("compressible "), 20)
+	_, err = l.Append([]byte("k1"), value)
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	recs := dumpAll(t, dir, log.WithEncryption(keys))
+	require.Len(t, recs, 1)
+	assert.False(t, recs[0].Sealed)
+	assert.Equal(t, "k1", string(recs[0].Key))
+	assert.Equal(t, value, recs[0].Value)
+	assert.Equal(t, uint32(len(value)), recs[0].ValueSize)
+	assert.NotZero(t, recs[0].Flags&record.FlagEncrypted, "flags are left as stored")
+
+	recs = dumpAll(t, dir)
+	require.Len(t, recs, 1)
+	assert.True(t, recs[0].Sealed, "no key was given")
+	assert.NotEqual(t, "k1", string(recs[0].Key))
+}
+
+func TestDump_StopsAtTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords...)
+	writeSegment(t, dir, 2, append(log.SegmentHeader(2, record.CurrentVersion), 1, 2, 3))
+
+	assert.Len(t, dumpAll(t, dir), len(repairRecords))
+}
+
+func TestDump_StopsOnCallbackError(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords...)
+
+	errStop := errors.New("stop")
+	calls := 0
+	err := log.Dump(dir, func(log.DumpRecord) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,40 @@
+//go:build integration
+
+package log
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/record"
+)
+
+// SetMergeHook makes fn run at every step of a merge that changes the disk,
+// until the test ends.
+func SetMergeHook(t testing.TB, fn func(step string)) {
+	mergeHook = fn
+	t.Cleanup(func() { mergeHook = nil })
+}
+
+// SegmentHeader returns the header of segment id written with version v.
+func SegmentHeader(id uint32, v record.Version) []byte {
+	return encodeSegmentHeader(segmentHeader{version: v, created: time.Now(), id: id})
+}
+
+// Unsynced reports whether l has writes that were not synced yet.
+func Unsynced(l Log) bool {
+	lf := l.(*logFile)
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+
+	return lf.dirty
+}
+
+// SyncerRunning reports whether l syncs in the background.
+func SyncerRunning(l Log) bool {
+	lf := l.(*logFile)
+	lf.mu.RLock()
+	defer lf.mu.RUnlock()
+
+	return lf.syncer != nil
+}
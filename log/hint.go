@@ -0,0 +1,259 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/1garo/kival/record"
+)
+
+// hintEntrySize is the fixed part of a hint entry:
+// fileID(4) + timestamp(4) + expiry(4) + keySize(4) + valSize(4) + valuePos(8) + flags(1)
+const hintEntrySize = 29
+
+// legacyHintEntrySize is the fixed part of the entries of hint files written
+// before hintMagic, they have no flags.
+const legacyHintEntrySize = 28
+
+// hintMagic starts hint files whose entries carry flags. A hint file without
+// it would need a first segment ID of 1213614411 to be mistaken for one.
+var hintMagic = []byte("KIVH")
+
+var ErrCorruptHint = errors.New("hint file checksum mismatch, corrupted hint")
+
+// hintEntry describes where the latest record of a key lives inside a sealed segment.
+// A ValueSize of 0 marks a tombstone, same as in the data file. The key is
+// stored as in the data file, that is sealed when Flags carry
+// record.FlagEncrypted.
+type hintEntry struct {
+	Key       []byte
+	FileID    uint32
+	ValuePos  int64
+	ValueSize uint32
+	Timestamp uint32
+	Expiry    uint32
+	Flags     record.Flag
+}
+
+func hintFileName(dir string, id uint32) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.hint", id))
+}
+
+// encodeHint serializes the entries followed by a crc of everything before it.
+func encodeHint(entries []hintEntry) []byte {
+	size := len(hintMagic) + 4
+	for _, e := range entries {
+		size += hintEntrySize + len(e.Key)
+	}
+
+	buf := make([]byte, 0, size)
+	buf = append(buf, hintMagic...)
+	for _, e := range entries {
+		buf = binary.LittleEndian.AppendUint32(buf, e.FileID)
+		buf = binary.LittleEndian.AppendUint32(buf, e.Timestamp)
+		buf = binary.LittleEndian.AppendUint32(buf, e.Expiry)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(e.Key)))
+		buf = binary.LittleEndian.AppendUint32(buf, e.ValueSize)
+		buf = binary.LittleEndian.AppendUint64(buf, uint64(e.ValuePos))
+		buf = append(buf, byte(e.Flags))
+		buf = append(buf, e.Key...)
+	}
+
+	crc := crc32.Checksum(buf, crc32.MakeTable(crc32.Castagnoli))
+	return binary.LittleEndian.AppendUint32(buf, crc)
+}
+
+// decodeHint validates the trailing crc and parses the entries of a hint file.
+func decodeHint(buf []byte) ([]hintEntry, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("%w: file too small", ErrCorruptHint)
+	}
+
+	body := buf[:len(buf)-4]
+	crc := binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	if crc != crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)) {
+		return nil, ErrCorruptHint
+	}
+
+	entrySize := legacyHintEntrySize
+	if bytes.HasPrefix(body, hintMagic) {
+		body = body[len(hintMagic):]
+		entrySize = hintEntrySize
+	}
+
+	var entries []hintEntry
+	for len(body) > 0 {
+		if len(body) < entrySize {
+			return nil, fmt.Errorf("%w: truncated entry", ErrCorruptHint)
+		}
+
+		keySize := binary.LittleEndian.Uint32(body[12:16])
+		if keySize == 0 || int(keySize) > len(body)-entrySize {
+			return nil, fmt.Errorf("%w: invalid key size", ErrCorruptHint)
+		}
+
+		e := hintEntry{
+			FileID:    binary.LittleEndian.Uint32(body[0:4]),
+			Timestamp: binary.LittleEndian.Uint32(body[4:8]),
+			Expiry:    binary.LittleEndian.Uint32(body[8:12]),
+			ValueSize: binary.LittleEndian.Uint32(body[16:20]),
+			ValuePos:  int64(binary.LittleEndian.Uint64(body[20:28])),
+			Key:       body[entrySize : entrySize+int(keySize)],
+		}
+		if entrySize == hintEntrySize {
+			e.Flags = record.Flag(body[28])
+		}
+		entries = append(entries, e)
+
+		body = body[entrySize+int(keySize):]
+	}
+
+	return entries, nil
+}
+
+// writeHint atomically replaces the hint file of the given segment.
+func writeHint(dir string, id uint32, entries []hintEntry) error {
+	name := hintFileName(dir, id)
+	tmp := name + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(encodeHint(entries)); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, name)
+}
+
+// readHint loads the hint file of the given segment.
+func readHint(dir string, id uint32) ([]hintEntry, error) {
+	buf, err := os.ReadFile(hintFileName(dir, id))
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeHint(buf)
+}
+
+// loadHint fills idx from the hint file of d.
+// It returns an error when the hint is missing or cannot be trusted, in which
+// case the caller should fall back to scanning the data file.
+func (d *logFile) loadHint(idx map[string]LogPosition) error {
+	entries, err := readHint(d.dir, d.id)
+	if err != nil {
+		return err
+	}
+
+	stat, err := d.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		end := e.ValuePos + int64(record.HeaderSizeOf(d.version)) + int64(len(e.Key)) + int64(e.ValueSize)
+		if e.FileID != d.id || e.ValuePos < d.dataStart || end > stat.Size() {
+			return fmt.Errorf("%w: entry points outside of segment %d", ErrCorruptHint, d.id)
+		}
+	}
+
+	// keys are decrypted up front, so a key that cannot be leaves idx untouched
+	keys := make([][]byte, len(entries))
+	for i, e := range entries {
+		key, err := d.recordKey(record.Record{
+			Key:       e.Key,
+			ValueSize: e.ValueSize,
+			Timestamp: e.Timestamp,
+			Expiry:    e.Expiry,
+			Flags:     e.Flags,
+		})
+		if err != nil {
+			return err
+		}
+		keys[i] = key
+	}
+
+	now := time.Now()
+	for i, e := range entries {
+		isTombstoneRecord := e.ValueSize == 0
+		if isTombstoneRecord || record.Expired(e.Expiry, now) {
+			delete(idx, string(keys[i]))
+			continue
+		}
+
+		idx[string(keys[i])] = LogPosition{
+			FileID:    e.FileID,
+			ValuePos:  e.ValuePos,
+			KeySize:   uint32(len(e.Key)),
+			ValueSize: e.ValueSize,
+			Expiry:    e.Expiry,
+			Meta:      e.Flags&record.FlagMeta != 0,
+			Version:   d.version,
+			timestamp: e.Timestamp,
+		}
+	}
+
+	d.writePos = stat.Size()
+	return nil
+}
+
+// WriteHint writes the hint file for this segment, it should only be called
+// once the segment is sealed since later appends are not reflected in it.
+func (d *logFile) WriteHint() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if d.closed {
+		return ErrLogClosed
+	}
+
+	// only the last record of each key matters, keep the entries in the
+	// order keys were first seen so the hint is deterministic.
+	var entries []hintEntry
+	seen := make(map[string]int)
+	_, err := d.scan(func(rec record.Record, start int64) {
+		e := hintEntry{
+			Key:       rec.Key,
+			FileID:    d.id,
+			ValuePos:  start,
+			ValueSize: rec.ValueSize,
+			Timestamp: rec.Timestamp,
+			Expiry:    rec.Expiry,
+			Flags:     rec.Flags,
+		}
+
+		if i, ok := seen[string(rec.Key)]; ok {
+			entries[i] = e
+			return
+		}
+
+		seen[string(rec.Key)] = len(entries)
+		entries = append(entries, e)
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeHint(d.dir, d.id, entries)
+}
@@ -0,0 +1,186 @@
+//go:build integration
+
+package log_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1garo/kival/log"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSealedLog writes the given records into segment id, seals it and writes its hint.
+func newSealedLog(t *testing.T, dir string, id uint32, records [][2]string) {
+	t.Helper()
+
+	l, err := newLog(id, dir)
+	require.NoError(t, err)
+
+	for _, r := range records {
+		var val []byte
+		if r[1] != "" {
+			val = []byte(r[1])
+		}
+		_, err := l.Append([]byte(r[0]), val)
+		require.NoError(t, err)
+	}
+
+	l.MarkReadOnly()
+	require.NoError(t, l.WriteHint())
+	require.NoError(t, l.Close())
+}
+
+func TestWriteHint_CreatesHintFile(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}})
+
+	_, err := os.Stat(filepath.Join(dir, "1.hint"))
+	assert.NoError(t, err, "hint file should exist")
+}
+
+func TestOpen_PrefersHintOverScan(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}, {"k3", "v3"}})
+
+	// corrupt the first record, a scan would stop there and find no keys
+	path := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[log.SegmentHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	createTestLogFile(t, filepath.Join(dir, "2.data"), nil)
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.Len(t, index, 3, "index should be loaded from the hint file")
+}
+
+func TestOpen_FallsBackToScanOnCorruptHint(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}})
+
+	path := filepath.Join(dir, "1.hint")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	createTestLogFile(t, filepath.Join(dir, "2.data"), nil)
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	require.Len(t, index, 2, "index should be rebuilt from the data file")
+	val, err := logs[1].ReadAt(index["k2"])
+	require.NoError(t, err)
+	assert.Equal(t, "v2", string(val))
+}
+
+func TestOpen_FallsBackToScanOnMissingHint(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}})
+	require.NoError(t, os.Remove(filepath.Join(dir, "1.hint")))
+
+	createTestLogFile(t, filepath.Join(dir, "2.data"), nil)
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.Contains(t, index, "k1")
+}
+
+func TestOpen_HintKeepsLatestVersionAndTombstones(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "old"}, {"k2", "v2"}})
+	newSealedLog(t, dir, 2, [][2]string{{"k1", "new"}, {"k2", ""}})
+	createTestLogFile(t, filepath.Join(dir, "3.data"), nil)
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.NotContains(t, index, "k2", "tombstone in hint should delete the key")
+
+	pos, ok := index["k1"]
+	require.True(t, ok)
+	assert.Equal(t, uint32(2), pos.FileID)
+
+	val, err := logs[pos.FileID].ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(val))
+}
+
+func TestNew_RemovesStaleHint(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}})
+
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = os.Stat(filepath.Join(dir, "1.hint"))
+	assert.True(t, os.IsNotExist(err), "stale hint should be removed")
+}
+
+// legacyHint rewrites a hint file in the format used before hint entries
+// carried flags: no magic, and no flags byte in the entries.
+func legacyHint(t *testing.T, buf []byte) []byte {
+	t.Helper()
+
+	const magicSize, entrySize = 4, 29
+	require.Equal(t, "KIVH", string(buf[:magicSize]))
+
+	body := buf[magicSize : len(buf)-4]
+	var out []byte
+	for len(body) > 0 {
+		keySize := int(binary.LittleEndian.Uint32(body[12:16]))
+		out = append(out, body[:entrySize-1]...)
+		out = append(out, body[entrySize:entrySize+keySize]...)
+		body = body[entrySize+keySize:]
+	}
+
+	return binary.LittleEndian.AppendUint32(out, crc32.Checksum(out, crc32.MakeTable(crc32.Castagnoli)))
+}
+
+func TestOpen_ReadsLegacyHint(t *testing.T) {
+	dir := t.TempDir()
+
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}})
+
+	path := filepath.Join(dir, "1.hint")
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, legacyHint(t, data), 0o644))
+
+	// a scan would stop at the corrupt first record and find no keys
+	dataPath := filepath.Join(dir, "1.data")
+	data, err = os.ReadFile(dataPath)
+	require.NoError(t, err)
+	data[log.SegmentHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(dataPath, data, 0o644))
+
+	createTestLogFile(t, filepath.Join(dir, "2.data"), nil)
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.Len(t, index, 2, "index should be loaded from the legacy hint file")
+}
@@ -0,0 +1,44 @@
+package log
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const lockFileName = "LOCK"
+
+var ErrDatabaseLocked = errors.New("database is locked by another process")
+
+// lockDir takes an advisory lock on the LOCK file inside dir.
+// Writers need an exclusive lock while read-only openers can share it.
+// The lock is held for as long as the returned file stays open.
+//
+// Read-only openers do not create LOCK, so they work on directories they
+// cannot write to. When it is missing, as in a copy of the directory, they go
+// on without a lock and the returned file is nil.
+func lockDir(dir string, shared bool) (*os.File, error) {
+	name := filepath.Join(dir, lockFileName)
+
+	var f *os.File
+	var err error
+	if shared {
+		// flock takes a shared lock on a file open for reads only
+		f, err = os.Open(name)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+	} else {
+		f, err = os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0o644)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := flock(f, shared); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	return f, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package log
+
+import "os"
+
+// flock is a no-op where flock(2) is not available, so the directory is not
+// protected against a second opener there.
+func flock(_ *os.File, _ bool) error {
+	return nil
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package log
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func flock(f *os.File, shared bool) error {
+	how := syscall.LOCK_EX
+	if shared {
+		how = syscall.LOCK_SH
+	}
+
+	err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return ErrDatabaseLocked
+	}
+
+	return err
+}
@@ -1,14 +1,17 @@
 package log
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/1garo/kival/record"
@@ -24,34 +27,92 @@ type SyncStrategy int
 const (
 	Always SyncStrategy = iota
 	EveryN
+	// GroupCommit leaves the sync to the caller, so concurrent writers can
+	// share one. The kv package queues writes that arrive together, appends
+	// them with AppendEntries, syncs once and only then acknowledges them, so
+	// a write is as durable on return as with Always.
+	GroupCommit
+	// Never leaves syncing to the OS, a crash may lose any write that was not
+	// synced with Sync. It is meant for benchmarks.
+	Never
+	// Interval syncs the active log file in the background every interval,
+	// see WithSyncInterval. A crash may lose the writes of the last interval.
+	Interval
 )
 
-var (
-	ErrCapacityExceeded = errors.New("capacity exceeded creation failed")
-	ErrReadOnlySegment  = errors.New("file is in readonly state, cannot write to it")
-	ErrLogClosed        = errors.New("log is closed")
+const (
+	// DefaultMaxSegmentSize is the size a log file is rotated at, see WithMaxSegmentSize.
+	DefaultMaxSegmentSize = 64 << 20
+	// MinSegmentSize is the smallest size WithMaxSegmentSize accepts.
+	MinSegmentSize = 1 << 10
+	// MaxSegmentSize is the largest size a log file grows to, so offsets in
+	// a log file fit in 32 bits. It is also the largest size
+	// WithMaxSegmentSize accepts.
+	MaxSegmentSize = 1<<32 - 1
+	// DefaultSyncInterval is how often Interval syncs, see WithSyncInterval.
+	DefaultSyncInterval = time.Second
 )
 
-var MaxDataFileSize = 1500 // 1.5 KB for faster tests
+var (
+	ErrCapacityExceeded    = errors.New("capacity exceeded creation failed")
+	ErrReadOnlySegment     = errors.New("file is in readonly state, cannot write to it")
+	ErrLogClosed           = errors.New("log is closed")
+	ErrInvalidSegmentSize  = errors.New("invalid max segment size")
+	ErrWriteTooLarge       = errors.New("write does not fit in a log file")
+	ErrInvalidSyncInterval = errors.New("sync interval must be positive")
+)
 
 type Log interface {
 	Append(key, val []byte) (pos LogPosition, err error)
+	AppendEntry(e Entry) (pos LogPosition, err error)
+	AppendBatch(entries []Entry) ([]LogPosition, error)
+	AppendEntries(entries []Entry) ([]LogPosition, error)
 	ReadAt(pos LogPosition) ([]byte, error)
 	Size() int64
+	DataSize() int64
 	ID() uint32
+	Sync() error
+	SyncStrategy() SyncStrategy
 	Close() error
 	MarkReadOnly()
 	WriteCount() int32
+	WriteHint() error
+	Rotate() (Log, error)
+}
+
+// Entry is a single write to the log, a nil Value deletes the key.
+type Entry struct {
+	Key    []byte
+	Value  []byte
+	Expiry uint32 // see record.Expiry, 0 means it never expires
+	Meta   bool   // Value starts with metadata, see record.FlagMeta
+}
+
+// flags returns the record flags e is written with.
+func (e Entry) flags() record.Flag {
+	if e.Meta {
+		return record.FlagMeta
+	}
+	return 0
 }
 
 // LogPosition is the position of the data inside the log files
 type LogPosition struct {
 	FileID    uint32 // which segment file
 	ValuePos  int64  // where the record starts inside that file
+	KeySize   uint32 // size of the key in the file, larger than the key once encrypted
 	ValueSize uint32
+	Expiry    uint32         // see record.Expiry, 0 means it never expires
+	Meta      bool           // the value starts with metadata, see record.FlagMeta
+	Version   record.Version // record version of the segment, which sets the header size
 	timestamp uint32
 }
 
+// Expired reports whether the record at this position is expired at now.
+func (p LogPosition) Expired(now time.Time) bool {
+	return record.Expired(p.Expiry, now)
+}
+
 func NewLogPosition(fileID, valueSize, timestamp uint32, valuePos int64) LogPosition {
 	return LogPosition{
 		FileID:    fileID,
@@ -87,13 +148,127 @@ func WithSyncEveryN(n int32) Option {
 	}
 }
 
+// WithSyncInterval sets how often the Interval strategy syncs.
+func WithSyncInterval(d time.Duration) Option {
+	return func(lf *logFile) error {
+		if d <= 0 {
+			return fmt.Errorf("%w: %s", ErrInvalidSyncInterval, d)
+		}
+
+		lf.syncInterval = d
+		return nil
+	}
+}
+
+// WithMaxSegmentSize sets the size in bytes a log file is rotated at.
+// It must be between MinSegmentSize and MaxSegmentSize, the default is
+// DefaultMaxSegmentSize.
+func WithMaxSegmentSize(size int64) Option {
+	return func(lf *logFile) error {
+		if size < MinSegmentSize {
+			return fmt.Errorf("%w: %d is below the minimum of %d bytes", ErrInvalidSegmentSize, size, MinSegmentSize)
+		}
+		if size > MaxSegmentSize {
+			return fmt.Errorf("%w: %d is above the maximum of %d bytes", ErrInvalidSegmentSize, size, int64(MaxSegmentSize))
+		}
+
+		lf.maxSize = size
+		return nil
+	}
+}
+
+// WithCompression compresses the values written to the log with c, see
+// record.Compress. The codec must be registered with record.RegisterCodec so
+// the values can be read back; values already on disk keep their codec.
+func WithCompression(c record.Codec) Option {
+	return func(lf *logFile) error {
+		if c == nil {
+			return record.ErrInvalidCodec
+		}
+		if _, err := record.LookupCodec(c.ID()); err != nil {
+			return err
+		}
+
+		lf.codec = c
+		return nil
+	}
+}
+
+// WithEncryption encrypts the keys and values written to the log with the
+// current key of keys, see record.Encrypt. Records already encrypted are read
+// with the key they were written with, so keys must hold every key still in
+// use by the log files.
+func WithEncryption(keys record.KeyProvider) Option {
+	return func(lf *logFile) error {
+		if keys == nil {
+			return record.ErrNoKeyProvider
+		}
+
+		lf.keys = keys
+		return nil
+	}
+}
+
+// WithLogger sets where the log reports what it does on its own, like cutting
+// off a torn write on Open. The default is slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(lf *logFile) error {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+
+		lf.logger = logger
+		return nil
+	}
+}
+
+// WithReadOnly opens the database for reads only.
+// Several read-only openers can share a directory as long as no writer holds it.
+func WithReadOnly() Option {
+	return func(lf *logFile) error {
+		lf.openReadOnly = true
+		return nil
+	}
+}
+
 // Open recreates the log state from the given path.
 // It goes through all the log files under the given path.
 // It returns the active log file, a map of log files, a map of log positions, and an error.
-func Open(path string, options ...Option) (*logFile, Logs, Index, error) {
-	if err := os.MkdirAll(path, 0o755); err != nil {
+//
+// The directory is locked for as long as the active log file stays open,
+// ErrDatabaseLocked is returned when another opener already holds it.
+func Open(path string, options ...Option) (_ *logFile, _ Logs, _ Index, err error) {
+	cfg, err := newLogFile(0, path, options...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// a read-only opener leaves the directory as it is, it may not be writable
+	if !cfg.openReadOnly {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	lock, err := lockDir(path, cfg.openReadOnly)
+	if err != nil {
 		return nil, nil, nil, err
 	}
+	defer func() {
+		if err != nil && lock != nil {
+			_ = lock.Close()
+		}
+	}()
+
+	// a merge interrupted by a crash is finished, or discarded if it never
+	// committed, before any log file is read.
+	if cfg.openReadOnly {
+		if fileExists(filepath.Join(path, mergeManifestName)) {
+			return nil, nil, nil, fmt.Errorf("%w: interrupted merge in %s", ErrReadOnlySegment, path)
+		}
+	} else if err := completeMerge(path); err != nil {
+		return nil, nil, nil, fmt.Errorf("cannot recover merge: %w", err)
+	}
 
 	files, _ := filepath.Glob(filepath.Join(path, "*.data"))
 	sort.Slice(files, func(i, j int) bool {
@@ -104,17 +279,32 @@ func Open(path string, options ...Option) (*logFile, Logs, Index, error) {
 
 	index := make(Index)
 	logs := make(Logs)
+	var active *logFile
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, lf := range logs {
+			_ = lf.Close()
+		}
+		if active != nil {
+			_ = active.Close()
+		}
+	}()
 
 	if len(files) == 0 {
+		if cfg.openReadOnly {
+			return nil, nil, nil, fmt.Errorf("%w: no log files in %s", os.ErrNotExist, path)
+		}
+
 		lf, err := New(1, path, options...)
 		if err != nil {
 			return nil, nil, nil, err
 		}
+		lf.lock = lock
 		return lf, logs, index, nil
 	}
 
-	var active *logFile
-
 	for i, f := range files {
 		id := parseFileID(f)
 
@@ -123,90 +313,278 @@ func Open(path string, options ...Option) (*logFile, Logs, Index, error) {
 			return nil, nil, nil, err
 		}
 
-		if err := lf.BuildIndex(index); err != nil {
-			return nil, nil, nil, err
+		// sealed segments can be loaded from their hint file, the active one is
+		// always scanned since it may have grown after its last hint.
+		isLatest := i == len(files)-1
+		if isLatest || lf.loadHint(index) != nil {
+			if err := lf.BuildIndex(index); err != nil {
+				_ = lf.Close()
+				return nil, nil, nil, err
+			}
 		}
 
-		isLatest := i == len(files)-1
-		if isLatest {
+		// records are only appended in the current layout, a latest log file
+		// written in an older one is sealed and a new one takes its place. So
+		// is one holding valid records past a corrupt one, see truncateTail.
+		appendable := lf.version == record.CurrentVersion
+		if isLatest && appendable && !cfg.openReadOnly {
+			if appendable, err = lf.truncateTail(); err != nil {
+				_ = lf.Close()
+				return nil, nil, nil, err
+			}
+		}
+		if isLatest && (appendable || cfg.openReadOnly) {
 			active = lf
-		} else {
-			logs[id] = lf
+			continue
+		}
+
+		logs[id] = lf
+		if isLatest {
+			lf.readOnly = true
+			if active, err = New(id+1, path, options...); err != nil {
+				return nil, nil, nil, err
+			}
 		}
 	}
 
+	active.lock = lock
 	return active, logs, index, nil
 }
 
 // logFile represents a log file.
+// Appends are serialized by mu, while reads only share it so they never
+// observe a half-updated writePos or a file closed underneath them.
 type logFile struct {
+	mu           sync.RWMutex
 	id           uint32
+	dir          string
+	options      []Option
 	file         *os.File
+	lock         *os.File // directory lock, held by the active log file
 	writePos     int64
 	writeCount   int32
 	readOnly     bool
+	openReadOnly bool
 	closed       bool
 	syncStrategy SyncStrategy
 	syncEveryN   int32
+	syncInterval time.Duration
+	syncer       *syncer // runs while an Interval log file has unsynced writes
+	syncErr      error   // last error of the syncer, returned by Sync and Close
+	dirty        bool    // writes were made since the last sync
+	maxSize      int64
+	version      record.Version     // layout of the records, from the segment header
+	dataStart    int64              // offset of the first record, past the segment header
+	merged       bool               // written by a merge, see segmentMerged
+	codec        record.Codec       // compresses appended values, nil stores them as is
+	keys         record.KeyProvider // encrypts appended records, nil stores them in clear
+	logger       *slog.Logger
+}
+
+// newLogFile builds a log file with the defaults and the given options applied.
+func newLogFile(id uint32, dir string, options ...Option) (*logFile, error) {
+	l := &logFile{
+		id:           id,
+		dir:          dir,
+		options:      options,
+		version:      record.CurrentVersion,
+		syncStrategy: Always,
+		syncEveryN:   1,
+		syncInterval: DefaultSyncInterval,
+		maxSize:      DefaultMaxSegmentSize,
+		logger:       slog.Default(),
+	}
+
+	for _, opt := range options {
+		if err := opt(l); err != nil {
+			return nil, err
+		}
+	}
+
+	return l, nil
 }
 
 // BuildIndex builds an index of keys and their positions in the log file.
+// Expired records are dropped like tombstones, since they hide older values too.
 func (d *logFile) BuildIndex(idx map[string]LogPosition) error {
-	offset := int64(0)
+	now := time.Now()
+	var keyErr error
+	offset, err := d.scan(func(rec record.Record, start int64) {
+		key, err := d.recordKey(rec)
+		if err != nil {
+			keyErr = cmp.Or(keyErr, err)
+			return
+		}
 
-	stat, err := d.file.Stat()
+		isTombstoneRecord := rec.ValueSize == 0
+		if isTombstoneRecord || record.Expired(rec.Expiry, now) {
+			delete(idx, string(key))
+			return
+		}
+
+		idx[string(key)] = LogPosition{
+			FileID:    d.id,
+			ValuePos:  start,
+			KeySize:   rec.KeySize,
+			ValueSize: rec.ValueSize,
+			Expiry:    rec.Expiry,
+			Meta:      rec.Flags&record.FlagMeta != 0,
+			Version:   d.version,
+			timestamp: rec.Timestamp,
+		}
+	})
 	if err != nil {
 		return err
 	}
+	if keyErr != nil {
+		return fmt.Errorf("log file %d: %w", d.id, keyErr)
+	}
+
+	// update WritePos to end of file
+	d.writePos = offset
+	return nil
+}
+
+// truncateTail cuts the log file right after its last valid record, as found
+// by BuildIndex, and syncs it. A write torn by a crash would otherwise be left
+// in place, with new records written over part of it and the rest of it after
+// them.
+//
+// When valid records follow the one that fails to decode, the damage is not a
+// torn write and cutting would lose them: the log file is left as is and
+// truncateTail returns false so Open seals it, Repair can salvage them. This
+// is checked whatever the decoding error, a damaged size field fails like a
+// torn write does.
+func (d *logFile) truncateTail() (bool, error) {
+	stat, err := d.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	size := stat.Size()
+	if d.writePos >= size {
+		return true, nil
+	}
+
+	_, _, err = record.DecodeVersion(d.version, d.file, d.writePos)
+	if err != nil {
+		if !isDecodeError(err) {
+			return false, err
+		}
+
+		next, found, err := d.nextRecord(d.writePos+1, size)
+		if err != nil {
+			return false, err
+		}
+		if found {
+			d.logger.Warn("valid records follow a corrupt one, sealing the log file, run a repair to salvage them",
+				"file", d.file.Name(), "offset", d.writePos, "next_record", next)
+			return false, nil
+		}
+	}
+
+	if err := d.file.Truncate(d.writePos); err != nil {
+		return false, err
+	}
+	if err := d.file.Sync(); err != nil {
+		return false, err
+	}
+
+	d.logger.Warn("discarded torn write at the end of the log file",
+		"file", d.file.Name(), "offset", d.writePos, "bytes", size-d.writePos)
+	return true, nil
+}
+
+// recordKey returns the key of rec as it was written, decrypting it when its
+// flags carry record.FlagEncrypted. Only the key and header of rec are read.
+func (d *logFile) recordKey(rec record.Record) ([]byte, error) {
+	if rec.Flags&record.FlagEncrypted == 0 {
+		return rec.Key, nil
+	}
+
+	return record.DecryptKey(rec, d.keys)
+}
+
+// scan decodes the records of the log file in order, calling fn with each
+// record and the offset it starts at. It stops at the first partial or corrupt
+// record and returns the offset right after the last valid one.
+//
+// Records of a batch are held back until its commit record is read, a batch
+// cut short by a crash is dropped and the returned offset points at its start.
+func (d *logFile) scan(fn func(rec record.Record, start int64)) (int64, error) {
+	type pendingRecord struct {
+		rec   record.Record
+		start int64
+	}
+
+	offset := d.dataStart
+	committed := d.dataStart
+	var batch []pendingRecord
+
+	stat, err := d.file.Stat()
+	if err != nil {
+		return 0, err
+	}
 	fileSize := stat.Size()
 
 	for offset < fileSize {
 		start := offset
-		rec, bytesRead, err := record.Decode(d.file, offset)
+		rec, bytesRead, err := record.DecodeVersion(d.version, d.file, offset)
 		if err != nil {
-			if err == io.EOF || errors.Is(err, record.ErrPartialWrite) || errors.Is(err, record.ErrCorruptRecord) {
+			if isDecodeError(err) {
 				break
 			}
 
-			return err
+			return 0, err
 		}
 
 		offset += bytesRead
 
-		isTombstoneRecord := rec.ValueSize == 0
-		if isTombstoneRecord {
-			delete(idx, string(rec.Key))
+		switch {
+		case rec.Flags&record.FlagBatchCommit != 0:
+			for _, p := range batch {
+				fn(p.rec, p.start)
+			}
+			batch = batch[:0]
+			fn(rec, start)
+		case rec.Flags&record.FlagBatch != 0:
+			batch = append(batch, pendingRecord{rec: rec, start: start})
 			continue
+		default:
+			// batches are written in a single write, a plain record right
+			// after an uncommitted one means the batch never completed.
+			batch = batch[:0]
+			fn(rec, start)
 		}
 
-		idx[string(rec.Key)] = LogPosition{
-			FileID:    d.id,
-			ValuePos:  start,
-			ValueSize: rec.ValueSize,
-			timestamp: rec.Timestamp,
-		}
+		committed = offset
 	}
 
-	// update WritePos to end of file
-	d.writePos = offset
-	return nil
+	return committed, nil
+}
+
+// isDecodeError reports whether err means there is no valid record at the
+// offset, as opposed to the file not being readable.
+func isDecodeError(err error) bool {
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, record.ErrPartialWrite) ||
+		errors.Is(err, record.ErrCorruptRecord) ||
+		errors.Is(err, record.ErrEmptyKey)
 }
 
 // New creates a new log file
 func New(id uint32, dir string, options ...Option) (*logFile, error) {
-	l := &logFile{
-		id:           id,
-		syncStrategy: Always,
-		syncEveryN:   1,
+	l, err := newLogFile(id, dir, options...)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, opt := range options {
-		if err := opt(l); err != nil {
-			return nil, err
-		}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
 	}
 
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	// a hint left behind by a previous segment with the same id would
+	// describe data that is about to be truncated.
+	if err := os.Remove(hintFileName(dir, id)); err != nil && !os.IsNotExist(err) {
 		return nil, err
 	}
 
@@ -220,12 +598,25 @@ func New(id uint32, dir string, options ...Option) (*logFile, error) {
 		return nil, err
 	}
 
-	pos, err := f.Seek(0, io.SeekEnd)
-	if err != nil {
+	header := encodeSegmentHeader(segmentHeader{
+		version: record.CurrentVersion,
+		created: time.Now(),
+		id:      id,
+		merged:  l.merged,
+	})
+	if _, err := f.WriteAt(header, 0); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	// a header torn by a crash would make the segment unreadable, unlike a
+	// torn record which is simply dropped on the next open.
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
 		return nil, err
 	}
 
-	l.writePos = pos
+	l.dataStart = SegmentHeaderSize
+	l.writePos = SegmentHeaderSize
 	l.file = f
 
 	return l, nil
@@ -233,46 +624,53 @@ func New(id uint32, dir string, options ...Option) (*logFile, error) {
 
 // openExisting opens an existing log file without truncating it.
 func openExisting(id uint32, dir string, options ...Option) (*logFile, error) {
-	l := &logFile{
-		id:           id,
-		syncStrategy: Always,
-		syncEveryN:   1,
+	l, err := newLogFile(id, dir, options...)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, opt := range options {
-		if err := opt(l); err != nil {
-			return nil, err
-		}
+	flag := os.O_RDWR
+	if l.openReadOnly {
+		flag = os.O_RDONLY
+		l.readOnly = true
 	}
 
 	fileName := filepath.Join(dir, fmt.Sprintf("%d.data", id))
 	f, err := os.OpenFile(
 		fileName,
-		os.O_RDWR,
+		flag,
 		0o644,
 	)
 	if err != nil {
 		return nil, err
 	}
 
+	l.file = f
+	if err := l.checkSegmentHeader(); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
 	pos, err := f.Seek(0, io.SeekEnd)
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
 
 	l.writePos = pos
-	l.file = f
 
 	return l, nil
 }
 
 // haveExceededCapacity checks if the log file has exceeded its capacity.
-func (d *logFile) haveExceededCapacity(key, val []byte) error {
-	keySize := uint32(len(key))
-	valSize := uint32(len(val))
-
-	recordSize := record.HeaderSize + keySize + valSize
-	if int64(recordSize)+d.writePos > int64(MaxDataFileSize) {
+// An empty log file takes a write of any size up to MaxSegmentSize, so a
+// record larger than the max size gets a log file of its own instead of
+// failing. A write that would not fit even then fails with ErrWriteTooLarge.
+func (d *logFile) haveExceededCapacity(size int64) error {
+	if d.dataStart+size > MaxSegmentSize {
+		return fmt.Errorf("%w: %d bytes", ErrWriteTooLarge, size)
+	}
+	if d.writePos > d.dataStart && size+d.writePos > d.maxSize {
 		return ErrCapacityExceeded
 	}
 	return nil
@@ -280,60 +678,157 @@ func (d *logFile) haveExceededCapacity(key, val []byte) error {
 
 // Append appends a key-value pair to the log file.
 func (d *logFile) Append(key, val []byte) (LogPosition, error) {
+	return d.AppendEntry(Entry{Key: key, Value: val})
+}
+
+// AppendEntry appends a single entry to the log file.
+func (d *logFile) AppendEntry(e Entry) (LogPosition, error) {
+	positions, err := d.append([]record.Record{{Key: e.Key, Value: e.Value, Expiry: e.Expiry, Flags: e.flags()}})
+	if err != nil {
+		return LogPosition{}, err
+	}
+
+	return positions[0], nil
+}
+
+// AppendBatch appends all the entries with a single write. When the log is
+// replayed either every entry is applied or none is.
+func (d *logFile) AppendBatch(entries []Entry) ([]LogPosition, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	recs := make([]record.Record, len(entries))
+	for i, e := range entries {
+		recs[i] = record.Record{Key: e.Key, Value: e.Value, Expiry: e.Expiry, Flags: record.FlagBatch | e.flags()}
+	}
+	recs[len(recs)-1].Flags |= record.FlagBatchCommit
+
+	return d.append(recs)
+}
+
+// AppendEntries appends independent entries with a single write, unlike
+// AppendBatch a crash may keep some of them and drop the rest.
+func (d *logFile) AppendEntries(entries []Entry) ([]LogPosition, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	recs := make([]record.Record, len(entries))
+	for i, e := range entries {
+		recs[i] = record.Record{Key: e.Key, Value: e.Value, Expiry: e.Expiry, Flags: e.flags()}
+	}
+
+	return d.append(recs)
+}
+
+// append encodes the records and writes them at the end of the log file at once.
+func (d *logFile) append(recs []record.Record) ([]LogPosition, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil, ErrLogClosed
+	}
 	if d.readOnly {
-		return LogPosition{}, ErrReadOnlySegment
+		return nil, ErrReadOnlySegment
 	}
-	start := d.writePos
 
-	if err := d.haveExceededCapacity(key, val); err != nil {
-		return LogPosition{}, err
+	var buf []byte
+	positions := make([]LogPosition, len(recs))
+	now := uint32(time.Now().Unix())
+	timestamp := record.Timestamp(time.Now())
+	for i, rec := range recs {
+		// set before encryption, which authenticates it
+		rec.Timestamp = timestamp
+		if d.codec != nil {
+			var err error
+			if rec, err = record.Compress(rec, d.codec); err != nil {
+				return nil, err
+			}
+		}
+		if d.keys != nil {
+			var err error
+			if rec, err = record.Encrypt(rec, d.keys); err != nil {
+				return nil, err
+			}
+		}
+
+		encoded := record.EncodeRecord(rec)
+		if len(encoded) == 0 {
+			return nil, record.ErrEncodeInput
+		}
+
+		positions[i] = NewLogPosition(
+			d.id,
+			uint32(len(rec.Value)),
+			now,
+			d.writePos+int64(len(buf)),
+		)
+		positions[i].KeySize = uint32(len(rec.Key))
+		positions[i].Expiry = rec.Expiry
+		positions[i].Meta = rec.Flags&record.FlagMeta != 0
+		positions[i].Version = d.version
+		buf = append(buf, encoded...)
+	}
+
+	if err := d.haveExceededCapacity(int64(len(buf))); err != nil {
+		return nil, err
 	}
 
-	buf := record.Encode(key, val)
 	n, err := d.file.WriteAt(buf, d.writePos)
 	if err != nil {
-		return LogPosition{}, err
+		return nil, err
 	}
 
 	d.writeCount++
+	d.dirty = true
 
 	switch d.syncStrategy {
 	case Always:
 		if err = d.file.Sync(); err != nil {
-			return LogPosition{}, err
+			return nil, err
 		}
+		d.dirty = false
 	case EveryN:
 		if d.writeCount == d.syncEveryN {
 			if err = d.file.Sync(); err != nil {
-				return LogPosition{}, err
+				return nil, err
 			}
 
 			d.writeCount = 0
+			d.dirty = false
+		}
+	case Interval:
+		if d.syncer == nil {
+			d.syncer = d.startSyncer()
 		}
 	}
 
 	d.writePos += int64(n)
 
-	return NewLogPosition(
-		d.id,
-		uint32(len(val)),
-		uint32(time.Now().Unix()),
-		start,
-	), nil
+	return positions, nil
 }
 
 // ReadAt reads a key-value pair from the log file at the given position.
 func (d *logFile) ReadAt(pos LogPosition) ([]byte, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	if d.closed {
 		return nil, ErrLogClosed
 	}
 
-	rec, _, err := record.Decode(d.file, pos.ValuePos)
+	rec, _, err := record.DecodeVersion(d.version, d.file, pos.ValuePos)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	return rec.Value, nil
+	if rec, err = record.Decrypt(rec, d.keys); err != nil {
+		return nil, err
+	}
+
+	return record.Decompress(rec)
 }
 
 // Size return the size of the log file.
@@ -342,23 +837,136 @@ func (d *logFile) Size() int64 {
 	return stat.Size()
 }
 
+// DataSize returns the size taken by records, that is Size without the
+// segment header.
+func (d *logFile) DataSize() int64 {
+	return d.Size() - d.dataStart
+}
+
 // ID returns the ID of the current log file.
 func (d *logFile) ID() uint32 {
 	return d.id
 }
 
-// Close closes the current log file.
+// Sync flushes every write done to the log file to disk.
+func (d *logFile) Sync() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return ErrLogClosed
+	}
+
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+
+	d.writeCount = 0
+	d.dirty = false
+	err := d.syncErr
+	d.syncErr = nil
+	return err
+}
+
+// SyncStrategy returns when the log file syncs its writes.
+func (d *logFile) SyncStrategy() SyncStrategy {
+	return d.syncStrategy
+}
+
+// Close closes the current log file, releasing the directory lock if it holds it.
+// Writes not synced yet are synced first, unless the strategy is Never.
 func (d *logFile) Close() error {
+	// the syncer is stopped once closed is set, so no write can start another
+	defer d.stopSyncer()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+
 	d.closed = true
-	return d.file.Close()
+	err := d.syncErr
+	if d.dirty && d.syncStrategy != Never {
+		err = errors.Join(err, d.file.Sync())
+	}
+	err = errors.Join(err, d.file.Close())
+	if d.lock != nil {
+		err = errors.Join(err, d.lock.Close())
+		d.lock = nil
+	}
+
+	return err
+}
+
+// Rotate seals the log file, writes its hint and returns the next log file.
+// The new log file inherits the options and the directory lock of this one.
+func (d *logFile) Rotate() (Log, error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrLogClosed
+	}
+	if d.readOnly {
+		d.mu.Unlock()
+		return nil, ErrReadOnlySegment
+	}
+	d.readOnly = true
+	d.mu.Unlock()
+
+	// a sealed log file is never synced again, flush what the strategy left
+	d.stopSyncer()
+	if d.syncStrategy != Never {
+		if err := d.syncIfDirty(); err != nil {
+			d.unseal()
+			return nil, fmt.Errorf("cannot sync log %d: %w", d.id, err)
+		}
+	}
+
+	if err := d.WriteHint(); err != nil {
+		d.unseal()
+		return nil, fmt.Errorf("cannot write hint for log %d: %w", d.id, err)
+	}
+
+	next, err := New(d.id+1, d.dir, d.options...)
+	if err != nil {
+		d.unseal()
+		return nil, fmt.Errorf("cannot create log %d: %w", d.id+1, err)
+	}
+
+	d.mu.Lock()
+	next.lock, d.lock = d.lock, nil
+	d.mu.Unlock()
+
+	return next, nil
+}
+
+// unseal takes back a Rotate that failed, so the log file goes on taking
+// writes and syncing them like before.
+func (d *logFile) unseal() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.readOnly = false
+	if d.syncStrategy == Interval && d.dirty && d.syncer == nil && !d.closed {
+		d.syncer = d.startSyncer()
+	}
 }
 
 // MarkReadOnly marks the current log file as read-only.
 func (d *logFile) MarkReadOnly() {
+	d.mu.Lock()
 	d.readOnly = true
+	d.mu.Unlock()
+
+	d.stopSyncer()
 }
 
 // WriteCount the amount of writes done to this file
 func (d *logFile) WriteCount() int32 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
 	return d.writeCount
 }
@@ -3,12 +3,16 @@
 package log_test
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/1garo/kival/log"
 	"github.com/1garo/kival/record"
@@ -16,11 +20,33 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// testSegmentSize keeps log files small so tests fill them quickly.
+const testSegmentSize = 1500
+
+func testOptions(opts []log.Option) []log.Option {
+	return append([]log.Option{log.WithMaxSegmentSize(testSegmentSize)}, opts...)
+}
+
+// newLog is log.New with log files of testSegmentSize bytes.
+func newLog(id uint32, dir string, opts ...log.Option) (log.Log, error) {
+	return log.New(id, dir, testOptions(opts)...)
+}
+
+// openLog is log.Open with log files of testSegmentSize bytes.
+func openLog(dir string, opts ...log.Option) (log.Log, log.Logs, log.Index, error) {
+	return log.Open(dir, testOptions(opts)...)
+}
+
+// newMerge is log.NewMerge with log files of testSegmentSize bytes.
+func newMerge(dir string, inputs []uint32, maxID uint32, opts ...log.Option) (*log.Merge, error) {
+	return log.NewMerge(dir, inputs, maxID, testOptions(opts)...)
+}
+
 func newTestLog(t *testing.T, opts ...log.Option) log.Log {
 	t.Helper()
 
 	dir := t.TempDir()
-	l, err := log.New(1, dir, opts...)
+	l, err := newLog(1, dir, opts...)
 	require.NoError(t, err)
 
 	t.Cleanup(func() { _ = l.Close() })
@@ -108,7 +134,7 @@ func TestLog_Append_InsertRecord(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, p.FileID, activeLog.ID(), "should return correct file ID")
-	assert.Equal(t, p.ValuePos, int64(0), "should return correct position")
+	assert.Equal(t, p.ValuePos, int64(log.SegmentHeaderSize), "should return correct position")
 	assert.Equal(t, p.ValueSize, uint32(len(val)), "should return correct value size")
 }
 
@@ -126,7 +152,7 @@ func TestLog_Append_ReadOnlySegmentError(t *testing.T) {
 func TestNew_LogFileCreation(t *testing.T) {
 	dir := t.TempDir()
 
-	l, err := log.New(1, dir)
+	l, err := newLog(1, dir)
 	require.NoError(t, err)
 	defer l.Close()
 
@@ -135,14 +161,15 @@ func TestNew_LogFileCreation(t *testing.T) {
 	assert.NoError(t, err, "log file should exist")
 
 	assert.Equal(t, uint32(1), l.ID(), "should have correct file ID")
-	assert.Equal(t, int64(0), l.Size(), "new file should be empty")
+	assert.Equal(t, int64(log.SegmentHeaderSize), l.Size(), "new file should only hold the header")
+	assert.Equal(t, int64(0), l.DataSize(), "new file should hold no records")
 }
 
 func TestNew_DirectoryCreation(t *testing.T) {
 	baseDir := t.TempDir()
 	nestedDir := filepath.Join(baseDir, "nested", "log", "dir")
 
-	l, err := log.New(42, nestedDir)
+	l, err := newLog(42, nestedDir)
 	require.NoError(t, err)
 	defer l.Close()
 
@@ -158,7 +185,7 @@ func TestNew_DirectoryCreation(t *testing.T) {
 func TestNew_FilePermissions(t *testing.T) {
 	dir := t.TempDir()
 
-	l, err := log.New(1, dir)
+	l, err := newLog(1, dir)
 	require.NoError(t, err)
 	defer l.Close()
 
@@ -172,7 +199,7 @@ func TestNew_FilePermissions(t *testing.T) {
 func TestOpen_EmptyDirectory(t *testing.T) {
 	dir := t.TempDir()
 
-	active, logs, index, err := log.Open(dir)
+	active, logs, index, err := openLog(dir)
 	require.NoError(t, err)
 	defer active.Close()
 
@@ -192,7 +219,7 @@ func TestOpen_ExistingFiles(t *testing.T) {
 	createTestLogFile(t, filepath.Join(dir, "2.data"), []byte("test2"))
 	createTestLogFile(t, filepath.Join(dir, "3.data"), []byte("test3"))
 
-	active, logs, _, err := log.Open(dir)
+	active, logs, _, err := openLog(dir)
 	require.NoError(t, err)
 	defer active.Close()
 
@@ -212,7 +239,7 @@ func TestOpen_MultipleFilesSorted(t *testing.T) {
 	createTestLogFile(t, filepath.Join(dir, "8.data"), []byte("test8"))
 	createTestLogFile(t, filepath.Join(dir, "1.data"), []byte("test1"))
 
-	active, logs, _, err := log.Open(dir)
+	active, logs, _, err := openLog(dir)
 	require.NoError(t, err)
 	defer active.Close()
 
@@ -231,7 +258,7 @@ func TestOpen_NonexistentDirectory(t *testing.T) {
 	subdir := filepath.Join(dir, "nonexistent")
 
 	// Should create directory and new log file
-	active, logs, index, err := log.Open(subdir)
+	active, logs, index, err := openLog(subdir)
 	require.NoError(t, err)
 	defer active.Close()
 
@@ -268,20 +295,24 @@ func TestParseFileID_ValidNames(t *testing.T) {
 }
 
 // Helper function to create test log files
+// createTestLogFile writes a log file of the current version holding content.
 func createTestLogFile(t *testing.T, path string, content []byte) {
 	t.Helper()
 
-	err := os.WriteFile(path, content, 0o644)
+	id, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), ".data"), 10, 32)
 	require.NoError(t, err)
+
+	data := append(log.SegmentHeader(uint32(id), record.CurrentVersion), content...)
+	require.NoError(t, os.WriteFile(path, data, 0o644))
 }
 
 func TestAppend_CapacityExceeded(t *testing.T) {
 	l := newTestLog(t)
 
-	// Create a large key-value pair that will consume most of MaxDataFileSize
+	// Create a large key-value pair that will consume most of testSegmentSize
 	// Record size = header(16) + keySize(100) + valueSize(380) = 496 bytes
 	largeKey := make([]byte, 100)
-	largeValue := make([]byte, int(log.MaxDataFileSize)-100-int(record.HeaderSize)-20) // fill most of capacity
+	largeValue := make([]byte, testSegmentSize-100-int(record.HeaderSize)-20) // fill most of capacity
 
 	// First append should succeed
 	_, err := l.Append(largeKey, largeValue)
@@ -307,8 +338,8 @@ func TestAppend_ExactlyAtCapacity(t *testing.T) {
 	require.NoError(t, err)
 
 	// Calculate remaining capacity and create exact fit record
-	// MaxDataFileSize is 500, header is 16 bytes, current size includes first record
-	remainingCapacity := log.MaxDataFileSize - int(l.Size())
+	// current size includes the first record
+	remainingCapacity := testSegmentSize - int(l.Size())
 	keySize := 8
 	valueSize := remainingCapacity - int(record.HeaderSize) - keySize // 16 for header
 	t.Logf("Remaining capacity: %d", remainingCapacity)
@@ -343,7 +374,22 @@ func TestAppend_MultipleRecordsUntilFull(t *testing.T) {
 	}
 
 	assert.Greater(t, recordCount, 0, "should have multiple records")
-	assert.Less(t, l.Size(), int64(log.MaxDataFileSize), "should not exceed max capacity")
+	assert.Less(t, l.Size(), int64(testSegmentSize), "should not exceed max capacity")
+}
+
+func TestAppend_OversizedRecordInEmptyLog(t *testing.T) {
+	l := newTestLog(t)
+
+	big := make([]byte, 3*testSegmentSize)
+	pos, err := l.Append([]byte("big"), big)
+	require.NoError(t, err, "an empty log takes a record of any size")
+
+	got, err := l.ReadAt(pos)
+	require.NoError(t, err)
+	assert.Equal(t, big, got)
+
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	assert.ErrorIs(t, err, log.ErrCapacityExceeded, "the oversized record has the log to itself")
 }
 
 func TestMarkReadOnly_PreventsAppend(t *testing.T) {
@@ -402,3 +448,477 @@ func TestAppend_EveryNSync_SyncsAtThreshold(t *testing.T) {
 
 	assert.EqualValues(t, 0, l.WriteCount())
 }
+
+func TestSync_ResetsWriteCount(t *testing.T) {
+	l := newTestLog(
+		t,
+		log.WithSyncStrategy(log.EveryN),
+		log.WithSyncEveryN(3),
+	)
+
+	_, err := l.Append([]byte("key"), []byte("value"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, l.WriteCount())
+
+	require.NoError(t, l.Sync())
+	assert.EqualValues(t, 0, l.WriteCount(), "sync should flush the pending writes")
+}
+
+func TestSync_AfterCloseReturnsError(t *testing.T) {
+	l := newTestLog(t)
+	require.NoError(t, l.Close())
+
+	assert.ErrorIs(t, l.Sync(), log.ErrLogClosed)
+}
+
+func TestAppend_MaxSegmentSizeIsPerLog(t *testing.T) {
+	small, err := newLog(1, t.TempDir())
+	require.NoError(t, err)
+	defer small.Close()
+
+	large, err := log.New(1, t.TempDir())
+	require.NoError(t, err)
+	defer large.Close()
+
+	val := make([]byte, testSegmentSize/2)
+	for _, l := range []log.Log{small, large} {
+		_, err = l.Append([]byte("k1"), val)
+		require.NoError(t, err)
+	}
+
+	_, err = small.Append([]byte("k2"), val)
+	assert.ErrorIs(t, err, log.ErrCapacityExceeded)
+
+	_, err = large.Append([]byte("k2"), val)
+	assert.NoError(t, err, "the default size should fit it")
+}
+
+func TestOpen_InvalidMaxSegmentSize(t *testing.T) {
+	dir := t.TempDir()
+
+	_, _, _, err := log.Open(dir, log.WithMaxSegmentSize(log.MinSegmentSize-1))
+	assert.ErrorIs(t, err, log.ErrInvalidSegmentSize)
+
+	_, _, _, err = log.Open(dir, log.WithMaxSegmentSize(-1))
+	assert.ErrorIs(t, err, log.ErrInvalidSegmentSize)
+
+	_, _, _, err = log.Open(dir, log.WithMaxSegmentSize(log.MaxSegmentSize+1))
+	assert.ErrorIs(t, err, log.ErrInvalidSegmentSize, "offsets must fit in 32 bits")
+
+	active, _, _, err := log.Open(dir, log.WithMaxSegmentSize(log.MinSegmentSize))
+	require.NoError(t, err, "the lock should not be left behind")
+	require.NoError(t, active.Close())
+}
+
+func TestRotate_KeepsMaxSegmentSize(t *testing.T) {
+	l, err := newLog(1, t.TempDir())
+	require.NoError(t, err)
+
+	rotated, err := l.Rotate()
+	require.NoError(t, err)
+	defer rotated.Close()
+	require.NoError(t, l.Close())
+
+	_, err = rotated.Append([]byte("k1"), make([]byte, testSegmentSize/2))
+	require.NoError(t, err)
+	_, err = rotated.Append([]byte("k2"), make([]byte, testSegmentSize/2))
+	assert.ErrorIs(t, err, log.ErrCapacityExceeded)
+}
+
+func TestOpen_LockedDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	active, _, _, err := openLog(dir)
+	require.NoError(t, err)
+
+	_, _, _, err = openLog(dir)
+	assert.ErrorIs(t, err, log.ErrDatabaseLocked)
+
+	require.NoError(t, active.Close())
+
+	active, _, _, err = openLog(dir)
+	require.NoError(t, err, "lock should be released when the active log is closed")
+	require.NoError(t, active.Close())
+}
+
+func TestRotate_HandsOverLock(t *testing.T) {
+	dir := t.TempDir()
+
+	active, _, _, err := openLog(dir)
+	require.NoError(t, err)
+
+	next, err := active.Rotate()
+	require.NoError(t, err)
+	assert.Equal(t, active.ID()+1, next.ID())
+	require.NoError(t, active.Close())
+
+	_, _, _, err = openLog(dir)
+	assert.ErrorIs(t, err, log.ErrDatabaseLocked, "rotated log should keep the lock")
+
+	require.NoError(t, next.Close())
+
+	reopened, _, _, err := openLog(dir)
+	require.NoError(t, err)
+	require.NoError(t, reopened.Close())
+}
+
+func TestRotate_SealsLog(t *testing.T) {
+	l := newTestLog(t, log.WithSyncStrategy(log.EveryN), log.WithSyncEveryN(10))
+
+	next, err := l.Rotate()
+	require.NoError(t, err)
+	defer next.Close()
+
+	_, err = l.Append([]byte("key"), []byte("value"))
+	assert.ErrorIs(t, err, log.ErrReadOnlySegment)
+
+	_, err = l.Rotate()
+	assert.ErrorIs(t, err, log.ErrReadOnlySegment, "a sealed log cannot be rotated again")
+
+	_, err = next.Append([]byte("key"), []byte("value"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, next.WriteCount(), "options should carry over to the next log")
+}
+
+func TestRotate_FailureKeepsLogWritable(t *testing.T) {
+	dir := t.TempDir()
+	l, err := newLog(1, dir, log.WithSyncStrategy(log.Interval), log.WithSyncInterval(time.Hour))
+	require.NoError(t, err)
+	defer l.Close()
+
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+
+	// the next log file cannot be created where a directory takes its name
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "2.data"), 0o755))
+	_, err = l.Rotate()
+	require.Error(t, err)
+
+	_, err = l.Append([]byte("k2"), []byte("v2"))
+	require.NoError(t, err, "a failed rotation leaves the log writable")
+	assert.True(t, log.SyncerRunning(l), "the syncer stopped by Rotate runs again")
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "2.data")))
+	next, err := l.Rotate()
+	require.NoError(t, err, "the rotation can be retried")
+	defer next.Close()
+
+	_, err = l.Append([]byte("k3"), []byte("v3"))
+	assert.ErrorIs(t, err, log.ErrReadOnlySegment)
+}
+
+func TestAppendBatch_ReturnsPositions(t *testing.T) {
+	l := newTestLog(t)
+
+	positions, err := l.AppendBatch([]log.Entry{
+		{Key: []byte("k1"), Value: []byte("v1")},
+		{Key: []byte("k2"), Value: []byte("v22")},
+	})
+	require.NoError(t, err)
+	require.Len(t, positions, 2)
+
+	val, err := l.ReadAt(positions[1])
+	require.NoError(t, err)
+	assert.Equal(t, "v22", string(val))
+	assert.EqualValues(t, 3, positions[1].ValueSize)
+	assert.EqualValues(t, 1, l.WriteCount(), "a batch should be a single write")
+}
+
+func TestAppendBatch_ReplayedOnOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	_, err = l.AppendBatch([]log.Entry{
+		{Key: []byte("k1")},
+		{Key: []byte("k2"), Value: []byte("v2")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.NotContains(t, index, "k1", "delete in batch should be applied")
+	assert.Contains(t, index, "k2")
+}
+
+func TestAppendBatch_UncommittedBatchIsDropped(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	committed := l.Size()
+
+	_, err = l.AppendBatch([]log.Entry{
+		{Key: []byte("k2"), Value: []byte("v2")},
+		{Key: []byte("k3"), Value: []byte("v3")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// drop the commit record, leaving the first record of the batch intact
+	path := filepath.Join(dir, "1.data")
+	require.NoError(t, os.Truncate(path, committed+int64(record.HeaderSize)+4))
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.Contains(t, index, "k1")
+	assert.NotContains(t, index, "k2")
+	assert.Equal(t, committed, active.Size(), "the torn batch should be cut off")
+
+	// the next append should land where the torn batch started
+	pos, err := active.Append([]byte("k4"), []byte("v4"))
+	require.NoError(t, err)
+	assert.Equal(t, committed, pos.ValuePos)
+}
+
+func TestAppendEntries_SingleWrite(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := newLog(1, dir, log.WithSyncStrategy(log.GroupCommit))
+	require.NoError(t, err)
+
+	positions, err := l.AppendEntries([]log.Entry{
+		{Key: []byte("k1"), Value: []byte("v1")},
+		{Key: []byte("k2"), Value: []byte("v22")},
+		{Key: []byte("k1")},
+	})
+	require.NoError(t, err)
+	require.Len(t, positions, 3)
+	assert.EqualValues(t, 1, l.WriteCount(), "entries should be a single write")
+
+	val, err := l.ReadAt(positions[1])
+	require.NoError(t, err)
+	assert.Equal(t, "v22", string(val))
+
+	require.NoError(t, l.Sync())
+	require.NoError(t, l.Close())
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.NotContains(t, index, "k1", "entries are replayed in order")
+	assert.Contains(t, index, "k2")
+}
+
+func TestAppendEntries_CapacityExceeded(t *testing.T) {
+	l := newTestLog(t)
+
+	_, err := l.Append([]byte("k0"), []byte("v0"))
+	require.NoError(t, err)
+	size := l.Size()
+
+	val := make([]byte, testSegmentSize/4)
+	entries := make([]log.Entry, 4)
+	for i := range entries {
+		entries[i] = log.Entry{Key: fmt.Appendf(nil, "k%d", i+1), Value: val}
+	}
+
+	_, err = l.AppendEntries(entries)
+	assert.ErrorIs(t, err, log.ErrCapacityExceeded)
+	assert.Equal(t, size, l.Size(), "entries that do not fit should not be written at all")
+}
+
+func TestBuildIndex_SkipsExpiredRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+
+	_, err = l.Append([]byte("k1"), []byte("old"))
+	require.NoError(t, err)
+	_, err = l.AppendEntry(log.Entry{
+		Key:    []byte("k1"),
+		Value:  []byte("expired"),
+		Expiry: record.Expiry(time.Now().Add(-time.Hour)),
+	})
+	require.NoError(t, err)
+	live, err := l.AppendEntry(log.Entry{
+		Key:    []byte("k2"),
+		Value:  []byte("live"),
+		Expiry: record.Expiry(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.NotContains(t, index, "k1", "expired record should hide older values")
+	require.Contains(t, index, "k2")
+	assert.Equal(t, live.Expiry, index["k2"].Expiry)
+	assert.False(t, index["k2"].Expired(time.Now()))
+}
+
+func TestLoadHint_SkipsExpiredRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+
+	_, err = l.AppendEntry(log.Entry{
+		Key:    []byte("k1"),
+		Value:  []byte("expired"),
+		Expiry: record.Expiry(time.Now().Add(-time.Hour)),
+	})
+	require.NoError(t, err)
+	_, err = l.AppendEntry(log.Entry{
+		Key:    []byte("k2"),
+		Value:  []byte("live"),
+		Expiry: record.Expiry(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	next, err := l.Rotate()
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+	require.NoError(t, next.Close())
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.NotContains(t, index, "k1")
+	assert.Contains(t, index, "k2")
+}
+
+// captureLogger returns a logger writing to the returned buffer.
+func captureLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(slog.NewTextHandler(&buf, nil)), &buf
+}
+
+func TestOpen_TruncatesTornWrite(t *testing.T) {
+	torn := record.EncodeRecord(record.Record{Key: []byte("k3"), Value: []byte("torn value")})
+
+	for n := 1; n < len(torn); n++ {
+		t.Run(strconv.Itoa(n), func(t *testing.T) {
+			dir := t.TempDir()
+			l, err := newLog(1, dir)
+			require.NoError(t, err)
+			_, err = l.Append([]byte("k1"), []byte("v1"))
+			require.NoError(t, err)
+			_, err = l.Append([]byte("k2"), []byte("v2"))
+			require.NoError(t, err)
+			valid := l.Size()
+			require.NoError(t, l.Close())
+
+			path := filepath.Join(dir, "1.data")
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+			require.NoError(t, err)
+			_, err = f.Write(torn[:n])
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			logger, logs := captureLogger()
+			active, _, index, err := openLog(dir, log.WithLogger(logger))
+			require.NoError(t, err)
+
+			assert.Equal(t, uint32(1), active.ID())
+			assert.Contains(t, index, "k2")
+			assert.NotContains(t, index, "k3")
+			stat, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, valid, stat.Size(), "the torn write should be cut off")
+			assert.Contains(t, logs.String(), "discarded torn write")
+			assert.Contains(t, logs.String(), fmt.Sprintf("bytes=%d", n))
+
+			// a shorter record must not leave part of the torn one behind it
+			_, err = active.Append([]byte("k4"), []byte("v"))
+			require.NoError(t, err)
+			require.NoError(t, active.Close())
+
+			active, _, index, err = openLog(dir, log.WithLogger(logger))
+			require.NoError(t, err)
+			defer active.Close()
+
+			val, err := active.ReadAt(index["k4"])
+			require.NoError(t, err)
+			assert.Equal(t, "v", string(val))
+			assert.Equal(t, active.Size(), index["k4"].ValuePos+int64(record.HeaderSize)+3)
+		})
+	}
+}
+
+func TestOpen_TruncatesZeroedTail(t *testing.T) {
+	dir := t.TempDir()
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	valid := l.Size()
+	require.NoError(t, l.Close())
+
+	// the file grew but its data never made it to disk
+	path := filepath.Join(dir, "1.data")
+	require.NoError(t, os.Truncate(path, valid+100))
+
+	logger, logs := captureLogger()
+	active, _, index, err := openLog(dir, log.WithLogger(logger))
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.Contains(t, index, "k1")
+	assert.Equal(t, valid, active.Size())
+	assert.Contains(t, logs.String(), "bytes=100")
+}
+
+func TestOpen_SealsLogWithRecordsPastCorruption(t *testing.T) {
+	for name, damage := range map[string]func(rec []byte){
+		"checksum": func(rec []byte) { rec[record.HeaderSize] ^= 0xff },
+		// the value size points past the end of the file, like a torn write
+		"value size": func(rec []byte) { rec[record.HeaderSize-1] = 0x7f },
+		"zeroed key size": func(rec []byte) {
+			binary.LittleEndian.PutUint32(rec[13:17], 0)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			l, err := newLog(1, dir)
+			require.NoError(t, err)
+			_, err = l.Append([]byte("k1"), []byte("v1"))
+			require.NoError(t, err)
+			corrupt := l.Size()
+			for _, k := range []string{"k2", "k3", "k4"} {
+				_, err = l.Append([]byte(k), []byte("v"))
+				require.NoError(t, err)
+			}
+			size := l.Size()
+			require.NoError(t, l.Close())
+
+			path := filepath.Join(dir, "1.data")
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			damage(data[corrupt:])
+			require.NoError(t, os.WriteFile(path, data, 0o644))
+
+			logger, logs := captureLogger()
+			active, sealed, index, err := openLog(dir, log.WithLogger(logger))
+			require.NoError(t, err)
+
+			assert.Equal(t, uint32(2), active.ID(), "appends should go to a new log file")
+			require.Contains(t, sealed, uint32(1))
+			assert.Equal(t, size, sealed[1].Size(), "records past the corruption are kept for repair")
+			assert.Contains(t, index, "k1")
+			assert.Contains(t, logs.String(), "run a repair")
+			assert.NotContains(t, logs.String(), "torn write")
+			require.NoError(t, active.Close())
+
+			report, err := log.Repair(dir)
+			require.NoError(t, err)
+			assert.Equal(t, 2, report.Segments[0].Recovered)
+		})
+	}
+}
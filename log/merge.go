@@ -0,0 +1,348 @@
+package log
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergeDirName      = "merge"
+	mergeManifestName = "MERGE"
+)
+
+var ErrMergeTooLarge = errors.New("merge output needs more log files than ids available")
+
+// mergeHook is called at every step of a merge that changes the disk, tests
+// use it to simulate a crash at that point.
+var mergeHook func(step string)
+
+func mergeStep(step string) {
+	if mergeHook != nil {
+		mergeHook(step)
+	}
+}
+
+// Merge rewrites the live records of sealed log files into new ones.
+//
+// Output log files are written to a temporary directory and swapped in by
+// Commit, which first records the swap in a manifest. Once the manifest is on
+// disk the merge is completed even if the process crashes, Open finishes it.
+// Without the manifest the temporary directory is discarded and the inputs
+// are left untouched.
+type Merge struct {
+	dir     string
+	tmpDir  string
+	inputs  []uint32
+	ids     []uint32 // ids the output log files can take, in order
+	options []Option
+	current *logFile
+	outputs []*logFile
+}
+
+// NewMerge prepares a merge replacing the inputs log files in dir.
+// Output log files take the ids from the lowest input up to maxID, every one
+// of them must be unused once the inputs are gone.
+func NewMerge(dir string, inputs []uint32, maxID uint32, options ...Option) (*Merge, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("merge needs at least one input")
+	}
+
+	cfg, err := newLogFile(0, dir, options...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.openReadOnly {
+		return nil, ErrReadOnlySegment
+	}
+
+	inputs = slices.Clone(inputs)
+	slices.Sort(inputs)
+
+	var ids []uint32
+	for id := inputs[0]; id <= maxID; id++ {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("%w: no id between %d and %d", ErrMergeTooLarge, inputs[0], maxID)
+	}
+
+	tmpDir := filepath.Join(dir, mergeDirName)
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Merge{
+		dir:     dir,
+		tmpDir:  tmpDir,
+		inputs:  inputs,
+		ids:     ids,
+		options: options,
+	}, nil
+}
+
+// mergeOutput flags the log files New creates as written by a merge.
+func mergeOutput() Option {
+	return func(lf *logFile) error {
+		lf.merged = true
+		return nil
+	}
+}
+
+// Append writes e to the current output log file, starting a new one when it is full.
+func (mg *Merge) Append(e Entry) (LogPosition, error) {
+	if mg.current != nil {
+		pos, err := mg.current.AppendEntry(e)
+		if !errors.Is(err, ErrCapacityExceeded) {
+			return pos, err
+		}
+	}
+
+	if err := mg.next(); err != nil {
+		return LogPosition{}, err
+	}
+
+	return mg.current.AppendEntry(e)
+}
+
+// next starts a new output log file with the next free id.
+func (mg *Merge) next() error {
+	if len(mg.outputs) == len(mg.ids) {
+		return fmt.Errorf("%w: %d ids", ErrMergeTooLarge, len(mg.ids))
+	}
+
+	options := append(mg.options[:len(mg.options):len(mg.options)], mergeOutput())
+	lf, err := New(mg.ids[len(mg.outputs)], mg.tmpDir, options...)
+	if err != nil {
+		return err
+	}
+
+	mg.outputs = append(mg.outputs, lf)
+	mg.current = lf
+	mergeStep("output")
+	return nil
+}
+
+// Commit seals and syncs the output log files, then replaces the inputs with them.
+// It returns the output log files, already sealed. If it fails before the
+// swap is recorded the merge is aborted, afterwards the swap is completed by
+// the next Open.
+func (mg *Merge) Commit() (Logs, error) {
+	if err := mg.seal(); err != nil {
+		return nil, errors.Join(err, mg.Abort())
+	}
+	mergeStep("outputs synced")
+
+	outputs := make([]uint32, len(mg.outputs))
+	for i, lf := range mg.outputs {
+		outputs[i] = lf.ID()
+	}
+
+	if err := writeManifest(mg.dir, mg.inputs, outputs); err != nil {
+		return nil, errors.Join(err, mg.Abort())
+	}
+	mergeStep("manifest written")
+
+	if err := completeMerge(mg.dir); err != nil {
+		return nil, err
+	}
+
+	// the output files were renamed under their open descriptors, only the
+	// directory they report has to follow.
+	logs := make(Logs, len(mg.outputs))
+	for _, lf := range mg.outputs {
+		lf.dir = mg.dir
+		logs[lf.ID()] = lf
+	}
+
+	return logs, nil
+}
+
+// seal makes the output log files and their hints durable.
+func (mg *Merge) seal() error {
+	for _, lf := range mg.outputs {
+		lf.MarkReadOnly()
+		if err := lf.Sync(); err != nil {
+			return err
+		}
+		if err := lf.WriteHint(); err != nil {
+			return err
+		}
+	}
+
+	return syncDir(mg.tmpDir)
+}
+
+// Abort discards the output log files, leaving the inputs untouched.
+// It must not be called once Commit has written the manifest.
+func (mg *Merge) Abort() error {
+	var errs []error
+	for _, lf := range mg.outputs {
+		errs = append(errs, lf.Close())
+	}
+	errs = append(errs, os.RemoveAll(mg.tmpDir))
+
+	return errors.Join(errs...)
+}
+
+// writeManifest atomically writes the list of files a merge replaces.
+func writeManifest(dir string, inputs, outputs []uint32) error {
+	var b strings.Builder
+	for _, id := range inputs {
+		fmt.Fprintf(&b, "in %d\n", id)
+	}
+	for _, id := range outputs {
+		fmt.Fprintf(&b, "out %d\n", id)
+	}
+
+	name := filepath.Join(dir, mergeManifestName)
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+
+	f, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	_ = f.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// readManifest reads the inputs and outputs of an interrupted merge.
+func readManifest(dir string) (inputs, outputs []uint32, err error) {
+	f, err := os.Open(filepath.Join(dir, mergeManifestName))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kind, idStr, ok := strings.Cut(scanner.Text(), " ")
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if !ok || err != nil {
+			return nil, nil, fmt.Errorf("invalid merge manifest line %q", scanner.Text())
+		}
+
+		switch kind {
+		case "in":
+			inputs = append(inputs, uint32(id))
+		case "out":
+			outputs = append(outputs, uint32(id))
+		default:
+			return nil, nil, fmt.Errorf("invalid merge manifest line %q", scanner.Text())
+		}
+	}
+
+	return inputs, outputs, scanner.Err()
+}
+
+// completeMerge moves the output of a committed merge in place of its inputs.
+// Every step can be repeated, so it is safe to run again after a crash.
+// Without a manifest the merge never committed and its output is discarded.
+func completeMerge(dir string) error {
+	tmpDir := filepath.Join(dir, mergeDirName)
+
+	inputs, outputs, err := readManifest(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.RemoveAll(tmpDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, id := range outputs {
+		data := fmt.Sprintf("%d.data", id)
+		hint := fmt.Sprintf("%d.hint", id)
+
+		if fileExists(filepath.Join(tmpDir, data)) {
+			// the hint of an input with the same id must not outlive its data
+			if err := removeIfExists(filepath.Join(dir, hint)); err != nil {
+				return err
+			}
+			mergeStep("remove " + hint)
+
+			if err := os.Rename(filepath.Join(tmpDir, data), filepath.Join(dir, data)); err != nil {
+				return err
+			}
+			mergeStep("rename " + data)
+		}
+
+		if fileExists(filepath.Join(tmpDir, hint)) {
+			if err := os.Rename(filepath.Join(tmpDir, hint), filepath.Join(dir, hint)); err != nil {
+				return err
+			}
+			mergeStep("rename " + hint)
+		}
+	}
+
+	for _, id := range inputs {
+		if slices.Contains(outputs, id) {
+			continue
+		}
+
+		if err := removeIfExists(filepath.Join(dir, fmt.Sprintf("%d.data", id))); err != nil {
+			return err
+		}
+		if err := removeIfExists(hintFileName(dir, id)); err != nil {
+			return err
+		}
+		mergeStep(fmt.Sprintf("remove %d", id))
+	}
+
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Join(dir, mergeManifestName)); err != nil {
+		return err
+	}
+	mergeStep("manifest removed")
+
+	if err := os.RemoveAll(tmpDir); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func removeIfExists(name string) error {
+	if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// syncDir flushes the directory entries of dir, making renames and removals durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,290 @@
+//go:build integration
+
+package log_test
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/1garo/kival/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mergeCrash is the panic value used to stop a merge at a given step.
+type mergeCrash struct{}
+
+func bigValue(s string) string {
+	return strings.Repeat(s, 600)
+}
+
+// newMergeFixture writes three sealed segments holding two records each and an
+// active segment 4, then returns the values Open should see once merged.
+func newMergeFixture(t *testing.T) (string, map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"a", bigValue("1")}, {"b", bigValue("1")}})
+	newSealedLog(t, dir, 2, [][2]string{{"c", bigValue("2")}, {"d", bigValue("2")}})
+	newSealedLog(t, dir, 3, [][2]string{{"b", ""}, {"e", bigValue("3")}})
+
+	active, err := newLog(4, dir)
+	require.NoError(t, err)
+	_, err = active.Append([]byte("a"), []byte(bigValue("4")))
+	require.NoError(t, err)
+	require.NoError(t, active.Close())
+
+	return dir, map[string]string{
+		"a": bigValue("4"),
+		"c": bigValue("2"),
+		"d": bigValue("2"),
+		"e": bigValue("3"),
+	}
+}
+
+// runMerge merges the sealed segments of dir the way the db does, it reports
+// whether the merge hook stopped it with a crash.
+func runMerge(t *testing.T, dir string) (crashed bool) {
+	t.Helper()
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer func() {
+		for _, l := range logs {
+			_ = l.Close()
+		}
+		_ = active.Close()
+
+		if r := recover(); r != nil {
+			if _, ok := r.(mergeCrash); !ok {
+				panic(r)
+			}
+			crashed = true
+		}
+	}()
+
+	var inputs []uint32
+	for id := range logs {
+		inputs = append(inputs, id)
+	}
+
+	var live []log.LogPosition
+	keys := make(map[log.LogPosition]string)
+	for key, pos := range index {
+		if pos.FileID != active.ID() {
+			live = append(live, pos)
+			keys[pos] = key
+		}
+	}
+	sort.Slice(live, func(i, j int) bool {
+		if live[i].FileID != live[j].FileID {
+			return live[i].FileID < live[j].FileID
+		}
+		return live[i].ValuePos < live[j].ValuePos
+	})
+
+	mg, err := newMerge(dir, inputs, active.ID()-1)
+	require.NoError(t, err)
+
+	for _, pos := range live {
+		val, err := logs[pos.FileID].ReadAt(pos)
+		require.NoError(t, err)
+		_, err = mg.Append(log.Entry{Key: []byte(keys[pos]), Value: val})
+		require.NoError(t, err)
+	}
+
+	outputs, err := mg.Commit()
+	require.NoError(t, err)
+	for _, l := range outputs {
+		_ = l.Close()
+	}
+
+	return false
+}
+
+// crashAt makes the n-th merge step panic, counting from zero.
+func crashAt(t *testing.T, n int) {
+	step := 0
+	log.SetMergeHook(t, func(string) {
+		if step == n {
+			panic(mergeCrash{})
+		}
+		step++
+	})
+}
+
+// mergeSteps returns the steps a merge of a fresh fixture goes through.
+func mergeSteps(t *testing.T) []string {
+	t.Helper()
+
+	var steps []string
+	log.SetMergeHook(t, func(step string) {
+		steps = append(steps, step)
+	})
+
+	dir, _ := newMergeFixture(t)
+	require.False(t, runMerge(t, dir))
+	log.SetMergeHook(t, nil)
+
+	return steps
+}
+
+// assertRecovered opens dir and checks it holds exactly want, with no trace of a merge left.
+func assertRecovered(t *testing.T, dir string, want map[string]string) {
+	t.Helper()
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer func() {
+		for _, l := range logs {
+			_ = l.Close()
+		}
+		_ = active.Close()
+	}()
+
+	got := make(map[string]string, len(index))
+	for key, pos := range index {
+		var l log.Log = active
+		if sealed, ok := logs[pos.FileID]; ok {
+			l = sealed
+		} else {
+			require.Equal(t, active.ID(), pos.FileID)
+		}
+
+		val, err := l.ReadAt(pos)
+		require.NoError(t, err, "key %s", key)
+		got[key] = string(val)
+	}
+	assert.Equal(t, want, got)
+
+	assert.NoFileExists(t, filepath.Join(dir, "MERGE"))
+	assert.NoDirExists(t, filepath.Join(dir, "merge"))
+}
+
+func dataFiles(t *testing.T, dir string) []string {
+	t.Helper()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.data"))
+	require.NoError(t, err)
+	for i, f := range files {
+		files[i] = filepath.Base(f)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func TestMerge_ReplacesInputs(t *testing.T) {
+	dir, want := newMergeFixture(t)
+
+	require.False(t, runMerge(t, dir))
+
+	assert.Equal(t, []string{"1.data", "2.data", "4.data"}, dataFiles(t, dir))
+	assert.FileExists(t, filepath.Join(dir, "1.hint"))
+	assert.FileExists(t, filepath.Join(dir, "2.hint"))
+	assert.NoFileExists(t, filepath.Join(dir, "3.hint"))
+	assertRecovered(t, dir, want)
+}
+
+func TestMerge_CrashAtEveryStep(t *testing.T) {
+	steps := mergeSteps(t)
+	require.Contains(t, steps, "manifest written")
+
+	committed := false
+	for i, step := range steps {
+		// the manifest is the commit point, before it nothing changes
+		if step == "manifest written" {
+			committed = true
+		}
+
+		t.Run(step, func(t *testing.T) {
+			dir, want := newMergeFixture(t)
+
+			crashAt(t, i)
+			require.True(t, runMerge(t, dir), "merge should crash")
+			log.SetMergeHook(t, nil)
+
+			assertRecovered(t, dir, want)
+
+			if committed {
+				assert.Equal(t, []string{"1.data", "2.data", "4.data"}, dataFiles(t, dir))
+			} else {
+				assert.Equal(t, []string{"1.data", "2.data", "3.data", "4.data"}, dataFiles(t, dir))
+			}
+		})
+	}
+}
+
+func TestMerge_CrashDuringRecovery(t *testing.T) {
+	steps := mergeSteps(t)
+
+	var first int
+	for i, step := range steps {
+		if step == "manifest written" {
+			first = i + 1
+		}
+	}
+
+	// every step after the manifest also runs when Open recovers the merge
+	for n := range steps[first:] {
+		dir, want := newMergeFixture(t)
+
+		crashAt(t, first-1)
+		require.True(t, runMerge(t, dir))
+
+		crashAt(t, n)
+		func() {
+			defer func() {
+				_, ok := recover().(mergeCrash)
+				require.True(t, ok, "recovery should crash at step %d", n)
+			}()
+			_, _, _, _ = openLog(dir)
+		}()
+		log.SetMergeHook(t, nil)
+
+		// the lock of the crashed Open was never released
+		require.NoError(t, os.Remove(filepath.Join(dir, "LOCK")))
+		assertRecovered(t, dir, want)
+	}
+}
+
+func TestOpen_DiscardsUncommittedMerge(t *testing.T) {
+	dir, want := newMergeFixture(t)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "merge"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "merge", "1.data"), []byte("partial"), 0o644))
+
+	assertRecovered(t, dir, want)
+	assert.Equal(t, []string{"1.data", "2.data", "3.data", "4.data"}, dataFiles(t, dir))
+}
+
+func TestMerge_Abort(t *testing.T) {
+	dir, want := newMergeFixture(t)
+
+	mg, err := newMerge(dir, []uint32{1, 2, 3}, 3)
+	require.NoError(t, err)
+	_, err = mg.Append(log.Entry{Key: []byte("c"), Value: []byte(bigValue("2"))})
+	require.NoError(t, err)
+	require.NoError(t, mg.Abort())
+
+	assertRecovered(t, dir, want)
+	assert.Equal(t, []string{"1.data", "2.data", "3.data", "4.data"}, dataFiles(t, dir))
+}
+
+func TestMerge_OutOfIDs(t *testing.T) {
+	dir, _ := newMergeFixture(t)
+
+	mg, err := newMerge(dir, []uint32{3}, 3)
+	require.NoError(t, err)
+	defer mg.Abort()
+
+	val := []byte(bigValue("x"))
+	_, err = mg.Append(log.Entry{Key: []byte("k1"), Value: val})
+	require.NoError(t, err)
+	_, err = mg.Append(log.Entry{Key: []byte("k2"), Value: val})
+	require.NoError(t, err)
+	_, err = mg.Append(log.Entry{Key: []byte("k3"), Value: val})
+	assert.ErrorIs(t, err, log.ErrMergeTooLarge)
+}
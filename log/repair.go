@@ -0,0 +1,439 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/1garo/kival/record"
+)
+
+// repairReportName is the report Repair leaves next to its backups.
+const repairReportName = "report.txt"
+
+// RepairReport describes what Repair found in a database and what it did.
+type RepairReport struct {
+	Dir       string
+	BackupDir string // holds the original of every rewritten log file, empty when none was
+	Segments  []SegmentRepair
+}
+
+// SegmentRepair describes what Repair found in a log file.
+type SegmentRepair struct {
+	ID        uint32
+	Records   int             // valid records kept
+	Recovered int             // valid records found after a damaged region, Open would have ignored them
+	Damaged   []DamagedRegion // regions holding no valid record, dropped from the log file
+	Err       error           // the log file could not be repaired and was left untouched
+}
+
+// DamagedRegion is a range of a log file that holds no valid record.
+type DamagedRegion struct {
+	Offset int64
+	Length int64
+	Reason error // why decoding failed at Offset
+
+	// BatchRecords are the valid records of a batch right before Offset
+	// whose commit record was lost to the region. They are dropped with it
+	// and take the BatchLength bytes before Offset.
+	BatchRecords int
+	BatchLength  int64
+}
+
+// Repaired reports whether the log file was rewritten.
+func (s SegmentRepair) Repaired() bool {
+	return s.Err == nil && len(s.Damaged) > 0
+}
+
+// Lost returns how many bytes were dropped from the log file.
+func (s SegmentRepair) Lost() int64 {
+	var n int64
+	for _, d := range s.Damaged {
+		n += d.Length + d.BatchLength
+	}
+	return n
+}
+
+// Err returns the errors of the log files that could not be repaired.
+func (r *RepairReport) Err() error {
+	var errs []error
+	for _, s := range r.Segments {
+		if s.Err != nil {
+			errs = append(errs, fmt.Errorf("log file %d: %w", s.ID, s.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteTo writes the report in a human readable form.
+func (r *RepairReport) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	fmt.Fprintf(cw, "repair of %s\n", r.Dir)
+	for _, s := range r.Segments {
+		switch {
+		case s.Err != nil:
+			fmt.Fprintf(cw, "log file %d: not repaired: %v\n", s.ID, s.Err)
+		case len(s.Damaged) == 0:
+			fmt.Fprintf(cw, "log file %d: ok, %d records\n", s.ID, s.Records)
+		default:
+			fmt.Fprintf(cw, "log file %d: %d records kept, %d of them recovered after damage, %d bytes lost\n",
+				s.ID, s.Records, s.Recovered, s.Lost())
+			for _, d := range s.Damaged {
+				fmt.Fprintf(cw, "  dropped %d bytes at offset %d: %v\n", d.Length, d.Offset, d.Reason)
+				if d.BatchRecords > 0 {
+					fmt.Fprintf(cw, "  dropped %d records of the uncommitted batch before it\n", d.BatchRecords)
+				}
+			}
+		}
+	}
+	if r.BackupDir != "" {
+		fmt.Fprintf(cw, "original log files saved in %s\n", r.BackupDir)
+	}
+
+	return cw.n, cw.err
+}
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Repair salvages the records of the database in path after corruption.
+//
+// Open stops reading a log file at the first record that fails to decode and
+// ignores everything after it. Repair instead skips the damaged region,
+// resynchronizing on the next offset that decodes into a record with a valid
+// checksum, and rewrites the log file with only the valid records. A torn
+// tail is simply cut off. The records of a batch whose commit record sits in
+// a damaged region are dropped too, like Open would. Hint files of rewritten
+// log files are removed since record positions move.
+//
+// The original of every rewritten log file is copied to a repair-* directory
+// inside path first, along with the report. Log files whose segment header
+// cannot be read are reported and left untouched. Repair takes the directory
+// lock, so the database must not be open.
+func Repair(path string) (*RepairReport, error) {
+	lock, err := lockDir(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.Close()
+
+	if err := completeMerge(path); err != nil {
+		return nil, fmt.Errorf("cannot recover merge: %w", err)
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(path, "*.data.repair"))
+	for _, f := range leftovers {
+		if err := os.Remove(f); err != nil {
+			return nil, err
+		}
+	}
+
+	files, _ := filepath.Glob(filepath.Join(path, "*.data"))
+	sort.Slice(files, func(i, j int) bool {
+		return parseFileID(files[i]) < parseFileID(files[j])
+	})
+
+	report := &RepairReport{Dir: path}
+	for _, f := range files {
+		s, err := repairSegment(report, parseFileID(f))
+		if err != nil {
+			return report, err
+		}
+		report.Segments = append(report.Segments, s)
+	}
+
+	if report.BackupDir != "" {
+		if err := writeRepairReport(report); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// repairSegment scans log file id for valid records and rewrites it when it
+// holds damaged regions. Errors that leave the log file untouched go in the
+// returned SegmentRepair, the returned error is for failures of the repair
+// itself.
+func repairSegment(report *RepairReport, id uint32) (SegmentRepair, error) {
+	s := SegmentRepair{ID: id}
+
+	lf, err := openExisting(id, report.Dir, WithReadOnly())
+	if err != nil {
+		s.Err = err
+		return s, nil
+	}
+	defer lf.file.Close()
+
+	found, err := lf.salvage()
+	if err != nil {
+		return s, fmt.Errorf("log file %d: %w", id, err)
+	}
+	s.Records, s.Recovered, s.Damaged = found.records, found.recovered, found.damaged
+
+	if len(s.Damaged) == 0 {
+		return s, nil
+	}
+
+	return s, rewriteSegment(report, lf, found.keep)
+}
+
+// salvaged is what salvage found in a log file.
+type salvaged struct {
+	records   int             // valid records kept
+	recovered int             // valid records kept after the first damaged region
+	keep      [][2]int64      // byte ranges of the valid records, contiguous ones merged
+	damaged   []DamagedRegion // byte ranges between them
+}
+
+// salvage decodes every valid record of the log file. Unlike scan it does not
+// stop at the first one that fails, it resynchronizes on the next offset that
+// decodes into a record with a valid checksum.
+//
+// A damaged region may hold the rest of a batch. Left in place, the records
+// before it would be committed by the next commit record read after the
+// rewrite, so they are dropped along with the region.
+func (d *logFile) salvage() (salvaged, error) {
+	var s salvaged
+
+	// the uncommitted batch records read since the last damaged region
+	batchStart, batchRecords := int64(0), 0
+
+	stat, err := d.file.Stat()
+	if err != nil {
+		return s, err
+	}
+	size := stat.Size()
+
+	for offset := d.dataStart; offset < size; {
+		rec, n, err := record.DecodeVersion(d.version, d.file, offset)
+		if err != nil {
+			if !isDecodeError(err) {
+				return s, err
+			}
+
+			next, found, nextErr := d.nextRecord(offset+1, size)
+			if nextErr != nil {
+				return s, nextErr
+			}
+			if !found {
+				next = size
+			}
+
+			region := DamagedRegion{Offset: offset, Length: next - offset, Reason: err}
+			if batchRecords > 0 {
+				// batch records are contiguous, the last kept range ends with them
+				last := &s.keep[len(s.keep)-1]
+				last[1] = batchStart
+				if last[0] == last[1] {
+					s.keep = s.keep[:len(s.keep)-1]
+				}
+				s.records -= batchRecords
+				if len(s.damaged) > 0 {
+					s.recovered -= batchRecords
+				}
+				region.BatchRecords, region.BatchLength = batchRecords, offset-batchStart
+				batchRecords = 0
+			}
+
+			s.damaged = append(s.damaged, region)
+			offset = next
+			continue
+		}
+
+		if len(s.keep) > 0 && s.keep[len(s.keep)-1][1] == offset {
+			s.keep[len(s.keep)-1][1] = offset + n
+		} else {
+			s.keep = append(s.keep, [2]int64{offset, offset + n})
+		}
+
+		s.records++
+		if len(s.damaged) > 0 {
+			s.recovered++
+		}
+
+		switch {
+		case rec.Flags&record.FlagBatchCommit != 0 || rec.Flags&record.FlagBatch == 0:
+			batchRecords = 0
+		case batchRecords == 0:
+			batchStart, batchRecords = offset, 1
+		default:
+			batchRecords++
+		}
+		offset += n
+	}
+
+	return s, nil
+}
+
+// resyncWindow is how many bytes nextRecord reads at once.
+const resyncWindow = 64 << 10
+
+// nextRecord returns the first offset from on, and before size, that decodes
+// into a record with a valid checksum. found is false when there is none.
+//
+// Offsets are first checked against the header found there, read from a
+// window of the file, and only decoded when it looks like a record.
+func (d *logFile) nextRecord(from, size int64) (offset int64, found bool, err error) {
+	headerSize := int64(record.HeaderSizeOf(d.version))
+	buf := make([]byte, resyncWindow)
+
+	for base := from; base+headerSize <= size; {
+		n, err := d.file.ReadAt(buf, base)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, false, err
+		}
+
+		// offsets whose header is whole in the window
+		last := int64(n) - headerSize
+		for i := int64(0); i <= last; i++ {
+			offset = base + i
+			if !d.plausibleHeader(buf[i:i+headerSize], size-offset) {
+				continue
+			}
+
+			_, _, err := record.DecodeVersion(d.version, d.file, offset)
+			if err == nil {
+				return offset, true, nil
+			}
+			if !isDecodeError(err) {
+				return 0, false, err
+			}
+		}
+
+		if n < len(buf) {
+			break
+		}
+		base += last + 1
+	}
+
+	return 0, false, nil
+}
+
+// plausibleHeader reports whether header could start a record of the log
+// file with left bytes from its start to the end of the file: the record
+// fits and its flags are ones its version writes.
+func (d *logFile) plausibleHeader(header []byte, left int64) bool {
+	keySize, valSize, flags := record.PeekHeader(d.version, header)
+	if keySize == 0 || int64(len(header))+int64(keySize)+int64(valSize) > left {
+		return false
+	}
+	if flags&record.FlagBatchCommit != 0 && flags&record.FlagBatch == 0 {
+		return false
+	}
+
+	known := record.FlagBatch | record.FlagBatchCommit
+	if d.version >= record.V2 {
+		known |= record.Flag(0).WithCodec(record.MaxCodecID)
+	}
+	if d.version >= record.V3 {
+		known |= record.FlagEncrypted
+	}
+	if d.version >= record.V4 {
+		known |= record.FlagMeta
+	}
+	return flags&^known == 0
+}
+
+// rewriteSegment replaces the log file with its header followed by the keep
+// ranges, after saving the original in the backup directory.
+func rewriteSegment(report *RepairReport, lf *logFile, keep [][2]int64) error {
+	dir := report.Dir
+	name := filepath.Join(dir, fmt.Sprintf("%d.data", lf.id))
+	tmp := name + ".repair"
+
+	ranges := append([][2]int64{{0, lf.dataStart}}, keep...)
+	if err := copyRanges(tmp, lf.file, ranges); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if report.BackupDir == "" {
+		backup, err := os.MkdirTemp(dir, "repair-"+time.Now().UTC().Format("20060102T150405Z")+"-")
+		if err != nil {
+			_ = os.Remove(tmp)
+			return err
+		}
+		report.BackupDir = backup
+	}
+
+	stat, err := lf.file.Stat()
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	backup := filepath.Join(report.BackupDir, filepath.Base(name))
+	if err := copyRanges(backup, lf.file, [][2]int64{{0, stat.Size()}}); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+	if err := removeIfExists(hintFileName(dir, lf.id)); err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// copyRanges writes the given byte ranges of src to a new file at path and syncs it.
+func copyRanges(path string, src *os.File, ranges [][2]int64) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range ranges {
+		if _, err := io.Copy(f, io.NewSectionReader(src, r[0], r[1]-r[0])); err != nil {
+			_ = f.Close()
+			return err
+		}
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+func writeRepairReport(report *RepairReport) error {
+	f, err := os.Create(filepath.Join(report.BackupDir, repairReportName))
+	if err != nil {
+		return err
+	}
+
+	if _, err := report.WriteTo(f); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,253 @@
+//go:build integration
+
+package log_test
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var repairRecords = []record.Record{
+	{Key: []byte("k1"), Value: []byte("v1")},
+	{Key: []byte("k2"), Value: []byte("v2")},
+	{Key: []byte("k3"), Value: []byte("v3")},
+	{Key: []byte("k4"), Value: []byte("v4")},
+}
+
+// readAll opens dir and returns the value of every key in the index.
+func readAll(t *testing.T, dir string) map[string]string {
+	t.Helper()
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	got := make(map[string]string)
+	for k, pos := range index {
+		var l log.Log = active
+		if sealed, ok := logs[pos.FileID]; ok {
+			l = sealed
+		}
+		val, err := l.ReadAt(pos)
+		require.NoError(t, err, k)
+		got[k] = string(val)
+	}
+	return got
+}
+
+func TestRepair_SkipsCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords...)
+	writeSegment(t, dir, 2, log.SegmentHeader(2, record.CurrentVersion))
+
+	// flip a value byte of k2
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	k2 := int64(log.SegmentHeaderSize + len(record.EncodeRecord(repairRecords[0])))
+	data[k2+int64(record.HeaderSize)+2] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	assert.NotContains(t, readAll(t, dir), "k3", "open stops at the corrupt record")
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+	require.NoError(t, report.Err())
+	require.Len(t, report.Segments, 2)
+
+	s := report.Segments[0]
+	assert.True(t, s.Repaired())
+	assert.Equal(t, 3, s.Records)
+	assert.Equal(t, 2, s.Recovered)
+	require.Len(t, s.Damaged, 1)
+	assert.Equal(t, k2, s.Damaged[0].Offset)
+	assert.Equal(t, int64(len(record.EncodeRecord(repairRecords[1]))), s.Damaged[0].Length)
+	assert.ErrorIs(t, s.Damaged[0].Reason, record.ErrCorruptRecord)
+	assert.False(t, report.Segments[1].Repaired())
+
+	assert.Equal(t, map[string]string{"k1": "v1", "k3": "v3", "k4": "v4"}, readAll(t, dir))
+
+	// the original and the report are kept aside
+	require.NotEmpty(t, report.BackupDir)
+	backup, err := os.ReadFile(filepath.Join(report.BackupDir, "1.data"))
+	require.NoError(t, err)
+	assert.Equal(t, data, backup)
+	text, err := os.ReadFile(filepath.Join(report.BackupDir, "report.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(text), "log file 1: 3 records kept, 2 of them recovered")
+}
+
+func TestRepair_TruncatesTornTail(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords...)
+
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(name, data[:len(data)-3], 0o644))
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+
+	s := report.Segments[0]
+	assert.Equal(t, 3, s.Records)
+	assert.Equal(t, 0, s.Recovered)
+	require.Len(t, s.Damaged, 1)
+	assert.ErrorIs(t, s.Damaged[0].Reason, record.ErrPartialWrite)
+
+	repaired, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, data[:len(data)-len(record.EncodeRecord(repairRecords[3]))], repaired)
+
+	got := readAll(t, dir)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2", "k3": "v3"}, got)
+}
+
+func TestRepair_DropsBatchCutByDamage(t *testing.T) {
+	dir := t.TempDir()
+	batch, commit := record.FlagBatch, record.FlagBatch|record.FlagBatchCommit
+	recs := []record.Record{
+		{Key: []byte("k1"), Value: []byte("v1")},
+		{Key: []byte("a1"), Value: []byte("v"), Flags: batch},
+		{Key: []byte("a2"), Value: []byte("v"), Flags: batch},
+		{Key: []byte("a3"), Value: []byte("v"), Flags: commit},
+		{Key: []byte("b1"), Value: []byte("v"), Flags: batch},
+		{Key: []byte("b2"), Value: []byte("v"), Flags: commit},
+	}
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), recs...)
+
+	// corrupt the commit record of the first batch
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	offsets := []int64{log.SegmentHeaderSize}
+	for _, rec := range recs {
+		offsets = append(offsets, offsets[len(offsets)-1]+int64(len(record.EncodeRecord(rec))))
+	}
+	data[offsets[3]+int64(record.HeaderSize)] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+
+	s := report.Segments[0]
+	assert.Equal(t, 3, s.Records)
+	assert.Equal(t, 2, s.Recovered)
+	require.Len(t, s.Damaged, 1)
+	assert.Equal(t, offsets[3], s.Damaged[0].Offset)
+	assert.Equal(t, 2, s.Damaged[0].BatchRecords)
+	assert.Equal(t, offsets[3]-offsets[1], s.Damaged[0].BatchLength)
+	assert.Equal(t, offsets[4]-offsets[1], s.Lost())
+
+	// a1 and a2 must not be committed by the commit record of the next batch
+	assert.Equal(t, map[string]string{"k1": "v1", "b1": "v", "b2": "v"}, readAll(t, dir))
+
+	var sb strings.Builder
+	_, err = report.WriteTo(&sb)
+	require.NoError(t, err)
+	assert.Contains(t, sb.String(), "dropped 2 records of the uncommitted batch before it")
+}
+
+func TestRepair_ResyncsAfterLargeDamage(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords[0])
+
+	// garbage spanning several resync windows, then the rest of the records
+	garbage := make([]byte, 300<<10)
+	rand.New(rand.NewSource(1)).Read(garbage)
+	name := filepath.Join(dir, "1.data")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write(garbage)
+	require.NoError(t, err)
+	for _, rec := range repairRecords[1:] {
+		_, err = f.Write(record.EncodeRecord(rec))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+
+	s := report.Segments[0]
+	assert.Equal(t, 4, s.Records)
+	assert.Equal(t, 3, s.Recovered)
+	require.Len(t, s.Damaged, 1)
+	assert.Equal(t, int64(len(garbage)), s.Damaged[0].Length)
+	assert.Equal(t, map[string]string{"k1": "v1", "k2": "v2", "k3": "v3", "k4": "v4"}, readAll(t, dir))
+}
+
+func TestRepair_RemovesHintOfRepairedLog(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}})
+
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	data[log.SegmentHeaderSize] ^= 0xff
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+	assert.True(t, report.Segments[0].Repaired())
+
+	assert.NoFileExists(t, filepath.Join(dir, "1.hint"))
+	assert.Equal(t, map[string]string{"k2": "v2"}, readAll(t, dir))
+}
+
+func TestRepair_CleanDatabaseIsUntouched(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords...)
+
+	before, err := os.ReadFile(filepath.Join(dir, "1.data"))
+	require.NoError(t, err)
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+	assert.Empty(t, report.BackupDir)
+	assert.Equal(t, 4, report.Segments[0].Records)
+
+	after, err := os.ReadFile(filepath.Join(dir, "1.data"))
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+
+	backups, _ := filepath.Glob(filepath.Join(dir, "repair-*"))
+	assert.Empty(t, backups)
+
+	var sb strings.Builder
+	_, err = report.WriteTo(&sb)
+	require.NoError(t, err)
+	assert.Contains(t, sb.String(), "log file 1: ok, 4 records")
+}
+
+func TestRepair_ReportsUnreadableHeader(t *testing.T) {
+	dir := t.TempDir()
+	header := log.SegmentHeader(1, record.CurrentVersion)
+	header[10] ^= 0xff
+	writeSegment(t, dir, 1, header, repairRecords...)
+
+	report, err := log.Repair(dir)
+	require.NoError(t, err)
+	assert.ErrorIs(t, report.Err(), log.ErrCorruptSegmentHeader)
+	assert.False(t, report.Segments[0].Repaired())
+	assert.Empty(t, report.BackupDir)
+}
+
+func TestRepair_LockedDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	active, _, _, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	_, err = log.Repair(dir)
+	assert.ErrorIs(t, err, log.ErrDatabaseLocked)
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/1garo/kival/record"
+)
+
+var ErrMergedSegment = errors.New("log file was written by a merge")
+
+// Replay reads back the records of a run of log files in the order they were
+// written. It reads through descriptors of its own, so log files a merge
+// removes meanwhile stay readable until Close.
+type Replay struct {
+	files []*logFile
+}
+
+// OpenReplay opens the log files ids of path, in that order, for reads only.
+// A log file written by a merge holds the live keys of the files it replaced
+// rather than the writes as they happened, OpenReplay fails on it with
+// ErrMergedSegment. A log file that is gone fails with os.ErrNotExist.
+func OpenReplay(path string, ids []uint32, options ...Option) (*Replay, error) {
+	options = append(options[:len(options):len(options)], WithReadOnly())
+
+	r := &Replay{}
+	for _, id := range ids {
+		lf, err := openExisting(id, path, options...)
+		if err == nil && lf.merged {
+			_ = lf.file.Close()
+			err = fmt.Errorf("log file %d: %w", id, ErrMergedSegment)
+		}
+		if err != nil {
+			return nil, errors.Join(err, r.Close())
+		}
+
+		r.files = append(r.files, lf)
+	}
+
+	return r, nil
+}
+
+// Read calls fn with the records of the log files from offset start of the
+// first one up to offset end of the last one, where the record at start is
+// the first read. Records of a batch are only passed once its commit record
+// is read, like Open a batch cut short by a crash is skipped.
+//
+// Reading a log file stops at the first record that does not decode. An error
+// returned by fn stops Read and is returned as is.
+func (r *Replay) Read(start, end int64, fn func(DumpRecord) error) error {
+	for i, lf := range r.files {
+		from, to := lf.dataStart, int64(-1)
+		if i == 0 {
+			from = max(start, lf.dataStart)
+		}
+		if i == len(r.files)-1 {
+			to = end
+		}
+
+		if err := lf.replay(from, to, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replay calls fn with the committed records from offset from up to offset
+// to, or to the end of the file when to is negative.
+func (d *logFile) replay(from, to int64, fn func(DumpRecord) error) error {
+	if to < 0 {
+		stat, err := d.file.Stat()
+		if err != nil {
+			return err
+		}
+		to = stat.Size()
+	}
+
+	var batch []DumpRecord
+	for offset := from; offset < to; {
+		rec, n, err := record.DecodeVersion(d.version, d.file, offset)
+		if err != nil {
+			if isDecodeError(err) {
+				return nil
+			}
+			return fmt.Errorf("log file %d: %w", d.id, err)
+		}
+
+		r := DumpRecord{FileID: d.id, Offset: offset, Size: n}
+		if r.Record, err = d.decodeForDump(rec); err != nil {
+			return fmt.Errorf("log file %d: offset %d: %w", d.id, offset, err)
+		}
+		offset += n
+
+		if rec.Flags&record.FlagBatch != 0 && rec.Flags&record.FlagBatchCommit == 0 {
+			batch = append(batch, r)
+			continue
+		}
+		if rec.Flags&record.FlagBatchCommit != 0 {
+			batch = append(batch, r)
+		} else {
+			// a plain record right after an uncommitted batch, see scan
+			batch = append(batch[:0], r)
+		}
+
+		for _, r := range batch {
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		batch = batch[:0]
+	}
+
+	return nil
+}
+
+// Close closes the log files of the replay.
+func (r *Replay) Close() error {
+	var errs []error
+	for _, lf := range r.files {
+		errs = append(errs, lf.file.Close())
+	}
+	r.files = nil
+
+	return errors.Join(errs...)
+}
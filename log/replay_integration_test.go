@@ -0,0 +1,111 @@
+//go:build integration
+
+package log_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// replayAll returns the records Read passes from start to end, as key=value.
+func replayAll(t *testing.T, r *log.Replay, start, end int64) []string {
+	t.Helper()
+
+	var got []string
+	require.NoError(t, r.Read(start, end, func(rec log.DumpRecord) error {
+		got = append(got, string(rec.Key)+"="+string(rec.Value))
+		return nil
+	}))
+	return got
+}
+
+func TestReplay_ReadsRange(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}})
+
+	l, err := newLog(2, dir)
+	require.NoError(t, err)
+	pos, err := l.Append([]byte("k3"), []byte("v3"))
+	require.NoError(t, err)
+	end, err := l.Append([]byte("k4"), []byte("v4"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	r, err := log.OpenReplay(dir, []uint32{1, 2})
+	require.NoError(t, err)
+	defer r.Close()
+
+	assert.Equal(t, []string{"k1=v1", "k2=v2", "k3=v3"}, replayAll(t, r, 0, end.ValuePos))
+	assert.Equal(t, []string{"k1=v1", "k2=v2", "k3=v3", "k4=v4"}, replayAll(t, r, 0, 1<<30))
+
+	r2, err := log.OpenReplay(dir, []uint32{2})
+	require.NoError(t, err)
+	defer r2.Close()
+	assert.Equal(t, []string{"k3=v3", "k4=v4"}, replayAll(t, r2, pos.ValuePos, 1<<30))
+	assert.Equal(t, []string{"k4=v4"}, replayAll(t, r2, end.ValuePos, 1<<30))
+}
+
+func TestReplay_SkipsTornBatch(t *testing.T) {
+	dir := t.TempDir()
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+	positions, err := l.AppendBatch([]log.Entry{
+		{Key: []byte("b1"), Value: []byte("v")},
+		{Key: []byte("b2"), Value: []byte("v")},
+	})
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	// cut the commit record off, then write past the torn batch
+	path := filepath.Join(dir, "1.data")
+	require.NoError(t, os.Truncate(path, positions[1].ValuePos))
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.Write(record.EncodeRecord(record.Record{Key: []byte("k1"), Value: []byte("v1")}))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	r, err := log.OpenReplay(dir, []uint32{1})
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, []string{"k1=v1"}, replayAll(t, r, 0, 1<<30))
+}
+
+func TestReplay_RejectsMergeOutput(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k1", "v2"}})
+	newSealedLog(t, dir, 2, [][2]string{{"k2", "v3"}})
+
+	mg, err := newMerge(dir, []uint32{1}, 1)
+	require.NoError(t, err)
+	_, err = mg.Append(log.Entry{Key: []byte("k1"), Value: []byte("v2")})
+	require.NoError(t, err)
+	outputs, err := mg.Commit()
+	require.NoError(t, err)
+	for _, l := range outputs {
+		require.NoError(t, l.Close())
+	}
+
+	_, err = log.OpenReplay(dir, []uint32{1, 2})
+	assert.ErrorIs(t, err, log.ErrMergedSegment)
+	_, err = log.OpenReplay(dir, []uint32{3})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	r, err := log.OpenReplay(dir, []uint32{2})
+	require.NoError(t, err)
+	defer r.Close()
+	assert.Equal(t, []string{"k2=v3"}, replayAll(t, r, 0, 1<<30))
+
+	// the merge output still opens like any other log file
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+	assert.Len(t, logs, 1)
+	assert.Contains(t, index, "k1")
+}
@@ -0,0 +1,103 @@
+package log
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/1garo/kival/record"
+)
+
+// SegmentHeaderSize is the size of the header at the start of every log file:
+// magic(4) + version(2) + flags(2) + created(8) + id(4) + crc(4).
+const SegmentHeaderSize = 24
+
+// segmentMerged flags a log file written by a merge. Log files written before
+// the flag existed leave it unset.
+const segmentMerged uint16 = 1
+
+var segmentMagic = []byte("KIVL")
+
+var ErrCorruptSegmentHeader = errors.New("segment header is corrupted")
+
+// segmentHeader tells how to read the records of a log file.
+type segmentHeader struct {
+	version record.Version
+	created time.Time
+	id      uint32
+	merged  bool // see segmentMerged
+}
+
+func encodeSegmentHeader(h segmentHeader) []byte {
+	buf := make([]byte, SegmentHeaderSize)
+	copy(buf[0:4], segmentMagic)
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(h.version))
+	if h.merged {
+		binary.LittleEndian.PutUint16(buf[6:8], segmentMerged)
+	}
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(h.created.UnixNano()))
+	binary.LittleEndian.PutUint32(buf[16:20], h.id)
+	binary.LittleEndian.PutUint32(buf[20:24], crc32.Checksum(buf[:20], crc32.MakeTable(crc32.Castagnoli)))
+	return buf
+}
+
+// readSegmentHeader reads the header of f. Log files written before the
+// header existed start straight with a record, for them ok is false and the
+// records are read as record.V0.
+func readSegmentHeader(f *os.File) (h segmentHeader, ok bool, err error) {
+	buf := make([]byte, SegmentHeaderSize)
+	n, err := f.ReadAt(buf, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return segmentHeader{}, false, err
+	}
+
+	if n < len(segmentMagic) || !bytes.Equal(buf[0:4], segmentMagic) {
+		if n > 0 && n < len(segmentMagic) && bytes.HasPrefix(segmentMagic, buf[:n]) {
+			return segmentHeader{}, false, fmt.Errorf("%w: truncated", ErrCorruptSegmentHeader)
+		}
+		return segmentHeader{version: record.V0}, false, nil
+	}
+
+	if n < SegmentHeaderSize {
+		return segmentHeader{}, false, fmt.Errorf("%w: truncated", ErrCorruptSegmentHeader)
+	}
+
+	if binary.LittleEndian.Uint32(buf[20:24]) != crc32.Checksum(buf[:20], crc32.MakeTable(crc32.Castagnoli)) {
+		return segmentHeader{}, false, fmt.Errorf("%w: checksum mismatch", ErrCorruptSegmentHeader)
+	}
+
+	return segmentHeader{
+		version: record.Version(binary.LittleEndian.Uint16(buf[4:6])),
+		created: time.Unix(0, int64(binary.LittleEndian.Uint64(buf[8:16]))),
+		id:      binary.LittleEndian.Uint32(buf[16:20]),
+		merged:  binary.LittleEndian.Uint16(buf[6:8])&segmentMerged != 0,
+	}, true, nil
+}
+
+// checkSegmentHeader reads the header of the log file and makes sure its
+// records can be decoded.
+func (d *logFile) checkSegmentHeader() error {
+	h, ok, err := readSegmentHeader(d.file)
+	if err != nil {
+		return fmt.Errorf("log file %d: %w", d.id, err)
+	}
+
+	if ok && h.id != d.id {
+		return fmt.Errorf("%w: log file %d holds segment %d", ErrCorruptSegmentHeader, d.id, h.id)
+	}
+	if !record.SupportedVersion(h.version) {
+		return fmt.Errorf("log file %d: %w: %d", d.id, record.ErrUnknownVersion, h.version)
+	}
+
+	d.version = h.version
+	d.merged = h.merged
+	if ok {
+		d.dataStart = SegmentHeaderSize
+	}
+	return nil
+}
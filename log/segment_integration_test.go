@@ -0,0 +1,209 @@
+//go:build integration
+
+package log_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSegment writes a log file made of header followed by the records.
+func writeSegment(t *testing.T, dir string, id uint32, header []byte, recs ...record.Record) {
+	t.Helper()
+
+	data := append([]byte{}, header...)
+	for _, rec := range recs {
+		data = append(data, record.EncodeRecord(rec)...)
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.data", id)), data, 0o644))
+}
+
+func TestNew_WritesSegmentHeader(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := newLog(1, dir)
+	require.NoError(t, err)
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(filepath.Join(dir, "1.data"))
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, len(data), log.SegmentHeaderSize)
+	assert.Equal(t, "KIVL", string(data[:4]))
+
+	active, _, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	val, err := active.ReadAt(index["k1"])
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val))
+}
+
+// baselineSegment is a log file written before the segment header existed:
+// records of a 16-byte header, crc(4) + timestamp(4) + keySize(4) +
+// valSize(4), with no expiry and no flags.
+const baselineSegment = "" +
+	// k1=v1
+	"\x27\x1d\xa7\x13\xc0\xa4\x00\x03\x02\x00\x00\x00\x02\x00\x00\x00k1v1" +
+	// k2=value2
+	"\xdc\x94\x21\x5d\xc0\xa4\x00\x03\x02\x00\x00\x00\x06\x00\x00\x00k2value2" +
+	// k1 deleted
+	"\x45\xf5\x7a\x9b\xc0\xa4\x00\x03\x02\x00\x00\x00\x00\x00\x00\x00k1"
+
+func TestOpen_ReadsSegmentsWithoutHeader(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "1.data"), []byte(baselineSegment), 0o644))
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	require.Contains(t, logs, uint32(1))
+	assert.NotContains(t, index, "k1", "the tombstone deletes k1")
+	require.Contains(t, index, "k2")
+	assert.Equal(t, int64(20), index["k2"].ValuePos, "records start at the beginning of a file without header")
+	assert.Zero(t, index["k2"].Expiry)
+
+	val, err := logs[1].ReadAt(index["k2"])
+	require.NoError(t, err)
+	assert.Equal(t, "value2", string(val))
+
+	// files without header hold record.V0, new records go to a new file
+	assert.Equal(t, uint32(2), active.ID())
+	_, err = logs[1].Append([]byte("k3"), []byte("v3"))
+	assert.ErrorIs(t, err, log.ErrReadOnlySegment)
+}
+
+func TestDecodeV0_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	data := []byte(baselineSegment)
+	data[len(data)-1] ^= 0xff
+	path := filepath.Join(dir, "1.data")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	rec, n, err := record.DecodeV0(f, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(20), n)
+	assert.Equal(t, "k1", string(rec.Key))
+	assert.Zero(t, rec.Flags)
+
+	_, _, err = record.DecodeV0(f, 44)
+	assert.ErrorIs(t, err, record.ErrCorruptRecord)
+	_, _, err = record.DecodeV0(f, 50)
+	assert.ErrorIs(t, err, record.ErrPartialWrite)
+}
+
+func TestOpen_CorruptSegmentHeader(t *testing.T) {
+	dir := t.TempDir()
+	header := log.SegmentHeader(1, record.CurrentVersion)
+	header[10] ^= 0xff
+	writeSegment(t, dir, 1, header, record.Record{Key: []byte("k1"), Value: []byte("v1")})
+
+	_, _, _, err := openLog(dir)
+	assert.ErrorIs(t, err, log.ErrCorruptSegmentHeader)
+}
+
+func TestOpen_FailureClosesLogFiles(t *testing.T) {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open descriptors cannot be counted here")
+	}
+
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}})
+	newSealedLog(t, dir, 2, [][2]string{{"k2", "v2"}})
+	header := log.SegmentHeader(3, record.CurrentVersion)
+	header[10] ^= 0xff
+	writeSegment(t, dir, 3, header, record.Record{Key: []byte("k3"), Value: []byte("v3")})
+
+	_, _, _, err = openLog(dir)
+	require.ErrorIs(t, err, log.ErrCorruptSegmentHeader)
+
+	after, err := os.ReadDir("/proc/self/fd")
+	require.NoError(t, err)
+	assert.Len(t, after, len(fds), "the log files opened before the failure are closed")
+}
+
+func TestOpen_TruncatedSegmentHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion)[:10])
+
+	_, _, _, err := openLog(dir)
+	assert.ErrorIs(t, err, log.ErrCorruptSegmentHeader)
+}
+
+func TestOpen_SegmentIDMismatch(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 2, log.SegmentHeader(1, record.CurrentVersion), record.Record{Key: []byte("k1"), Value: []byte("v1")})
+
+	_, _, _, err := openLog(dir)
+	assert.ErrorIs(t, err, log.ErrCorruptSegmentHeader)
+}
+
+func TestOpen_UnknownVersion(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion+100), record.Record{Key: []byte("k1"), Value: []byte("v1")})
+
+	_, _, _, err := openLog(dir)
+	assert.ErrorIs(t, err, record.ErrUnknownVersion)
+}
+
+func TestOpen_RegisteredDecoder(t *testing.T) {
+	// an older layout: the current one with the value stored reversed
+	const reversed = record.CurrentVersion + 200
+	record.RegisterDecoder(reversed, func(f *os.File, offset int64) (record.Record, int64, error) {
+		rec, n, err := record.Decode(f, offset)
+		for i, j := 0, len(rec.Value)-1; i < j; i, j = i+1, j-1 {
+			rec.Value[i], rec.Value[j] = rec.Value[j], rec.Value[i]
+		}
+		return rec, n, err
+	})
+
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, reversed), record.Record{Key: []byte("k1"), Value: []byte("abc")})
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	require.Contains(t, logs, uint32(1))
+	val, err := logs[1].ReadAt(index["k1"])
+	require.NoError(t, err)
+	assert.Equal(t, "cba", string(val))
+}
+
+func TestOpen_SealsLatestSegmentOfOlderVersion(t *testing.T) {
+	const older = record.CurrentVersion + 300
+	record.RegisterDecoder(older, record.Decode)
+
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, older), record.Record{Key: []byte("k1"), Value: []byte("v1")})
+
+	active, logs, index, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+
+	assert.Equal(t, uint32(2), active.ID(), "new records should go to a log file of the current version")
+	require.Contains(t, logs, uint32(1))
+
+	pos, err := active.Append([]byte("k2"), []byte("v2"))
+	require.NoError(t, err)
+	assert.Equal(t, uint32(2), pos.FileID)
+
+	val, err := logs[1].ReadAt(index["k1"])
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val))
+}
@@ -0,0 +1,70 @@
+package log
+
+import "time"
+
+// syncer syncs a log file in the background for the Interval strategy.
+type syncer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startSyncer starts syncing d every syncInterval, the caller must hold mu.
+// It is started by the first write that is left unsynced, so log files that
+// are only read never get one.
+func (d *logFile) startSyncer() *syncer {
+	s := &syncer{stop: make(chan struct{}), done: make(chan struct{})}
+
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(d.syncInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if err := d.syncIfDirty(); err != nil {
+					d.mu.Lock()
+					d.syncErr = err
+					d.mu.Unlock()
+				}
+			}
+		}
+	}()
+
+	return s
+}
+
+// stopSyncer stops the syncer of d, if any, and waits for it to return.
+// The caller must not hold mu, the syncer takes it.
+func (d *logFile) stopSyncer() {
+	d.mu.Lock()
+	s := d.syncer
+	d.syncer = nil
+	d.mu.Unlock()
+
+	if s != nil {
+		close(s.stop)
+		<-s.done
+	}
+}
+
+// syncIfDirty syncs d when it has writes that were not synced yet.
+func (d *logFile) syncIfDirty() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed || !d.dirty {
+		return nil
+	}
+
+	if err := d.file.Sync(); err != nil {
+		return err
+	}
+
+	d.writeCount = 0
+	d.dirty = false
+	return nil
+}
@@ -0,0 +1,79 @@
+//go:build integration
+
+package log_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncStrategy_Never(t *testing.T) {
+	l := newTestLog(t, log.WithSyncStrategy(log.Never))
+
+	_, err := l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	assert.True(t, log.Unsynced(l), "Never should not sync appends")
+	assert.False(t, log.SyncerRunning(l))
+
+	require.NoError(t, l.Sync())
+	assert.False(t, log.Unsynced(l), "Sync should still sync")
+}
+
+func TestSyncStrategy_Interval(t *testing.T) {
+	l := newTestLog(t, log.WithSyncStrategy(log.Interval), log.WithSyncInterval(10*time.Millisecond))
+	assert.False(t, log.SyncerRunning(l), "the syncer starts with the first write")
+
+	_, err := l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	assert.True(t, log.SyncerRunning(l))
+
+	assert.Eventually(t, func() bool { return !log.Unsynced(l) }, 5*time.Second, 5*time.Millisecond)
+
+	require.NoError(t, l.Close())
+	assert.False(t, log.SyncerRunning(l), "Close should stop the syncer")
+}
+
+func TestSyncStrategy_IntervalStopsOnRotate(t *testing.T) {
+	dir := t.TempDir()
+	l, err := newLog(1, dir, log.WithSyncStrategy(log.Interval), log.WithSyncInterval(time.Hour))
+	require.NoError(t, err)
+
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	require.True(t, log.Unsynced(l))
+
+	next, err := l.Rotate()
+	require.NoError(t, err)
+	defer next.Close()
+	defer l.Close()
+
+	assert.False(t, log.SyncerRunning(l), "a sealed log file needs no syncer")
+	assert.False(t, log.Unsynced(l), "Rotate should sync the sealed log file")
+	assert.Equal(t, log.Interval, next.SyncStrategy(), "the next log file keeps the strategy")
+}
+
+func TestSyncStrategy_EveryNSyncsTrailingWritesOnRotate(t *testing.T) {
+	dir := t.TempDir()
+	l, err := newLog(1, dir, log.WithSyncStrategy(log.EveryN), log.WithSyncEveryN(10))
+	require.NoError(t, err)
+
+	_, err = l.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+	require.True(t, log.Unsynced(l), "one write is below the threshold")
+
+	next, err := l.Rotate()
+	require.NoError(t, err)
+	defer next.Close()
+	defer l.Close()
+
+	assert.False(t, log.Unsynced(l))
+}
+
+func TestWithSyncInterval_Invalid(t *testing.T) {
+	_, err := newLog(1, t.TempDir(), log.WithSyncInterval(0))
+	assert.ErrorIs(t, err, log.ErrInvalidSyncInterval)
+}
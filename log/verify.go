@@ -0,0 +1,261 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/1garo/kival/record"
+)
+
+var (
+	ErrInvalidFileName = errors.New("file name is not <id>.data or <id>.hint")
+	ErrDuplicateFileID = errors.New("log file id is used by several files")
+	ErrHintOutOfRange  = errors.New("hint entry points outside of its log file")
+	ErrHintNoRecord    = errors.New("hint entry does not point at a record")
+	ErrOrphanHint      = errors.New("hint file has no log file")
+)
+
+// VerifyReport describes the integrity of a database, as found by Verify.
+type VerifyReport struct {
+	Dir          string
+	Segments     []SegmentCheck
+	Problems     []Problem
+	MergePending bool // a merge was interrupted, the next Open finishes or discards it
+}
+
+// SegmentCheck sums up a log file that Verify could read.
+type SegmentCheck struct {
+	ID      uint32
+	Version record.Version
+	Size    int64
+	Records int  // valid records, damaged regions left out
+	Hint    bool // a hint file goes with the log file
+}
+
+// Problem is an integrity issue Verify found in a file.
+type Problem struct {
+	File   string // name of the file, relative to the database directory
+	Offset int64  // where the issue starts, -1 when it is about the whole file
+	Length int64  // bytes affected from Offset, 0 when unknown
+	Err    error
+}
+
+func (p Problem) String() string {
+	switch {
+	case p.Offset < 0:
+		return fmt.Sprintf("%s: %v", p.File, p.Err)
+	case p.Length > 0:
+		return fmt.Sprintf("%s: %d bytes at offset %d: %v", p.File, p.Length, p.Offset, p.Err)
+	default:
+		return fmt.Sprintf("%s: offset %d: %v", p.File, p.Offset, p.Err)
+	}
+}
+
+// OK reports whether Verify found no problem.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Err returns the problems as a single error, nil when there are none.
+func (r *VerifyReport) Err() error {
+	errs := make([]error, len(r.Problems))
+	for i, p := range r.Problems {
+		errs[i] = fmt.Errorf("%s: %w", p.File, p.Err)
+	}
+	return errors.Join(errs...)
+}
+
+// WriteTo writes the report in a human readable form.
+func (r *VerifyReport) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+
+	fmt.Fprintf(cw, "verify of %s\n", r.Dir)
+	for _, s := range r.Segments {
+		hint := ""
+		if s.Hint {
+			hint = ", with hint"
+		}
+		fmt.Fprintf(cw, "log file %d: version %d, %d bytes, %d records%s\n", s.ID, s.Version, s.Size, s.Records, hint)
+	}
+	if r.MergePending {
+		fmt.Fprintln(cw, "an interrupted merge is pending")
+	}
+	for _, p := range r.Problems {
+		fmt.Fprintf(cw, "problem: %s\n", p)
+	}
+	if r.OK() {
+		fmt.Fprintln(cw, "no problem found")
+	} else {
+		fmt.Fprintf(cw, "%d problems found\n", len(r.Problems))
+	}
+
+	return cw.n, cw.err
+}
+
+// Verify checks the integrity of the database in path without changing it.
+//
+// Every record of every log file is decoded and its checksum checked, ranges
+// of a log file that hold no valid record are reported along with the reason
+// decoding failed there. Log files are also checked for names that are not
+// <id>.data, IDs used by several files and segment headers that cannot be
+// read, hint files for entries that do not point at a record of their log
+// file.
+//
+// Verify takes no lock and opens files for reads only, so it can run on a
+// backup or a read-only copy. On a database that is open for writes it may
+// report the record being written as a torn write. The returned error is
+// only for failures to read the directory, problems go in the report.
+func Verify(path string) (*VerifyReport, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	report := &VerifyReport{
+		Dir:          path,
+		MergePending: fileExists(filepath.Join(path, mergeManifestName)),
+	}
+
+	files, err := filepath.Glob(filepath.Join(path, "*.data"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	// Open names log files after their ID, a name that does not parse reads as
+	// ID 0 and several names can parse to the same ID, like 1.data and 01.data.
+	byID := make(map[uint32][]string)
+	for _, f := range files {
+		name := filepath.Base(f)
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ".data"), 10, 32)
+		if err != nil {
+			report.addProblem(name, -1, 0, ErrInvalidFileName)
+			continue
+		}
+		byID[uint32(id)] = append(byID[uint32(id)], name)
+	}
+
+	ids := make([]uint32, 0, len(byID))
+	for id := range byID {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	valid := ids[:0]
+	for _, id := range ids {
+		canonical := fmt.Sprintf("%d.data", id)
+		if !slices.Contains(byID[id], canonical) {
+			for _, name := range byID[id] {
+				report.addProblem(name, -1, 0, ErrInvalidFileName)
+			}
+			continue
+		}
+
+		for _, name := range byID[id] {
+			if name != canonical {
+				report.addProblem(name, -1, 0, fmt.Errorf("%w: %s is log file %d too", ErrDuplicateFileID, canonical, id))
+			}
+		}
+		valid = append(valid, id)
+	}
+
+	for _, id := range valid {
+		if err := report.verifySegment(id); err != nil {
+			return report, err
+		}
+	}
+
+	hints, _ := filepath.Glob(filepath.Join(path, "*.hint"))
+	sort.Strings(hints)
+	for _, h := range hints {
+		name := filepath.Base(h)
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, ".hint"), 10, 32)
+		if err != nil || fmt.Sprintf("%d.hint", id) != name {
+			report.addProblem(name, -1, 0, ErrInvalidFileName)
+			continue
+		}
+		if !slices.Contains(valid, uint32(id)) {
+			report.addProblem(name, -1, 0, ErrOrphanHint)
+		}
+	}
+
+	return report, nil
+}
+
+func (r *VerifyReport) addProblem(file string, offset, length int64, err error) {
+	r.Problems = append(r.Problems, Problem{File: file, Offset: offset, Length: length, Err: err})
+}
+
+// verifySegment checks log file id and its hint file. The returned error is
+// for failures to read them, what is wrong with them goes in the report.
+func (r *VerifyReport) verifySegment(id uint32) error {
+	name := fmt.Sprintf("%d.data", id)
+
+	lf, err := openExisting(id, r.Dir, WithReadOnly())
+	if err != nil {
+		if errors.Is(err, ErrCorruptSegmentHeader) || errors.Is(err, record.ErrUnknownVersion) {
+			r.addProblem(name, 0, int64(SegmentHeaderSize), err)
+			return nil
+		}
+		return err
+	}
+	defer lf.file.Close()
+
+	found, err := lf.salvage()
+	if err != nil {
+		return fmt.Errorf("log file %d: %w", id, err)
+	}
+	for _, d := range found.damaged {
+		r.addProblem(name, d.Offset, d.Length, d.Reason)
+	}
+
+	stat, err := lf.file.Stat()
+	if err != nil {
+		return err
+	}
+
+	s := SegmentCheck{ID: id, Version: lf.version, Size: stat.Size(), Records: found.records}
+	s.Hint, err = r.verifyHint(lf, stat.Size())
+	r.Segments = append(r.Segments, s)
+	return err
+}
+
+// verifyHint checks that every entry of the hint file of lf points at a
+// record of lf, it returns false when there is no hint file.
+func (r *VerifyReport) verifyHint(lf *logFile, size int64) (bool, error) {
+	name := filepath.Base(hintFileName(r.Dir, lf.id))
+
+	entries, err := readHint(r.Dir, lf.id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return false, nil
+	case errors.Is(err, ErrCorruptHint):
+		r.addProblem(name, -1, 0, err)
+		return true, nil
+	case err != nil:
+		return true, err
+	}
+
+	for _, e := range entries {
+		end := e.ValuePos + int64(record.HeaderSizeOf(lf.version)) + int64(len(e.Key)) + int64(e.ValueSize)
+		if e.FileID != lf.id || e.ValuePos < lf.dataStart || end > size {
+			r.addProblem(name, -1, 0, fmt.Errorf("%w: entry for file %d at offset %d", ErrHintOutOfRange, e.FileID, e.ValuePos))
+			continue
+		}
+
+		if _, _, err := record.DecodeVersion(lf.version, lf.file, e.ValuePos); err != nil {
+			if !isDecodeError(err) {
+				return true, err
+			}
+			r.addProblem(name, -1, 0, fmt.Errorf("%w at offset %d: %w", ErrHintNoRecord, e.ValuePos, err))
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,197 @@
+//go:build integration
+
+package log_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// verify runs log.Verify on dir and returns the problems it found.
+func verify(t *testing.T, dir string) (*log.VerifyReport, []log.Problem) {
+	t.Helper()
+
+	report, err := log.Verify(dir)
+	require.NoError(t, err)
+	return report, report.Problems
+}
+
+func TestVerify_CleanDatabase(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}, {"k1", ""}})
+	writeSegment(t, dir, 2, log.SegmentHeader(2, record.CurrentVersion), repairRecords...)
+
+	report, problems := verify(t, dir)
+	assert.Empty(t, problems)
+	assert.True(t, report.OK())
+	assert.NoError(t, report.Err())
+	assert.False(t, report.MergePending)
+
+	require.Len(t, report.Segments, 2)
+	assert.Equal(t, log.SegmentCheck{ID: 1, Version: record.CurrentVersion, Size: report.Segments[0].Size, Records: 3, Hint: true}, report.Segments[0])
+	assert.Equal(t, 4, report.Segments[1].Records)
+	assert.False(t, report.Segments[1].Hint)
+
+	var sb strings.Builder
+	_, err := report.WriteTo(&sb)
+	require.NoError(t, err)
+	assert.Contains(t, sb.String(), "no problem found")
+}
+
+func TestVerify_DamagedRecords(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords...)
+
+	name := filepath.Join(dir, "1.data")
+	data, err := os.ReadFile(name)
+	require.NoError(t, err)
+	k2 := int64(log.SegmentHeaderSize + len(record.EncodeRecord(repairRecords[0])))
+	data[k2+int64(record.HeaderSize)] ^= 0xff
+	data = data[:len(data)-2]
+	require.NoError(t, os.WriteFile(name, data, 0o644))
+
+	report, problems := verify(t, dir)
+	require.Len(t, problems, 2)
+
+	size := int64(len(record.EncodeRecord(repairRecords[1])))
+	assert.Equal(t, "1.data", problems[0].File)
+	assert.Equal(t, k2, problems[0].Offset)
+	assert.Equal(t, size, problems[0].Length)
+	assert.ErrorIs(t, problems[0].Err, record.ErrCorruptRecord)
+
+	assert.Equal(t, k2+2*size, problems[1].Offset)
+	assert.Equal(t, size-2, problems[1].Length)
+	assert.ErrorIs(t, problems[1].Err, record.ErrPartialWrite)
+
+	assert.Equal(t, 2, report.Segments[0].Records)
+
+	after, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, data, after, "verify must not change the files")
+}
+
+func TestVerify_FileNames(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords[0])
+	writeSegment(t, dir, 2, log.SegmentHeader(2, record.CurrentVersion), repairRecords[1])
+	require.NoError(t, os.Rename(filepath.Join(dir, "2.data"), filepath.Join(dir, "02.data")))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "backup.data"), nil, 0o644))
+	data, err := os.ReadFile(filepath.Join(dir, "1.data"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "001.data"), data, 0o644))
+
+	report, problems := verify(t, dir)
+	byFile := make(map[string]error)
+	for _, p := range problems {
+		byFile[p.File] = p.Err
+	}
+
+	assert.Len(t, problems, 3)
+	assert.ErrorIs(t, byFile["001.data"], log.ErrDuplicateFileID)
+	assert.ErrorIs(t, byFile["02.data"], log.ErrInvalidFileName)
+	assert.ErrorIs(t, byFile["backup.data"], log.ErrInvalidFileName)
+
+	require.Len(t, report.Segments, 1, "only 1.data is a log file Open can read")
+	assert.Equal(t, uint32(1), report.Segments[0].ID)
+}
+
+func TestVerify_SegmentHeader(t *testing.T) {
+	dir := t.TempDir()
+	writeSegment(t, dir, 1, log.SegmentHeader(1, record.CurrentVersion), repairRecords[0])
+	writeSegment(t, dir, 2, log.SegmentHeader(1, record.CurrentVersion), repairRecords[1])
+
+	report, problems := verify(t, dir)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "2.data", problems[0].File)
+	assert.ErrorIs(t, problems[0].Err, log.ErrCorruptSegmentHeader)
+	assert.Len(t, report.Segments, 1)
+}
+
+func TestVerify_HintPastEndOfFile(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}})
+
+	// cut the last record, the hint entry of k2 now points past the end
+	name := filepath.Join(dir, "1.data")
+	stat, err := os.Stat(name)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(name, stat.Size()-4))
+
+	_, problems := verify(t, dir)
+	var hintErrs []error
+	for _, p := range problems {
+		if p.File == "1.hint" {
+			hintErrs = append(hintErrs, p.Err)
+		}
+	}
+	require.Len(t, hintErrs, 1)
+	assert.ErrorIs(t, hintErrs[0], log.ErrHintOutOfRange)
+}
+
+func TestVerify_HintNotAtRecord(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}, {"k2", "v2"}})
+
+	// move the first entry one byte forward and fix up the checksum
+	name := filepath.Join(dir, "1.hint")
+	buf, err := os.ReadFile(name)
+	require.NoError(t, err)
+	body := buf[:len(buf)-4]
+	pos := binary.LittleEndian.Uint64(body[4+20:])
+	binary.LittleEndian.PutUint64(body[4+20:], pos+1)
+	binary.LittleEndian.PutUint32(buf[len(buf)-4:], crc32.Checksum(body, crc32.MakeTable(crc32.Castagnoli)))
+	require.NoError(t, os.WriteFile(name, buf, 0o644))
+
+	_, problems := verify(t, dir)
+	require.Len(t, problems, 1)
+	assert.Equal(t, "1.hint", problems[0].File)
+	assert.ErrorIs(t, problems[0].Err, log.ErrHintNoRecord)
+}
+
+func TestVerify_CorruptAndOrphanHints(t *testing.T) {
+	dir := t.TempDir()
+	newSealedLog(t, dir, 1, [][2]string{{"k1", "v1"}})
+	newSealedLog(t, dir, 2, [][2]string{{"k2", "v2"}})
+	require.NoError(t, os.Remove(filepath.Join(dir, "2.data")))
+
+	name := filepath.Join(dir, "1.hint")
+	buf, err := os.ReadFile(name)
+	require.NoError(t, err)
+	buf[len(buf)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(name, buf, 0o644))
+
+	_, problems := verify(t, dir)
+	require.Len(t, problems, 2)
+	assert.Equal(t, "1.hint", problems[0].File)
+	assert.ErrorIs(t, problems[0].Err, log.ErrCorruptHint)
+	assert.Equal(t, "2.hint", problems[1].File)
+	assert.ErrorIs(t, problems[1].Err, log.ErrOrphanHint)
+}
+
+func TestVerify_OpenDatabase(t *testing.T) {
+	dir := t.TempDir()
+
+	active, _, _, err := openLog(dir)
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = active.Append([]byte("k1"), []byte("v1"))
+	require.NoError(t, err)
+
+	report, problems := verify(t, dir)
+	assert.Empty(t, problems, "verify takes no lock")
+	assert.Equal(t, 1, report.Segments[0].Records)
+}
+
+func TestVerify_MissingDirectory(t *testing.T) {
+	_, err := log.Verify(filepath.Join(t.TempDir(), "missing"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
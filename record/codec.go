@@ -0,0 +1,160 @@
+package record
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// CodecID identifies the codec a value was compressed with. It is stored in
+// the upper bits of the record flags, 0 means the value is stored as is.
+type CodecID uint8
+
+const (
+	// NoCodec marks a value stored as is.
+	NoCodec CodecID = 0
+	// FlateCodec is the ID of the codecs returned by NewFlate.
+	FlateCodec CodecID = 1
+
+	// MaxCodecID is the largest ID that fits in the record flags.
+	MaxCodecID CodecID = 1<<(8-codecShift) - 1
+
+	codecShift = 4
+)
+
+var (
+	ErrUnknownCodec = errors.New("unknown codec")
+	ErrInvalidCodec = errors.New("invalid codec")
+)
+
+// Codec compresses the values of records. A codec is registered under its ID
+// with RegisterCodec, so values it compressed can be read back.
+type Codec interface {
+	ID() CodecID
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[CodecID]Codec{FlateCodec: Flate}
+)
+
+// RegisterCodec makes values compressed with c readable. The ID of c must be
+// between 1 and MaxCodecID, registering it again replaces the previous codec.
+func RegisterCodec(c Codec) error {
+	if c == nil || c.ID() == NoCodec || c.ID() > MaxCodecID {
+		return ErrInvalidCodec
+	}
+
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs[c.ID()] = c
+	return nil
+}
+
+// LookupCodec returns the codec registered under id.
+func LookupCodec(id CodecID) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	c, ok := codecs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownCodec, id)
+	}
+
+	return c, nil
+}
+
+// Codec returns the codec the value of the record was compressed with.
+func (f Flag) Codec() CodecID {
+	return CodecID(f >> codecShift)
+}
+
+// WithCodec returns f marking the value as compressed with id.
+func (f Flag) WithCodec(id CodecID) Flag {
+	return f&(1<<codecShift-1) | Flag(id)<<codecShift
+}
+
+// Compress returns rec with its value compressed by c. The value is kept as
+// is when compressing does not make it smaller, and tombstones are left alone.
+func Compress(rec Record, c Codec) (Record, error) {
+	if len(rec.Value) == 0 {
+		return rec, nil
+	}
+
+	compressed, err := c.Compress(rec.Value)
+	if err != nil {
+		return Record{}, err
+	}
+	if len(compressed) >= len(rec.Value) {
+		return rec, nil
+	}
+
+	rec.Value = compressed
+	rec.Flags = rec.Flags.WithCodec(c.ID())
+	return rec, nil
+}
+
+// Decompress returns the value of rec as it was before Compress.
+func Decompress(rec Record) ([]byte, error) {
+	id := rec.Flags.Codec()
+	if id == NoCodec {
+		return rec.Value, nil
+	}
+
+	c, err := LookupCodec(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Decompress(rec.Value)
+}
+
+// Flate compresses values with compress/flate at the default level.
+var Flate Codec = flateCodec{level: flate.DefaultCompression}
+
+// NewFlate returns a codec compressing with compress/flate at level, see
+// flate.NewWriter for the accepted levels.
+func NewFlate(level int) (Codec, error) {
+	if _, err := flate.NewWriter(io.Discard, level); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCodec, err)
+	}
+
+	return flateCodec{level: level}, nil
+}
+
+type flateCodec struct {
+	level int
+}
+
+func (c flateCodec) ID() CodecID {
+	return FlateCodec
+}
+
+func (c flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, c.level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (c flateCodec) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
@@ -0,0 +1,194 @@
+package record
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// EncryptionOverhead is how many bytes Encrypt adds to the key of a record:
+// keyID(4) + nonce(12) + tag(16). A value grows by nonce(12) + tag(16).
+const EncryptionOverhead = 4 + nonceSize + tagSize
+
+const (
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var (
+	ErrNoKeyProvider = errors.New("record is encrypted but no key provider is set")
+	ErrDecrypt       = errors.New("record cannot be decrypted")
+	ErrInvalidKey    = errors.New("invalid encryption key")
+)
+
+// KeyProvider hands out the AES keys records are encrypted with. Keys are
+// 16, 24 or 32 bytes long and identified by an ID stored in every record, so
+// a key must stay available for as long as records encrypted with it exist.
+type KeyProvider interface {
+	// CurrentKey returns the key new records are encrypted with.
+	CurrentKey() (id uint32, key []byte, err error)
+	// Key returns the key with the given ID.
+	Key(id uint32) ([]byte, error)
+}
+
+// Encrypt returns rec with its key and value sealed with AES-GCM under the
+// current key of keys. Tombstones keep an empty value.
+//
+// Both are authenticated along with the record header past the checksum:
+// timestamp, expiry, flags and value size, so a put cannot pass for a
+// tombstone and a record cannot be aged or moved. The key only needs those
+// and the key ID, so it can be decrypted from a hint file. The value is bound
+// to the sealed key as well. rec must carry its final timestamp and flags,
+// EncodeRecord keeps a timestamp that is set.
+func Encrypt(rec Record, keys KeyProvider) (Record, error) {
+	id, key, err := keys.CurrentKey()
+	if err != nil {
+		return Record{}, err
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return Record{}, err
+	}
+
+	rec.Flags |= FlagEncrypted
+
+	// the header as it will be stored, with the size of the sealed value
+	header := rec
+	if len(rec.Value) > 0 {
+		header.ValueSize = uint32(nonceSize + tagSize + len(rec.Value))
+	} else {
+		header.ValueSize = 0
+	}
+
+	sealedKey := binary.LittleEndian.AppendUint32(make([]byte, 0, EncryptionOverhead+len(rec.Key)), id)
+	if sealedKey, err = seal(aead, sealedKey, rec.Key, keyAAD(id, header)); err != nil {
+		return Record{}, err
+	}
+
+	if len(rec.Value) > 0 {
+		sealedValue := make([]byte, 0, header.ValueSize)
+		if sealedValue, err = seal(aead, sealedValue, rec.Value, valueAAD(header, sealedKey)); err != nil {
+			return Record{}, err
+		}
+		rec.Value = sealedValue
+	}
+
+	rec.Key = sealedKey
+	rec.ValueSize = header.ValueSize
+	return rec, nil
+}
+
+// DecryptKey opens the key of rec sealed by Encrypt. Only the key and the
+// header fields of rec are read, its value may be missing, as in a hint file.
+func DecryptKey(rec Record, keys KeyProvider) ([]byte, error) {
+	if keys == nil {
+		return nil, ErrNoKeyProvider
+	}
+	sealed := rec.Key
+	if len(sealed) < EncryptionOverhead {
+		return nil, fmt.Errorf("%w: key too short", ErrDecrypt)
+	}
+
+	id := binary.LittleEndian.Uint32(sealed[0:4])
+	aead, err := keyAEAD(keys, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return open(aead, sealed[4:], keyAAD(id, rec))
+}
+
+// Decrypt returns rec with its key and value as they were before Encrypt.
+// Records that are not encrypted are returned as is.
+func Decrypt(rec Record, keys KeyProvider) (Record, error) {
+	if rec.Flags&FlagEncrypted == 0 {
+		return rec, nil
+	}
+
+	key, err := DecryptKey(rec, keys)
+	if err != nil {
+		return Record{}, err
+	}
+
+	if len(rec.Value) > 0 {
+		aead, err := keyAEAD(keys, binary.LittleEndian.Uint32(rec.Key[0:4]))
+		if err != nil {
+			return Record{}, err
+		}
+
+		if rec.Value, err = open(aead, rec.Value, valueAAD(rec, rec.Key)); err != nil {
+			return Record{}, err
+		}
+	}
+
+	rec.Key = key
+	rec.Flags &^= FlagEncrypted
+	rec.KeySize = uint32(len(rec.Key))
+	rec.ValueSize = uint32(len(rec.Value))
+	return rec, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func keyAEAD(keys KeyProvider, id uint32) (cipher.AEAD, error) {
+	key, err := keys.Key(id)
+	if err != nil {
+		return nil, fmt.Errorf("%w: key %d: %w", ErrDecrypt, id, err)
+	}
+
+	return newAEAD(key)
+}
+
+// seal appends a random nonce and the sealed plaintext to dst.
+func seal(aead cipher.AEAD, dst, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, aad), nil
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < nonceSize+tagSize {
+		return nil, fmt.Errorf("%w: too short", ErrDecrypt)
+	}
+
+	plaintext, err := aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], aad)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecrypt, err)
+	}
+
+	return plaintext, nil
+}
+
+// headerAAD returns the header fields of rec that are authenticated:
+// timestamp(4) + expiry(4) + flags(1) + valSize(4).
+func headerAAD(dst []byte, rec Record) []byte {
+	dst = binary.LittleEndian.AppendUint32(dst, rec.Timestamp)
+	dst = binary.LittleEndian.AppendUint32(dst, rec.Expiry)
+	dst = append(dst, byte(rec.Flags))
+	return binary.LittleEndian.AppendUint32(dst, rec.ValueSize)
+}
+
+func keyAAD(id uint32, rec Record) []byte {
+	aad := binary.LittleEndian.AppendUint32(nil, id)
+	return headerAAD(aad, rec)
+}
+
+func valueAAD(rec Record, sealedKey []byte) []byte {
+	aad := headerAAD(nil, rec)
+	return append(aad, sealedKey...)
+}
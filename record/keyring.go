@@ -0,0 +1,117 @@
+package record
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrKeyNotFound = errors.New("encryption key not found")
+
+// Keyring is a KeyProvider holding its keys in memory. The key with the
+// highest ID is the current one.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[uint32][]byte
+	current uint32
+}
+
+// NewKeyring returns an empty keyring, see Add.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[uint32][]byte)}
+}
+
+// LoadKeyring reads a keyring file. Every line holds a key ID and the key in
+// hex, separated by a space. Empty lines and lines starting with # are skipped.
+func LoadKeyring(path string) (*Keyring, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	k := NewKeyring()
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		idStr, keyHex, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%w: %s:%d: expected an ID and a key", ErrInvalidKey, path, n)
+		}
+
+		id, err := strconv.ParseUint(idStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s:%d: %w", ErrInvalidKey, path, n, err)
+		}
+
+		key, err := hex.DecodeString(strings.TrimSpace(keyHex))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s:%d: %w", ErrInvalidKey, path, n, err)
+		}
+
+		if err := k.Add(uint32(id), key); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+// Add adds a key to the keyring. A key with a higher ID than every other one
+// becomes the current key, so new records and merges start using it.
+func (k *Keyring) Add(id uint32, key []byte) error {
+	if _, err := newAEAD(key); err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("%w: duplicate key %d", ErrInvalidKey, id)
+	}
+
+	k.keys[id] = append([]byte(nil), key...)
+	if len(k.keys) == 1 || id > k.current {
+		k.current = id
+	}
+	return nil
+}
+
+// CurrentKey implements KeyProvider.
+func (k *Keyring) CurrentKey() (uint32, []byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[k.current]
+	if !ok {
+		return 0, nil, ErrKeyNotFound
+	}
+
+	return k.current, key, nil
+}
+
+// Key implements KeyProvider.
+func (k *Keyring) Key(id uint32) ([]byte, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	key, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrKeyNotFound, id)
+	}
+
+	return key, nil
+}
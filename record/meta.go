@@ -0,0 +1,30 @@
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrCorruptMeta = errors.New("record metadata is corrupted")
+
+// JoinMeta returns the value of a record flagged FlagMeta that holds meta and
+// data: the length of meta as a uvarint, meta, then data. The value is never
+// empty, so it does not read as a tombstone even when data is.
+func JoinMeta(meta, data []byte) []byte {
+	buf := make([]byte, 0, binary.MaxVarintLen64+len(meta)+len(data))
+	buf = binary.AppendUvarint(buf, uint64(len(meta)))
+	buf = append(buf, meta...)
+	return append(buf, data...)
+}
+
+// SplitMeta returns the metadata and the data of the value of a record
+// flagged FlagMeta, see JoinMeta.
+func SplitMeta(val []byte) (meta, data []byte, err error) {
+	n, size := binary.Uvarint(val)
+	if size <= 0 || n > uint64(len(val)-size) {
+		return nil, nil, ErrCorruptMeta
+	}
+
+	end := size + int(n)
+	return val[size:end:end], val[end:], nil
+}
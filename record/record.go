@@ -19,7 +19,23 @@ var (
 
 var (
 	CustomEpoch = 1704067200 // first commit to the projec - 2025-12-04 UTC
-	HeaderSize  = uint32(16) // crc(4) + timestamp(4) + keySize(4) + valSize(4)
+	HeaderSize  = uint32(21) // crc(4) + timestamp(4) + expiry(4) + flags(1) + keySize(4) + valSize(4)
+)
+
+// Flag describes how a record should be applied when the log is replayed.
+type Flag uint8
+
+const (
+	// FlagBatch marks a record written as part of a batch, it only takes
+	// effect once the record carrying FlagBatchCommit is read.
+	FlagBatch Flag = 1 << iota
+	// FlagBatchCommit marks the last record of a batch.
+	FlagBatchCommit
+	// FlagEncrypted marks a record whose key and value are sealed, see Encrypt.
+	FlagEncrypted
+	// FlagMeta marks a record whose value starts with metadata of the
+	// application that wrote it, see JoinMeta.
+	FlagMeta
 )
 
 // Record is the value encoded or decoded from the db
@@ -30,12 +46,54 @@ type Record struct {
 	Key       []byte
 	Value     []byte
 	Timestamp uint32
+	Expiry    uint32 // seconds since CustomEpoch, 0 means it never expires
+	Flags     Flag
+}
+
+// Expiry converts t to the seconds since CustomEpoch stored in a record.
+// It rounds up, so a record never expires before t.
+func Expiry(t time.Time) uint32 {
+	secs := t.Unix() - int64(CustomEpoch)
+	if t.Nanosecond() > 0 {
+		secs++
+	}
+
+	return uint32(max(secs, 1))
+}
+
+// Expired reports whether a record with the given expiry is expired at now.
+func Expired(expiry uint32, now time.Time) bool {
+	if expiry == 0 {
+		return false
+	}
+
+	return now.Unix()-int64(CustomEpoch) >= int64(expiry)
+}
+
+// Time converts seconds since CustomEpoch, as stored in the timestamp and the
+// expiry of a record, back to a time.
+func Time(secs uint32) time.Time {
+	return time.Unix(int64(CustomEpoch)+int64(secs), 0)
+}
+
+// Timestamp converts t to the seconds since CustomEpoch a record stores as its
+// timestamp.
+func Timestamp(t time.Time) uint32 {
+	return uint32(t.Unix()) - uint32(CustomEpoch)
 }
 
 // Encode encode the record to be inserted into db
 // TODO: this should return an error too
 func Encode(key, val []byte) []byte {
-	greaterThanUint32MAX := len(key) > math.MaxUint32 || len(val) > math.MaxUint32
+	return EncodeRecord(Record{Key: key, Value: val})
+}
+
+// EncodeRecord encodes the key, value, timestamp, expiry and flags of rec, the
+// remaining fields are computed. A zero timestamp is set to now, see Timestamp.
+func EncodeRecord(rec Record) []byte {
+	key, val := rec.Key, rec.Value
+
+	greaterThanUint32MAX := uint64(HeaderSize)+uint64(len(key))+uint64(len(val)) > math.MaxUint32
 	if len(key) == 0 || greaterThanUint32MAX {
 		return []byte{}
 	}
@@ -46,106 +104,135 @@ func Encode(key, val []byte) []byte {
 	recordSize := HeaderSize + keySize + valSize
 
 	buf := make([]byte, recordSize)
-	binary.LittleEndian.PutUint32(buf[8:12], keySize)
-	binary.LittleEndian.PutUint32(buf[12:HeaderSize], valSize)
+	binary.LittleEndian.PutUint32(buf[8:12], rec.Expiry)
+	buf[12] = byte(rec.Flags)
+	binary.LittleEndian.PutUint32(buf[13:17], keySize)
+	binary.LittleEndian.PutUint32(buf[17:HeaderSize], valSize)
 
 	copy(buf[HeaderSize:HeaderSize+keySize], key)
 
 	copy(buf[HeaderSize+keySize:], val)
 
-	crc := GenerateCRC(keySize, valSize, key, val)
+	crc := GenerateCRC(buf[8:HeaderSize], key, val)
 	binary.LittleEndian.PutUint32(buf[0:4], crc)
 
-	ts32 := uint32(time.Now().Unix()) - uint32(CustomEpoch)
+	ts32 := rec.Timestamp
+	if ts32 == 0 {
+		ts32 = Timestamp(time.Now())
+	}
 	binary.LittleEndian.PutUint32(buf[4:8], ts32)
 
 	return buf
 }
 
-// Decode decode the record retrieve from the db
+// Decode decode the record retrieve from the db, it returns the record and
+// how many bytes it takes in the file.
 func Decode(
 	f *os.File,
 	offset int64,
 ) (Record, int64, error) {
-	stat, err := f.Stat()
+	header, fileSize, err := readHeader(f, offset, HeaderSize)
 	if err != nil {
-		return Record{}, -1, nil
+		return Record{}, -1, err
 	}
 
-	if offset+int64(HeaderSize) > stat.Size() {
-		return Record{}, -1, fmt.Errorf("%w: offset + header size greater than file size", ErrPartialWrite)
+	rec := Record{
+		Crc:       binary.LittleEndian.Uint32(header[0:4]),
+		Timestamp: binary.LittleEndian.Uint32(header[4:8]),
+		Expiry:    binary.LittleEndian.Uint32(header[8:12]),
+		Flags:     Flag(header[12]),
+		KeySize:   binary.LittleEndian.Uint32(header[13:17]),
+		ValueSize: binary.LittleEndian.Uint32(header[17:HeaderSize]),
 	}
 
-	header := make([]byte, HeaderSize)
-	_, err = f.ReadAt(header, offset)
+	return readBody(f, offset, fileSize, header[8:HeaderSize], rec)
+}
+
+// V0HeaderSize is the size of the header of V0 records:
+// crc(4) + timestamp(4) + keySize(4) + valSize(4).
+const V0HeaderSize = uint32(16)
+
+// DecodeV0 decodes a record of segments written before the segment header
+// existed. They have no expiry and no flags, their checksum covers the key
+// size, the value size, the key and the value.
+func DecodeV0(f *os.File, offset int64) (Record, int64, error) {
+	header, fileSize, err := readHeader(f, offset, V0HeaderSize)
 	if err != nil {
 		return Record{}, -1, err
 	}
 
-	crc := binary.LittleEndian.Uint32(header[0:4])
-	timestamp := binary.LittleEndian.Uint32(header[4:8])
-	keySize := binary.LittleEndian.Uint32(header[8:12])
+	rec := Record{
+		Crc:       binary.LittleEndian.Uint32(header[0:4]),
+		Timestamp: binary.LittleEndian.Uint32(header[4:8]),
+		KeySize:   binary.LittleEndian.Uint32(header[8:12]),
+		ValueSize: binary.LittleEndian.Uint32(header[12:V0HeaderSize]),
+	}
+
+	return readBody(f, offset, fileSize, header[8:V0HeaderSize], rec)
+}
+
+// readHeader reads the size bytes of the header of the record at offset, it
+// also returns the size of f.
+func readHeader(f *os.File, offset int64, size uint32) ([]byte, int64, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if offset+int64(size) > stat.Size() {
+		return nil, 0, fmt.Errorf("%w: offset + header size greater than file size", ErrPartialWrite)
+	}
+
+	header := make([]byte, size)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+
+	return header, stat.Size(), nil
+}
+
+// readBody reads the key and value of rec, whose header is at offset, and
+// checks them against its checksum, computed over checked, the key and the
+// value. It returns rec and how many bytes it takes in the file.
+func readBody(f *os.File, offset, fileSize int64, checked []byte, rec Record) (Record, int64, error) {
 	// record without a key is useless
-	if keySize == 0 {
+	if rec.KeySize == 0 {
 		return Record{}, -1, ErrEmptyKey
 	}
-	valSize := binary.LittleEndian.Uint32(header[12:HeaderSize])
 
-	recordSize := HeaderSize + keySize + valSize
-	isBiggerThanFileSize := int64(recordSize)+offset > stat.Size()
-	if isBiggerThanFileSize {
+	headerSize := int64(len(checked)) + 8 // crc and timestamp are not checked
+	recordSize := headerSize + int64(rec.KeySize) + int64(rec.ValueSize)
+	if recordSize+offset > fileSize {
 		// This is a partial write
 		// Treat as corruption
 		// During index rebuild → stop scanning
 		return Record{}, -1, fmt.Errorf("%w: offset plus record size greater than file size", ErrPartialWrite)
 	}
-	offset += int64(HeaderSize)
 
-	key := make([]byte, keySize)
-	n, err := f.ReadAt(key, offset)
+	body := make([]byte, rec.KeySize+rec.ValueSize)
+	n, err := f.ReadAt(body, offset+headerSize)
 	if err != nil {
 		return Record{}, -1, err
 	}
-	bytesRead := n
-	offset += int64(keySize)
-
-	val := make([]byte, valSize)
-	n, err = f.ReadAt(val, offset)
-	if err != nil {
-		return Record{}, -1, err
-	}
-	bytesRead += n
-	if bytesRead != int(keySize)+int(valSize) {
+	if n != len(body) {
 		// Partial write
 		// Corruption
 		return Record{}, -1, fmt.Errorf("%w: bytes read different than key + value size", ErrPartialWrite)
 	}
-	offset += int64(valSize)
 
-	actualCRC := GenerateCRC(keySize, valSize, key, val)
-	if crc != actualCRC {
+	rec.Key, rec.Value = body[:rec.KeySize:rec.KeySize], body[rec.KeySize:]
+	if rec.Crc != GenerateCRC(checked, rec.Key, rec.Value) {
 		return Record{}, -1, ErrCorruptRecord
 	}
 
-	return Record{
-		Crc:       crc,
-		KeySize:   keySize,
-		ValueSize: valSize,
-		Key:       key,
-		Value:     val,
-		Timestamp: timestamp,
-	}, offset, nil
+	return rec, recordSize, nil
 }
 
-func GenerateCRC(keySize, valSize uint32, key, val []byte) uint32 {
+// GenerateCRC computes the checksum of a record from the header fields that
+// follow the timestamp, the key and the value.
+func GenerateCRC(header, key, val []byte) uint32 {
 	crcTable := crc32.MakeTable(crc32.Castagnoli) // or crc32.IEEE — either is fine
-	crcBuf := make([]byte, 8+keySize+valSize)
-
-	binary.LittleEndian.PutUint32(crcBuf[0:4], keySize)
-	binary.LittleEndian.PutUint32(crcBuf[4:8], valSize)
-
-	copy(crcBuf[8:8+keySize], key)
-	copy(crcBuf[8+keySize:], val)
-
-	return crc32.Checksum(crcBuf, crcTable)
+	crc := crc32.Update(0, crcTable, header)
+	crc = crc32.Update(crc, crcTable, key)
+	return crc32.Update(crc, crcTable, val)
 }
@@ -0,0 +1,93 @@
+package record
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Version identifies the layout of the records of a segment, it is stored in
+// the segment header.
+type Version uint16
+
+const (
+	// V0 is the layout of segments written before the segment header
+	// existed, see DecodeV0. It is never written.
+	V0 Version = 0
+	// V1 is the layout described by HeaderSize.
+	V1 Version = 1
+	// V2 is V1 with the codec of the value in the upper bits of the flags,
+	// see Compress. Readers that only know V1 would return compressed values.
+	V2 Version = 2
+	// V3 is V2 with FlagEncrypted, see Encrypt.
+	V3 Version = 3
+	// V4 is V3 with FlagMeta, see JoinMeta. Readers that only know V3 would
+	// return the metadata as part of the value.
+	V4 Version = 4
+
+	// CurrentVersion is the layout Encode writes.
+	CurrentVersion = V4
+)
+
+var ErrUnknownVersion = errors.New("unknown record format version")
+
+// Decoder reads the record at offset in f, it returns the record and how many
+// bytes it takes in the file.
+type Decoder func(f *os.File, offset int64) (Record, int64, error)
+
+var (
+	decodersMu sync.RWMutex
+	decoders   = map[Version]Decoder{V0: DecodeV0, V1: Decode, V2: Decode, V3: Decode, V4: Decode}
+)
+
+// RegisterDecoder makes DecodeVersion read records of version v with d.
+// When the layout written by Encode changes, the decoder of the previous
+// layout is kept registered so older segments stay readable.
+func RegisterDecoder(v Version, d Decoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+
+	decoders[v] = d
+}
+
+// DecodeVersion decodes the record at offset in f with the decoder of version v.
+func DecodeVersion(v Version, f *os.File, offset int64) (Record, int64, error) {
+	decodersMu.RLock()
+	d, ok := decoders[v]
+	decodersMu.RUnlock()
+
+	if !ok {
+		return Record{}, -1, fmt.Errorf("%w: %d", ErrUnknownVersion, v)
+	}
+
+	return d(f, offset)
+}
+
+// HeaderSizeOf returns the size of the header of the records of version v.
+func HeaderSizeOf(v Version) uint32 {
+	if v == V0 {
+		return V0HeaderSize
+	}
+	return HeaderSize
+}
+
+// PeekHeader returns the key size, value size and flags of the record of
+// version v whose header starts header, which holds at least HeaderSizeOf(v)
+// bytes. Nothing is checked, see DecodeVersion.
+func PeekHeader(v Version, header []byte) (keySize, valSize uint32, flags Flag) {
+	if v == V0 {
+		return binary.LittleEndian.Uint32(header[8:12]), binary.LittleEndian.Uint32(header[12:16]), 0
+	}
+	return binary.LittleEndian.Uint32(header[13:17]), binary.LittleEndian.Uint32(header[17:21]), Flag(header[12])
+}
+
+// SupportedVersion reports whether records of version v can be decoded.
+func SupportedVersion(v Version) bool {
+	decodersMu.RLock()
+	defer decodersMu.RUnlock()
+
+	_, ok := decoders[v]
+	return ok
+}
@@ -0,0 +1,388 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1garo/kival/kv"
+)
+
+// defaultScanCount is how many keys SCAN returns without COUNT.
+const defaultScanCount = 10
+
+// command is a command the server supports.
+type command struct {
+	// arity counts the arguments, name included: exactly arity when positive,
+	// at least -arity when negative.
+	arity int
+	run   func(c *conn, args [][]byte)
+}
+
+var commands = map[string]command{
+	"ping":    {-1, (*conn).ping},
+	"echo":    {2, (*conn).echo},
+	"quit":    {1, (*conn).quitCmd},
+	"select":  {2, (*conn).selectDB},
+	"command": {-1, (*conn).commandCmd},
+	"get":     {2, (*conn).get},
+	"set":     {-3, (*conn).set},
+	"del":     {-2, (*conn).del},
+	"exists":  {-2, (*conn).exists},
+	"keys":    {2, (*conn).keys},
+	"scan":    {-2, (*conn).scan},
+	"merge":   {1, (*conn).merge},
+	"info":    {-1, (*conn).info},
+}
+
+// exec runs the command args[0] with the arguments that follow.
+func (c *conn) exec(args [][]byte) {
+	name := strings.ToLower(string(args[0]))
+	cmd, ok := commands[name]
+	if !ok {
+		c.w.writeErrorf("ERR unknown command '%s'", truncate(args[0]))
+		return
+	}
+
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		c.w.writeErrorf("ERR wrong number of arguments for '%s' command", name)
+		return
+	}
+
+	cmd.run(c, args[1:])
+}
+
+// dbError replies with err, as returned by the db.
+func (c *conn) dbError(err error) {
+	c.w.writeErrorf("ERR %v", err)
+}
+
+// PING [message]
+func (c *conn) ping(args [][]byte) {
+	switch len(args) {
+	case 0:
+		c.w.simple("PONG")
+	case 1:
+		c.w.bulk(args[0])
+	default:
+		c.w.writeError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+// ECHO message
+func (c *conn) echo(args [][]byte) {
+	c.w.bulk(args[0])
+}
+
+// QUIT
+func (c *conn) quitCmd([][]byte) {
+	c.w.simple("OK")
+	c.quit = true
+}
+
+// SELECT index, there is a single database.
+func (c *conn) selectDB(args [][]byte) {
+	if string(args[0]) != "0" {
+		c.w.writeError("ERR DB index is out of range")
+		return
+	}
+	c.w.simple("OK")
+}
+
+// COMMAND, clients like redis-cli ask for it when they connect. They cope
+// with an empty reply.
+func (c *conn) commandCmd([][]byte) {
+	c.w.array(0)
+}
+
+// GET key
+func (c *conn) get(args [][]byte) {
+	val, err := c.server.db.Get(args[0])
+	switch {
+	case errors.Is(err, kv.ErrKeyNotFound):
+		c.w.null()
+	case err != nil:
+		c.dbError(err)
+	default:
+		c.w.bulk(val)
+	}
+}
+
+// SET key value [EX seconds | PX milliseconds]
+func (c *conn) set(args [][]byte) {
+	key, val := args[0], args[1]
+
+	var ttl time.Duration
+	for opts := args[2:]; len(opts) > 0; opts = opts[2:] {
+		unit := time.Duration(0)
+		switch strings.ToLower(string(opts[0])) {
+		case "ex":
+			unit = time.Second
+		case "px":
+			unit = time.Millisecond
+		}
+		if unit == 0 || len(opts) < 2 || ttl != 0 {
+			c.w.writeError("ERR syntax error")
+			return
+		}
+
+		n, err := strconv.ParseInt(string(opts[1]), 10, 64)
+		if err != nil || n <= 0 {
+			c.w.writeError("ERR invalid expire time in 'set' command")
+			return
+		}
+		ttl = time.Duration(n) * unit
+	}
+
+	var err error
+	if ttl > 0 {
+		err = c.server.db.PutWithTTL(key, val, ttl)
+	} else {
+		err = c.server.db.Put(key, val)
+	}
+	if err != nil {
+		c.dbError(err)
+		return
+	}
+
+	c.w.simple("OK")
+}
+
+// DEL key [key ...], replies with how many keys were deleted.
+func (c *conn) del(args [][]byte) {
+	var n int64
+	for _, key := range args {
+		err := c.server.db.Del(key)
+		if errors.Is(err, kv.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			c.dbError(err)
+			return
+		}
+		n++
+	}
+
+	c.w.integer(n)
+}
+
+// EXISTS key [key ...], replies with how many of the keys exist, a key given
+// twice counts twice.
+func (c *conn) exists(args [][]byte) {
+	var n int64
+	for _, key := range args {
+		found, err := c.has(key)
+		if err != nil {
+			c.dbError(err)
+			return
+		}
+		if found {
+			n++
+		}
+	}
+
+	c.w.integer(n)
+}
+
+// has reports whether key exists without reading its value.
+func (c *conn) has(key []byte) (bool, error) {
+	end := append(bytes.Clone(key), 0)
+	it := c.server.db.Scan(key, end, kv.KeysOnly())
+	found := it.Next()
+	return found, it.Err()
+}
+
+// KEYS pattern
+func (c *conn) keys(args [][]byte) {
+	pattern := args[0]
+
+	var keys [][]byte
+	it := c.server.db.ScanPrefix(globPrefix(pattern), kv.KeysOnly())
+	for it.Next() {
+		if matchGlob(pattern, it.Key()) {
+			keys = append(keys, it.Key())
+		}
+	}
+	if err := it.Err(); err != nil {
+		c.dbError(err)
+		return
+	}
+
+	c.w.array(len(keys))
+	for _, k := range keys {
+		c.w.bulk(k)
+	}
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+//
+// Keys come in order. A cursor stands for the last key walked, which the
+// server keeps, so the next call seeks past it instead of walking the keys
+// before it again. As with Redis, a key written or deleted during the
+// iteration may be missed, but it is never returned twice.
+func (c *conn) scan(args [][]byte) {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		c.w.writeError("ERR invalid cursor")
+		return
+	}
+
+	var pattern []byte
+	count := defaultScanCount
+	for opts := args[1:]; len(opts) > 0; opts = opts[2:] {
+		if len(opts) < 2 {
+			c.w.writeError("ERR syntax error")
+			return
+		}
+
+		switch strings.ToLower(string(opts[0])) {
+		case "match":
+			pattern = opts[1]
+		case "count":
+			n, err := strconv.Atoi(string(opts[1]))
+			if err != nil || n < 1 {
+				c.w.writeError("ERR value is not an integer or out of range")
+				return
+			}
+			count = n
+		default:
+			c.w.writeError("ERR syntax error")
+			return
+		}
+	}
+
+	var start []byte
+	if cursor != 0 {
+		last, ok := c.server.scanCursors.take(cursor)
+		if !ok {
+			c.w.writeError("ERR invalid cursor")
+			return
+		}
+		// the smallest key greater than last
+		start = append(last, 0)
+	}
+
+	var keys [][]byte
+	var last []byte
+	next := uint64(0)
+	walked := 0
+	it := c.server.db.Scan(start, nil, kv.KeysOnly())
+	for it.Next() {
+		if walked == count {
+			next = c.server.scanCursors.add(last)
+			break
+		}
+		walked++
+		last = it.Key()
+		if pattern == nil || matchGlob(pattern, last) {
+			keys = append(keys, last)
+		}
+	}
+	if err := it.Err(); err != nil {
+		c.dbError(err)
+		return
+	}
+
+	c.w.array(2)
+	c.w.bulkString(strconv.FormatUint(next, 10))
+	c.w.array(len(keys))
+	for _, k := range keys {
+		c.w.bulk(k)
+	}
+}
+
+// MERGE compacts the log files of the db, see kv.KV.Merge.
+func (c *conn) merge([][]byte) {
+	if err := c.server.db.Merge(); err != nil {
+		c.dbError(err)
+		return
+	}
+
+	c.w.simple("OK")
+}
+
+// INFO [section ...]
+func (c *conn) info(args [][]byte) {
+	want := func(section string) bool {
+		if len(args) == 0 {
+			return true
+		}
+		for _, a := range args {
+			if s := strings.ToLower(string(a)); s == section || s == "all" || s == "everything" {
+				return true
+			}
+		}
+		return false
+	}
+
+	var b strings.Builder
+	section := func(name string, fields ...any) {
+		if b.Len() > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", name)
+		for i := 0; i < len(fields); i += 2 {
+			fmt.Fprintf(&b, "%s:%v\r\n", fields[i], fields[i+1])
+		}
+	}
+
+	s := c.server
+	if want("server") {
+		section("Server",
+			"uptime_in_seconds", int64(time.Since(s.started).Seconds()),
+			"tcp_port", port(c.nc.LocalAddr().String()),
+		)
+	}
+	if want("clients") {
+		section("Clients", "connected_clients", s.clients())
+	}
+
+	if want("keyspace") {
+		var keys int
+		it := s.db.Scan(nil, nil, kv.KeysOnly())
+		for it.Next() {
+			keys++
+		}
+		if err := it.Err(); err != nil {
+			c.dbError(err)
+			return
+		}
+		section("Keyspace", "keys", keys)
+	}
+
+	if want("segments") {
+		stats, err := s.db.Stats()
+		if err != nil {
+			c.dbError(err)
+			return
+		}
+
+		var size, live, dead int64
+		for _, st := range stats {
+			size, live, dead = size+st.Size, live+st.LiveBytes, dead+st.DeadBytes
+		}
+		ratio := 0.0
+		if size > 0 {
+			ratio = float64(dead) / float64(size)
+		}
+		section("Segments",
+			"segments", len(stats),
+			"size_bytes", size,
+			"live_bytes", live,
+			"dead_bytes", dead,
+			"dead_ratio", strconv.FormatFloat(ratio, 'f', 4, 64),
+		)
+	}
+
+	c.w.bulkString(b.String())
+}
+
+func port(addr string) string {
+	if i := strings.LastIndexByte(addr, ':'); i >= 0 {
+		return addr[i+1:]
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package server_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommands_GetSetDel(t *testing.T) {
+	db, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	assert.Nil(t, c.do(t, "GET", "k1"))
+	assert.Equal(t, "OK", c.do(t, "SET", "k1", "v1"))
+	assert.Equal(t, "v1", c.do(t, "GET", "k1"))
+
+	val, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val), "writes go to the db")
+
+	assert.Equal(t, "OK", c.do(t, "SET", "k2", "v2"))
+	assert.Equal(t, int64(2), c.do(t, "DEL", "k1", "k2", "k3"))
+	assert.Equal(t, int64(0), c.do(t, "DEL", "k1"))
+	assert.Nil(t, c.do(t, "GET", "k1"))
+}
+
+func TestCommands_Exists(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.do(t, "SET", "k1", "v1")
+	c.do(t, "SET", "k10", "v10")
+
+	assert.Equal(t, int64(0), c.do(t, "EXISTS", "k"))
+	assert.Equal(t, int64(1), c.do(t, "EXISTS", "k1"))
+	assert.Equal(t, int64(3), c.do(t, "EXISTS", "k1", "k1", "k10", "k2"))
+}
+
+func TestCommands_SetTTL(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, "OK", c.do(t, "SET", "k1", "v1", "PX", "1"))
+	assert.Equal(t, "OK", c.do(t, "SET", "k2", "v2", "ex", "100"))
+	time.Sleep(1100 * time.Millisecond) // expiry has a one second resolution
+
+	assert.Nil(t, c.do(t, "GET", "k1"))
+	assert.Equal(t, "v2", c.do(t, "GET", "k2"))
+
+	for _, args := range [][]string{
+		{"SET", "k", "v", "EX"},
+		{"SET", "k", "v", "NX"},
+		{"SET", "k", "v", "EX", "1", "PX", "1"},
+	} {
+		assert.Equal(t, respError("ERR syntax error"), c.do(t, args...), "%v", args)
+	}
+	for _, ttl := range []string{"0", "-1", "x"} {
+		assert.Equal(t, respError("ERR invalid expire time in 'set' command"), c.do(t, "SET", "k", "v", "EX", ttl))
+	}
+}
+
+func TestCommands_Errors(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, respError("ERR unknown command 'HSET'"), c.do(t, "HSET", "h", "f", "v"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'get' command"), c.do(t, "GET"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'set' command"), c.do(t, "SET", "k"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'del' command"), c.do(t, "DEL"))
+	assert.Equal(t, respError("ERR wrong number of arguments for 'ping' command"), c.do(t, "PING", "a", "b"))
+	assert.IsType(t, respError(""), c.do(t, "SET", "", "v"), "db errors are replied")
+	assert.IsType(t, respError(""), c.do(t, "SET", "k", ""), "empty values are rejected")
+	assert.Nil(t, c.do(t, "GET", "k"))
+	assert.Equal(t, "PONG", c.do(t, "PING"), "the connection is still usable")
+}
+
+func TestCommands_Keys(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	for _, k := range []string{"user:1", "user:2", "user:10", "order:1", "u*"} {
+		c.do(t, "SET", k, "v")
+	}
+
+	for pattern, want := range map[string][]any{
+		"*":         {"order:1", "u*", "user:1", "user:10", "user:2"},
+		"user:*":    {"user:1", "user:10", "user:2"},
+		"user:?":    {"user:1", "user:2"},
+		"*:1":       {"order:1", "user:1"},
+		"user:[12]": {"user:1", "user:2"},
+		"u\\*":      {"u*"},
+		"nope*":     {},
+	} {
+		assert.Equal(t, want, c.do(t, "KEYS", pattern), "pattern %q", pattern)
+	}
+}
+
+func TestCommands_Scan(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	var want []string
+	for i := range 25 {
+		k := fmt.Sprintf("k%02d", i)
+		want = append(want, k)
+		c.do(t, "SET", k, "v")
+	}
+
+	// walk the keys through the cursor
+	var got []string
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		require.Less(t, calls, 10, "the scan does not end")
+
+		reply := c.do(t, "SCAN", cursor, "COUNT", "7").([]any)
+		require.Len(t, reply, 2)
+		for _, k := range reply[1].([]any) {
+			got = append(got, k.(string))
+		}
+
+		cursor = reply[0].(string)
+		if cursor == "0" {
+			break
+		}
+	}
+	assert.Equal(t, want, got)
+
+	reply := c.do(t, "SCAN", "0", "MATCH", "k1*", "COUNT", "100").([]any)
+	assert.Equal(t, "0", reply[0])
+	assert.Len(t, reply[1], 10)
+
+	reply = c.do(t, "SCAN", "0").([]any)
+	assert.Len(t, reply[1], 10, "10 keys by default")
+	cursor = reply[0].(string)
+	assert.NotEqual(t, "0", cursor)
+
+	// keys written behind the cursor are not walked again
+	c.do(t, "DEL", "k00")
+	c.do(t, "SET", "k00", "v")
+	reply = c.do(t, "SCAN", cursor, "COUNT", "1").([]any)
+	assert.Equal(t, []any{"k10"}, reply[1])
+	assert.Equal(t, respError("ERR invalid cursor"), c.do(t, "SCAN", cursor), "a cursor is used once")
+
+	assert.Equal(t, respError("ERR invalid cursor"), c.do(t, "SCAN", "x"))
+	assert.Equal(t, respError("ERR invalid cursor"), c.do(t, "SCAN", "12345"))
+	assert.Equal(t, respError("ERR syntax error"), c.do(t, "SCAN", "0", "MATCH"))
+	assert.Equal(t, respError("ERR syntax error"), c.do(t, "SCAN", "0", "TYPE", "string"))
+	assert.Equal(t, respError("ERR value is not an integer or out of range"), c.do(t, "SCAN", "0", "COUNT", "0"))
+}
+
+func TestCommands_ScanDropsOldestCursor(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+	c.do(t, "SET", "k1", "v")
+	c.do(t, "SET", "k2", "v")
+
+	first := c.do(t, "SCAN", "0", "COUNT", "1").([]any)[0].(string)
+	for range server.MaxScanCursors - 1 {
+		c.do(t, "SCAN", "0", "COUNT", "1")
+	}
+	last := c.do(t, "SCAN", "0", "COUNT", "1").([]any)[0].(string)
+
+	assert.Equal(t, respError("ERR invalid cursor"), c.do(t, "SCAN", first))
+	reply := c.do(t, "SCAN", last).([]any)
+	assert.Equal(t, []any{"0", []any{"k2"}}, reply)
+}
+
+func TestCommands_MergeInfo(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	for i := range 10 {
+		c.do(t, "SET", "k1", fmt.Sprintf("v%d", i))
+	}
+	c.do(t, "SET", "k2", "v")
+	assert.Equal(t, "OK", c.do(t, "MERGE"))
+	assert.Equal(t, "v9", c.do(t, "GET", "k1"))
+
+	info := c.do(t, "INFO").(string)
+	for _, field := range []string{
+		"# Server\r\n", "uptime_in_seconds:",
+		"connected_clients:1\r\n",
+		"# Keyspace\r\n", "keys:2\r\n",
+		"# Segments\r\n", "segments:", "dead_bytes:",
+	} {
+		assert.Contains(t, info, field)
+	}
+
+	info = c.do(t, "INFO", "keyspace").(string)
+	assert.Equal(t, "# Keyspace\r\nkeys:2\r\n", info)
+	assert.False(t, strings.Contains(c.do(t, "INFO", "clients").(string), "keys:"))
+}
+
+func TestCommands_ClientHandshake(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, []any{}, c.do(t, "COMMAND", "DOCS"))
+	assert.Equal(t, "OK", c.do(t, "SELECT", "0"))
+	assert.Equal(t, respError("ERR DB index is out of range"), c.do(t, "SELECT", "1"))
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abcd", false},
+		{"*b*", "abc", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"[abc]x", "bx", true},
+		{"[abc]x", "dx", false},
+		{"[^abc]x", "dx", true},
+		{"[^abc]x", "ax", false},
+		{"[a-c]", "b", true},
+		{"[c-a]", "b", true},
+		{"[a-c]", "d", false},
+		{"[\\]]", "]", true},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"[abc", "a", true},
+	} {
+		assert.Equal(t, tc.match, server.MatchGlob([]byte(tc.pattern), []byte(tc.s)), "%q ~ %q", tc.pattern, tc.s)
+	}
+}
+
+func TestGlobPrefix(t *testing.T) {
+	for pattern, want := range map[string]string{
+		"":        "",
+		"*":       "",
+		"user:*":  "user:",
+		"a?b":     "a",
+		"ab[cd]":  "ab",
+		"a\\*b*":  "a*b",
+		"literal": "literal",
+	} {
+		assert.Equal(t, want, string(server.GlobPrefix([]byte(pattern))), "%q", pattern)
+	}
+}
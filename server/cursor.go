@@ -0,0 +1,45 @@
+package server
+
+import "sync"
+
+// maxScanCursors is how many SCAN cursors the server keeps, once past it the
+// oldest one is dropped and using it fails with an invalid cursor error.
+const maxScanCursors = 1024
+
+// scanCursors maps the cursors handed out by SCAN to the last key walked, so
+// the next call resumes right after it. A cursor is good for one call, a
+// client walking the keys is given a new one each time.
+type scanCursors struct {
+	mu    sync.Mutex
+	last  map[uint64][]byte
+	order [maxScanCursors]uint64 // ring of the cursors handed out, by age
+	seq   uint64                 // last cursor handed out, 0 is never one
+}
+
+// add returns a new cursor that resumes after key.
+func (sc *scanCursors) add(key []byte) uint64 {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.last == nil {
+		sc.last = make(map[uint64][]byte)
+	}
+
+	sc.seq++
+	slot := &sc.order[sc.seq%maxScanCursors]
+	// drop the cursor handed out maxScanCursors calls ago, unless it was used
+	delete(sc.last, *slot)
+	*slot = sc.seq
+	sc.last[sc.seq] = key
+	return sc.seq
+}
+
+// take returns the key cursor resumes after and forgets the cursor.
+func (sc *scanCursors) take(cursor uint64) ([]byte, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	key, ok := sc.last[cursor]
+	delete(sc.last, cursor)
+	return key, ok
+}
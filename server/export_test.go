@@ -0,0 +1,15 @@
+package server
+
+// MatchGlob and GlobPrefix expose the pattern matching of KEYS and SCAN.
+var (
+	MatchGlob  = matchGlob
+	GlobPrefix = globPrefix
+)
+
+// ReadBlock reads the bulk strings and data blocks of clients.
+var ReadBlock = readBlock
+
+const (
+	MaxBulkSize    = maxBulkSize
+	MaxScanCursors = maxScanCursors
+)
@@ -0,0 +1,107 @@
+package server
+
+// matchGlob reports whether s matches the glob-style pattern of KEYS and SCAN,
+// as Redis reads it: '*' matches any sequence of bytes, '?' any single byte,
+// [abc] one of the bytes in the brackets, [a-c] a range of them, [^abc] any
+// byte but those, and a backslash makes the byte after it literal.
+func matchGlob(pattern, s []byte) bool {
+	// backtrack to the last star when the rest fails to match
+	star, retry := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, retry = p, i
+				p++
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if end, ok := matchClass(pattern, p, s[i]); ok {
+					p = end
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) && pattern[p+1] == s[i] {
+					p += 2
+					i++
+					continue
+				}
+			default:
+				if pattern[p] == s[i] {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+
+		if star < 0 {
+			return false
+		}
+		retry++
+		p, i = star+1, retry
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches b against the class that opens at pattern[start], a '['.
+// It returns the index past the class and whether b is in it. An unterminated
+// class runs to the end of the pattern.
+func matchClass(pattern []byte, start int, b byte) (int, bool) {
+	p := start + 1
+	negate := p < len(pattern) && pattern[p] == '^'
+	if negate {
+		p++
+	}
+
+	match := false
+	for ; p < len(pattern) && pattern[p] != ']'; p++ {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			match = match || pattern[p] == b
+		case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+			lo, hi := pattern[p], pattern[p+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			match = match || (lo <= b && b <= hi)
+			p += 2
+		default:
+			match = match || pattern[p] == b
+		}
+	}
+	if p < len(pattern) {
+		p++ // past ']'
+	}
+
+	return p, match != negate
+}
+
+// globPrefix returns the literal bytes pattern starts with, every key it
+// matches has them as a prefix.
+func globPrefix(pattern []byte) []byte {
+	var prefix []byte
+	for p := 0; p < len(pattern); p++ {
+		switch pattern[p] {
+		case '*', '?', '[':
+			return prefix
+		case '\\':
+			if p+1 == len(pattern) {
+				return prefix
+			}
+			p++
+		}
+		prefix = append(prefix, pattern[p])
+	}
+	return prefix
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/log"
+	"github.com/1garo/kival/record"
+)
+
+const (
+	// defaultListLimit is how many keys GET /v1/keys returns without limit.
+	defaultListLimit = 1000
+	// maxListLimit bounds the limit of GET /v1/keys.
+	maxListLimit = 100_000
+)
+
+// HTTPHandler returns a handler serving the db as a REST API:
+//
+//	GET    /v1/keys/{key}   the value, as the raw response body
+//	PUT    /v1/keys/{key}   set the value to the raw request body, ?ttl=30s to expire it
+//	DELETE /v1/keys/{key}   delete the key
+//	GET    /v1/keys         list the keys in order, see listKeys
+//	POST   /v1/admin/merge  compact the log files
+//	GET    /v1/stats        the stats of every log file
+//
+// Errors come as {"error": "..."} with a status code that tells their kind,
+// see httpStatus.
+func (s *Server) HTTPHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/keys/{key...}", s.getKey)
+	mux.HandleFunc("PUT /v1/keys/{key...}", s.putKey)
+	mux.HandleFunc("DELETE /v1/keys/{key...}", s.deleteKey)
+	mux.HandleFunc("GET /v1/keys", s.listKeys)
+	mux.HandleFunc("POST /v1/admin/merge", s.mergeHTTP)
+	mux.HandleFunc("GET /v1/stats", s.stats)
+	return mux
+}
+
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request) {
+	val, err := s.db.Get([]byte(r.PathValue("key")))
+	if err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(val)))
+	_, _ = w.Write(val)
+}
+
+func (s *Server) putKey(w http.ResponseWriter, r *http.Request) {
+	var ttl time.Duration
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			writeError(w, http.StatusBadRequest, "ttl must be a positive duration, like 30s")
+			return
+		}
+	}
+
+	val, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBulkSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err.Error())
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	key := []byte(r.PathValue("key"))
+	if ttl > 0 {
+		err = s.db.PutWithTTL(key, val, ttl)
+	} else {
+		err = s.db.Put(key, val)
+	}
+	if err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Del([]byte(r.PathValue("key"))); err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// keyList is the body of GET /v1/keys. Next is set when there are more keys,
+// pass it as start to get them. Keys are bytes, not necessarily UTF-8, so
+// they are base64 encoded like JSON encodes []byte.
+type keyList struct {
+	Keys [][]byte `json:"keys"`
+	Next []byte   `json:"next,omitempty"`
+}
+
+// listKeys answers GET /v1/keys?prefix=&start=&limit=, the keys starting with
+// prefix from start on, at most limit of them.
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := []byte(q.Get("prefix"))
+	start := []byte(q.Get("start"))
+
+	limit := defaultListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxListLimit {
+			writeError(w, http.StatusBadRequest, "limit must be between 1 and "+strconv.Itoa(maxListLimit))
+			return
+		}
+		limit = n
+	}
+
+	// keys come in order, the ones with the prefix are next to each other
+	from := start
+	if bytes.Compare(prefix, start) > 0 {
+		from = prefix
+	}
+
+	list := keyList{Keys: [][]byte{}}
+	it := s.db.Scan(from, nil, kv.KeysOnly())
+	for it.Next() {
+		if !bytes.HasPrefix(it.Key(), prefix) {
+			break
+		}
+		if len(list.Keys) == limit {
+			list.Next = it.Key()
+			break
+		}
+		list.Keys = append(list.Keys, it.Key())
+	}
+	if err := it.Err(); err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) mergeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := s.db.Merge(); err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// segmentStats is a log file in the body of GET /v1/stats.
+type segmentStats struct {
+	ID        uint32  `json:"id"`
+	Size      int64   `json:"size"`
+	LiveBytes int64   `json:"live_bytes"`
+	DeadBytes int64   `json:"dead_bytes"`
+	DeadRatio float64 `json:"dead_ratio"`
+	Active    bool    `json:"active"`
+}
+
+// statsBody is the body of GET /v1/stats, the log files and their total.
+type statsBody struct {
+	Segments  []segmentStats `json:"segments"`
+	Size      int64          `json:"size"`
+	LiveBytes int64          `json:"live_bytes"`
+	DeadBytes int64          `json:"dead_bytes"`
+}
+
+func (s *Server) stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.db.Stats()
+	if err != nil {
+		s.httpError(w, r, err)
+		return
+	}
+
+	body := statsBody{Segments: make([]segmentStats, 0, len(stats))}
+	for _, st := range stats {
+		body.Segments = append(body.Segments, segmentStats{
+			ID:        st.ID,
+			Size:      st.Size,
+			LiveBytes: st.LiveBytes,
+			DeadBytes: st.DeadBytes,
+			DeadRatio: st.DeadRatio(),
+			Active:    st.Active,
+		})
+		body.Size += st.Size
+		body.LiveBytes += st.LiveBytes
+		body.DeadBytes += st.DeadBytes
+	}
+
+	writeJSON(w, http.StatusOK, body)
+}
+
+// httpStatus maps an error of the db to the status code of its reply.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, kv.ErrKeyNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, record.ErrEncodeInput),
+		errors.Is(err, kv.ErrInvalidTTL),
+		errors.Is(err, kv.ErrEmptyValue):
+		return http.StatusBadRequest
+	case errors.Is(err, log.ErrWriteTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, log.ErrReadOnlySegment):
+		return http.StatusForbidden
+	case errors.Is(err, log.ErrCapacityExceeded),
+		errors.Is(err, log.ErrMergeTooLarge):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, kv.ErrClosed),
+		errors.Is(err, log.ErrLogClosed):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// httpError replies with err. Errors that are not the client's fault are
+// logged.
+func (s *Server) httpError(w http.ResponseWriter, r *http.Request, err error) {
+	status := httpStatus(err)
+	if status >= http.StatusInternalServerError {
+		s.logger.Error("cannot serve request", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+
+	writeError(w, status, err.Error())
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
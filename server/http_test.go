@@ -0,0 +1,268 @@
+package server_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestHTTP serves a fresh db over HTTP and returns both. They are closed
+// when the test ends.
+func newTestHTTP(t *testing.T) (kv.KV, *httptest.Server) {
+	t.Helper()
+
+	db, err := kv.New(t.TempDir())
+	require.NoError(t, err)
+
+	srv, err := server.New(db, server.WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv.HTTPHandler())
+	t.Cleanup(func() {
+		ts.Close()
+		if err := db.Close(); !errors.Is(err, kv.ErrClosed) {
+			require.NoError(t, err)
+		}
+	})
+
+	return db, ts
+}
+
+// request sends a request to ts and returns the status code and the body.
+func request(t *testing.T, ts *httptest.Server, method, path, body string) (int, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+	require.NoError(t, err)
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(b)
+}
+
+// errorBody returns the error message of a JSON error reply.
+func errorBody(t *testing.T, body string) string {
+	t.Helper()
+
+	var e struct{ Error string }
+	require.NoError(t, json.Unmarshal([]byte(body), &e), body)
+	return e.Error
+}
+
+func TestHTTP_Keys(t *testing.T) {
+	db, ts := newTestHTTP(t)
+
+	code, body := request(t, ts, http.MethodGet, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, kv.ErrKeyNotFound.Error(), errorBody(t, body))
+
+	code, _ = request(t, ts, http.MethodPut, "/v1/keys/k1", "v1")
+	assert.Equal(t, http.StatusNoContent, code)
+
+	val, err := db.Get([]byte("k1"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(val))
+
+	code, body = request(t, ts, http.MethodGet, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "v1", body)
+
+	code, _ = request(t, ts, http.MethodDelete, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusNoContent, code)
+	code, _ = request(t, ts, http.MethodDelete, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHTTP_KeyEscaping(t *testing.T) {
+	_, ts := newTestHTTP(t)
+
+	for _, key := range []string{"a/b", "a b", "a?b", "é", "a%2Fb"} {
+		path := "/v1/keys/" + url.PathEscape(key)
+		code, _ := request(t, ts, http.MethodPut, path, "v:"+key)
+		require.Equal(t, http.StatusNoContent, code, key)
+
+		code, body := request(t, ts, http.MethodGet, path, "")
+		assert.Equal(t, http.StatusOK, code, key)
+		assert.Equal(t, "v:"+key, body)
+	}
+
+	code, body := request(t, ts, http.MethodGet, "/v1/keys?prefix=a", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"keys":["YSBi","YSUyRmI=","YS9i","YT9i"]}`, body, "a b, a%2Fb, a/b and a?b in base64")
+}
+
+func TestHTTP_BinaryValue(t *testing.T) {
+	_, ts := newTestHTTP(t)
+
+	val := "\x00\xff\r\n"
+	code, _ := request(t, ts, http.MethodPut, "/v1/keys/bin", val)
+	require.Equal(t, http.StatusNoContent, code)
+
+	resp, err := ts.Client().Get(ts.URL + "/v1/keys/bin")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "application/octet-stream", resp.Header.Get("Content-Type"))
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, val, string(b))
+}
+
+func TestHTTP_ValueMeta(t *testing.T) {
+	db, ts := newTestHTTP(t)
+
+	require.NoError(t, db.PutMeta([]byte("k1"), []byte("data"), []byte("meta"), 0))
+	code, body := request(t, ts, http.MethodGet, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "data", body, "the metadata is not part of the value")
+
+	// a value is returned as is, whatever it starts with
+	val := "\x00MC" + strings.Repeat("\x00", 16) + "data"
+	require.NoError(t, db.Put([]byte("k2"), []byte(val)))
+	code, body = request(t, ts, http.MethodGet, "/v1/keys/k2", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, val, body)
+}
+
+func TestHTTP_TTL(t *testing.T) {
+	_, ts := newTestHTTP(t)
+
+	code, _ := request(t, ts, http.MethodPut, "/v1/keys/k1?ttl=1ms", "v1")
+	require.Equal(t, http.StatusNoContent, code)
+	time.Sleep(1100 * time.Millisecond) // expiry has a one second resolution
+
+	code, _ = request(t, ts, http.MethodGet, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	for _, ttl := range []string{"0s", "-1s", "x"} {
+		code, body := request(t, ts, http.MethodPut, "/v1/keys/k1?ttl="+ttl, "v1")
+		assert.Equal(t, http.StatusBadRequest, code, ttl)
+		assert.Contains(t, errorBody(t, body), "ttl")
+	}
+}
+
+func TestHTTP_BadRequests(t *testing.T) {
+	_, ts := newTestHTTP(t)
+
+	code, body := request(t, ts, http.MethodPut, "/v1/keys/", "v")
+	assert.Equal(t, http.StatusBadRequest, code, "empty key")
+	assert.NotEmpty(t, errorBody(t, body))
+
+	code, body = request(t, ts, http.MethodPut, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusBadRequest, code, "empty value")
+	assert.NotEmpty(t, errorBody(t, body))
+
+	code, _ = request(t, ts, http.MethodPost, "/v1/keys/k1", "v")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, _ = request(t, ts, http.MethodGet, "/v1/admin/merge", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	code, _ = request(t, ts, http.MethodGet, "/v2/keys/k1", "")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestHTTP_List(t *testing.T) {
+	db, ts := newTestHTTP(t)
+
+	for _, k := range []string{"a", "user:1", "user:2", "user:3", "v"} {
+		require.NoError(t, db.Put([]byte(k), []byte("v")))
+	}
+
+	type list struct {
+		Keys []string
+		Next string
+	}
+	for query, want := range map[string]list{
+		"":                           {Keys: []string{"a", "user:1", "user:2", "user:3", "v"}},
+		"?prefix=user:":              {Keys: []string{"user:1", "user:2", "user:3"}},
+		"?prefix=user:&limit=2":      {Keys: []string{"user:1", "user:2"}, Next: "user:3"},
+		"?prefix=user:&start=user:3": {Keys: []string{"user:3"}},
+		"?prefix=user:&start=b":      {Keys: []string{"user:1", "user:2", "user:3"}},
+		"?prefix=user:&start=w":      {Keys: []string{}},
+		"?prefix=nope":               {Keys: []string{}},
+		"?start=user:2&limit=1":      {Keys: []string{"user:2"}, Next: "user:3"},
+	} {
+		code, body := request(t, ts, http.MethodGet, "/v1/keys"+query, "")
+		assert.Equal(t, http.StatusOK, code, query)
+
+		var got struct {
+			Keys [][]byte
+			Next []byte
+		}
+		require.NoError(t, json.Unmarshal([]byte(body), &got), body)
+		keys := []string{}
+		for _, k := range got.Keys {
+			keys = append(keys, string(k))
+		}
+		assert.Equal(t, want, list{Keys: keys, Next: string(got.Next)}, query)
+	}
+
+	// keys are base64 encoded, so binary ones come back as they are
+	require.NoError(t, db.Put([]byte("bin:\xff\x00"), []byte("v")))
+	code, body := request(t, ts, http.MethodGet, "/v1/keys?prefix=bin:", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"keys":["YmluOv8A"]}`, body)
+
+	for _, limit := range []string{"0", "-1", "x", "100001"} {
+		code, _ := request(t, ts, http.MethodGet, "/v1/keys?limit="+limit, "")
+		assert.Equal(t, http.StatusBadRequest, code, limit)
+	}
+}
+
+func TestHTTP_MergeStats(t *testing.T) {
+	_, ts := newTestHTTP(t)
+
+	for range 5 {
+		request(t, ts, http.MethodPut, "/v1/keys/k1", "v1")
+	}
+
+	code, body := request(t, ts, http.MethodGet, "/v1/stats", "")
+	require.Equal(t, http.StatusOK, code)
+
+	var stats struct {
+		Segments []struct {
+			ID        uint32
+			Size      int64
+			LiveBytes int64   `json:"live_bytes"`
+			DeadBytes int64   `json:"dead_bytes"`
+			DeadRatio float64 `json:"dead_ratio"`
+			Active    bool
+		}
+		Size      int64
+		DeadBytes int64 `json:"dead_bytes"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(body), &stats), body)
+	require.Len(t, stats.Segments, 1)
+	assert.True(t, stats.Segments[0].Active)
+	assert.Equal(t, stats.Size, stats.Segments[0].Size)
+	assert.Positive(t, stats.DeadBytes, "overwrites leave dead bytes")
+	assert.InDelta(t, 0.8, stats.Segments[0].DeadRatio, 0.01)
+
+	code, _ = request(t, ts, http.MethodPost, "/v1/admin/merge", "")
+	assert.Equal(t, http.StatusNoContent, code)
+}
+
+func TestHTTP_ClosedDB(t *testing.T) {
+	db, ts := newTestHTTP(t)
+	require.NoError(t, db.Close())
+
+	code, body := request(t, ts, http.MethodGet, "/v1/keys/k1", "")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, kv.ErrClosed.Error(), errorBody(t, body))
+}
@@ -0,0 +1,450 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/record"
+)
+
+const (
+	// maxKeySize is the longest key memcached takes.
+	maxKeySize = 250
+	// maxRelativeExptime is the largest exptime read as seconds from now, a
+	// larger one is a unix time, as in memcached.
+	maxRelativeExptime = 60 * 60 * 24 * 30
+)
+
+// itemMetaSize is the size of the metadata of an item: flags(4), expiry(4)
+// and cas(8).
+const itemMetaSize = 4 + 4 + 8
+
+// item is a value stored through memcached. The flags and the expiry of the
+// client are stored as the metadata of the value, see kv.KV.PutMeta, so they
+// make it to the record with it while other clients only see the data. The
+// expiry is also the expiry of the record, the db drops the item on its own.
+type item struct {
+	flags  uint32
+	expiry uint32 // seconds since record.CustomEpoch, 0 means it never expires
+	cas    uint64
+	data   []byte
+}
+
+func (it item) meta() []byte {
+	buf := make([]byte, itemMetaSize)
+	binary.LittleEndian.PutUint32(buf, it.flags)
+	binary.LittleEndian.PutUint32(buf[4:], it.expiry)
+	binary.LittleEndian.PutUint64(buf[8:], it.cas)
+	return buf
+}
+
+// decodeItem reads an item stored by putItem. A value written some other way,
+// through Redis or the Go API, has no such metadata and reads as an item with
+// no flags and a cas derived from the value, so it changes when the value
+// does.
+func decodeItem(data, meta []byte) item {
+	if len(meta) != itemMetaSize {
+		h := fnv.New64a()
+		_, _ = h.Write(data)
+		return item{cas: h.Sum64(), data: data}
+	}
+
+	return item{
+		flags:  binary.LittleEndian.Uint32(meta),
+		expiry: binary.LittleEndian.Uint32(meta[4:]),
+		cas:    binary.LittleEndian.Uint64(meta[8:]),
+		data:   data,
+	}
+}
+
+// ServeMemcached serves the connections accepted on l with the memcached text
+// protocol, like Serve does for Redis. Close stops it too.
+//
+// Items carry the flags and expiry of the client as metadata of their data,
+// see item, so Redis and HTTP clients see the data alone. Commands that
+// read before they write, like add, cas or incr, are atomic with respect to
+// each other, not to writes made through other protocols.
+func (s *Server) ServeMemcached(l net.Listener) error {
+	return s.serve(l, s.serveMemcached)
+}
+
+// ListenAndServeMemcached listens on the TCP address addr and serves the
+// connections it accepts with the memcached text protocol.
+func (s *Server) ListenAndServeMemcached(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.ServeMemcached(l)
+}
+
+// mcConn is a memcached client connection.
+type mcConn struct {
+	server *Server
+	r      *bufio.Reader
+	w      *bufio.Writer
+	quit   bool
+}
+
+// errClientData is returned for a data block that cannot be read, the
+// connection cannot be read any further.
+var errClientData = errors.New("bad data chunk")
+
+func (s *Server) serveMemcached(nc net.Conn) {
+	c := &mcConn{server: s, r: bufio.NewReaderSize(nc, maxInlineSize), w: bufio.NewWriter(nc)}
+	for !c.quit {
+		line, err := c.r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			c.reply("CLIENT_ERROR line too long")
+			_ = c.w.Flush()
+			return
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !s.isClosed() {
+				s.logger.Debug("cannot read from client", "remote", nc.RemoteAddr(), "err", err)
+			}
+			return
+		}
+
+		args := bytes.Fields(line)
+		if len(args) == 0 {
+			c.reply("ERROR")
+		} else if err := c.exec(args); err != nil {
+			// the reply to the command tells why, if it can be sent
+			_ = c.w.Flush()
+			return
+		}
+
+		// pipelined commands are answered at once
+		if c.r.Buffered() > 0 && !c.quit {
+			continue
+		}
+		if err := c.w.Flush(); err != nil {
+			if !s.isClosed() {
+				s.logger.Debug("cannot write to client", "remote", nc.RemoteAddr(), "err", err)
+			}
+			return
+		}
+	}
+}
+
+func (c *mcConn) reply(line string) {
+	c.w.WriteString(line)
+	c.w.WriteString("\r\n")
+}
+
+// exec runs the command args[0]. It returns an error when the connection
+// cannot go on, once the reply saying so is written.
+func (c *mcConn) exec(args [][]byte) error {
+	name, args := string(args[0]), args[1:]
+	switch name {
+	case "get", "gets":
+		c.get(args, name == "gets")
+	case "set", "add", "replace", "cas":
+		return c.store(name, args)
+	case "delete":
+		c.delete(args)
+	case "incr", "decr":
+		c.incr(args, name == "incr")
+	case "version":
+		c.reply("VERSION kival")
+	case "quit":
+		c.quit = true
+	default:
+		c.reply("ERROR")
+	}
+	return nil
+}
+
+// noreply strips the noreply argument off args and reports whether it was
+// there.
+func noreply(args [][]byte) ([][]byte, bool) {
+	if n := len(args); n > 0 && string(args[n-1]) == "noreply" {
+		return args[:n-1], true
+	}
+	return args, false
+}
+
+func validKey(key []byte) bool {
+	if len(key) == 0 || len(key) > maxKeySize {
+		return false
+	}
+	for _, b := range key {
+		if b < 0x21 || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// get key*
+// gets key*
+func (c *mcConn) get(keys [][]byte, withCAS bool) {
+	if len(keys) == 0 {
+		c.reply("ERROR")
+		return
+	}
+
+	for _, key := range keys {
+		if !validKey(key) {
+			c.reply("CLIENT_ERROR bad command line format")
+			return
+		}
+	}
+
+	for _, key := range keys {
+		data, meta, err := c.server.db.GetMeta(key)
+		if errors.Is(err, kv.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			c.reply("SERVER_ERROR " + err.Error())
+			return
+		}
+
+		it := decodeItem(data, meta)
+		fmt.Fprintf(c.w, "VALUE %s %d %d", key, it.flags, len(it.data))
+		if withCAS {
+			fmt.Fprintf(c.w, " %d", it.cas)
+		}
+		c.w.WriteString("\r\n")
+		c.w.Write(it.data)
+		c.w.WriteString("\r\n")
+	}
+	c.reply("END")
+}
+
+// set key flags exptime bytes [noreply]
+// add key flags exptime bytes [noreply]
+// replace key flags exptime bytes [noreply]
+// cas key flags exptime bytes cas [noreply]
+//
+// The data block follows the command line. store returns an error when it
+// cannot be read, which ends the connection.
+func (c *mcConn) store(name string, args [][]byte) error {
+	args, quiet := noreply(args)
+
+	nargs := 4
+	if name == "cas" {
+		nargs = 5
+	}
+	if len(args) != nargs {
+		c.reply("ERROR")
+		return nil
+	}
+
+	size, err := strconv.Atoi(string(args[3]))
+	if err != nil || size < 0 {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+	if size > maxBulkSize {
+		c.reply("SERVER_ERROR object too large for cache")
+		return errClientData
+	}
+
+	// the data block is read even when the command is invalid, so it is not
+	// taken for the next command
+	data, err := readBlock(c.r, size+2)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(data, []byte("\r\n")) {
+		c.reply("CLIENT_ERROR " + errClientData.Error())
+		return errClientData
+	}
+
+	flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	var cas uint64
+	var err3 error
+	if name == "cas" {
+		cas, err3 = strconv.ParseUint(string(args[4]), 10, 64)
+	}
+	if errors.Join(err1, err2, err3) != nil || !validKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return nil
+	}
+
+	result := c.server.mcStore(name, args[0], item{flags: uint32(flags), data: data[:size]}, exptime, cas)
+	if !quiet {
+		c.reply(result)
+	}
+	return nil
+}
+
+// delete key [noreply]
+func (c *mcConn) delete(args [][]byte) {
+	args, quiet := noreply(args)
+	if len(args) != 1 || !validKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	// a cas running meanwhile would bring the item back
+	c.server.mcMu.Lock()
+	err := c.server.db.Del(args[0])
+	c.server.mcMu.Unlock()
+
+	result := "DELETED"
+	switch {
+	case errors.Is(err, kv.ErrKeyNotFound):
+		result = "NOT_FOUND"
+	case err != nil:
+		result = "SERVER_ERROR " + err.Error()
+	}
+
+	if !quiet {
+		c.reply(result)
+	}
+}
+
+// incr key value [noreply]
+// decr key value [noreply]
+func (c *mcConn) incr(args [][]byte, up bool) {
+	args, quiet := noreply(args)
+	if len(args) != 2 {
+		c.reply("ERROR")
+		return
+	}
+	if !validKey(args[0]) {
+		c.reply("CLIENT_ERROR bad command line format")
+		return
+	}
+
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.reply("CLIENT_ERROR invalid numeric delta argument")
+		return
+	}
+
+	result := c.server.mcIncr(args[0], delta, up)
+	if !quiet {
+		c.reply(result)
+	}
+}
+
+// expiry converts the exptime of a client to the expiry of an item. A
+// negative exptime, or a unix time in the past, expires the item at once.
+func expiry(exptime int64, now time.Time) (uint32, bool) {
+	switch {
+	case exptime == 0:
+		return 0, false
+	case exptime < 0:
+		return 0, true
+	case exptime <= maxRelativeExptime:
+		return record.Expiry(now.Add(time.Duration(exptime) * time.Second)), false
+	}
+
+	at := time.Unix(exptime, 0)
+	if !at.After(now) {
+		return 0, true
+	}
+	return record.Expiry(at), false
+}
+
+// mcStore runs a storage command and returns its reply.
+func (s *Server) mcStore(name string, key []byte, it item, exptime int64, cas uint64) string {
+	s.mcMu.Lock()
+	defer s.mcMu.Unlock()
+
+	if name != "set" {
+		data, meta, err := s.db.GetMeta(key)
+		found := err == nil
+		if err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+			return "SERVER_ERROR " + err.Error()
+		}
+
+		switch {
+		case name == "add" && found:
+			return "NOT_STORED"
+		case name == "replace" && !found:
+			return "NOT_STORED"
+		case name == "cas" && !found:
+			return "NOT_FOUND"
+		case name == "cas" && decodeItem(data, meta).cas != cas:
+			return "EXISTS"
+		}
+	}
+
+	now := time.Now()
+	exp, expired := expiry(exptime, now)
+	if expired {
+		// stored and expired right away
+		if err := s.db.Del(key); err != nil && !errors.Is(err, kv.ErrKeyNotFound) {
+			return "SERVER_ERROR " + err.Error()
+		}
+		return "STORED"
+	}
+
+	it.expiry = exp
+	if err := s.putItem(key, it, now); err != nil {
+		return "SERVER_ERROR " + err.Error()
+	}
+	return "STORED"
+}
+
+// mcIncr adds delta to the item at key, or subtracts it, and returns the
+// reply of incr or decr. The item keeps its flags and expiry.
+func (s *Server) mcIncr(key []byte, delta uint64, up bool) string {
+	s.mcMu.Lock()
+	defer s.mcMu.Unlock()
+
+	data, meta, err := s.db.GetMeta(key)
+	if errors.Is(err, kv.ErrKeyNotFound) {
+		return "NOT_FOUND"
+	}
+	if err != nil {
+		return "SERVER_ERROR " + err.Error()
+	}
+
+	now := time.Now()
+	it := decodeItem(data, meta)
+	if record.Expired(it.expiry, now) {
+		return "NOT_FOUND" // expired since the Get
+	}
+
+	n, err := strconv.ParseUint(string(it.data), 10, 64)
+	if err != nil {
+		return "CLIENT_ERROR cannot increment or decrement non-numeric value"
+	}
+
+	switch {
+	case up:
+		n += delta // wraps around at 2^64, as in memcached
+	case delta > n:
+		n = 0
+	default:
+		n -= delta
+	}
+
+	it.data = strconv.AppendUint(nil, n, 10)
+	if err := s.putItem(key, it, now); err != nil {
+		return "SERVER_ERROR " + err.Error()
+	}
+	return string(it.data)
+}
+
+// putItem writes it at key with a new cas, and the expiry of the item as the
+// expiry of the record.
+func (s *Server) putItem(key []byte, it item, now time.Time) error {
+	it.cas = s.cas.Add(1)
+
+	var ttl time.Duration
+	if it.expiry != 0 {
+		// the db rounds the expiry up to the next second, aim just past the
+		// second before so the record expires with the item
+		ttl = max(record.Time(it.expiry-1).Sub(now)+1, 1)
+	}
+	return s.db.PutMeta(key, it.data, it.meta(), ttl)
+}
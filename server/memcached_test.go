@@ -0,0 +1,320 @@
+package server_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestMemcached serves a fresh db with the memcached protocol and returns
+// it with the address to dial. They are closed when the test ends.
+func newTestMemcached(t *testing.T) (kv.KV, string) {
+	t.Helper()
+
+	db, err := kv.New(t.TempDir())
+	require.NoError(t, err)
+
+	srv, err := server.New(db, server.WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.ServeMemcached(l) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close())
+		require.ErrorIs(t, <-done, server.ErrServerClosed)
+		require.NoError(t, db.Close())
+	})
+
+	return db, l.Addr().String()
+}
+
+// mcClient is a minimal memcached client.
+type mcClient struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func dialMemcached(t *testing.T, addr string) *mcClient {
+	t.Helper()
+
+	nc, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = nc.Close() })
+	require.NoError(t, nc.SetDeadline(time.Now().Add(10*time.Second)))
+
+	return &mcClient{nc: nc, r: bufio.NewReader(nc)}
+}
+
+// send writes raw to the server.
+func (c *mcClient) send(t *testing.T, raw string) {
+	t.Helper()
+
+	_, err := io.WriteString(c.nc, raw)
+	require.NoError(t, err)
+}
+
+// line returns the next line of the server, without its CRLF.
+func (c *mcClient) line(t *testing.T) string {
+	t.Helper()
+
+	line, err := c.r.ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(line, "\r\n"), "reply %q", line)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// do sends a command line, with data when set, and returns the reply line.
+func (c *mcClient) do(t *testing.T, cmd string, data ...string) string {
+	t.Helper()
+
+	raw := cmd + "\r\n"
+	for _, d := range data {
+		raw += d + "\r\n"
+	}
+	c.send(t, raw)
+	return c.line(t)
+}
+
+// mcValue is a value returned by get or gets.
+type mcValue struct {
+	flags uint32
+	cas   uint64
+	data  string
+}
+
+// get sends a get or gets command and returns the values by key.
+func (c *mcClient) get(t *testing.T, cmd string) map[string]mcValue {
+	t.Helper()
+
+	c.send(t, cmd+"\r\n")
+	values := make(map[string]mcValue)
+	for {
+		line := c.line(t)
+		if line == "END" {
+			return values
+		}
+
+		f := strings.Fields(line)
+		require.True(t, len(f) == 4 || len(f) == 5, "reply %q", line)
+		require.Equal(t, "VALUE", f[0], "reply %q", line)
+
+		flags, err := strconv.ParseUint(f[2], 10, 32)
+		require.NoError(t, err)
+		size, err := strconv.Atoi(f[3])
+		require.NoError(t, err)
+		v := mcValue{flags: uint32(flags)}
+		if len(f) == 5 {
+			v.cas, err = strconv.ParseUint(f[4], 10, 64)
+			require.NoError(t, err)
+		}
+
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(c.r, buf)
+		require.NoError(t, err)
+		v.data = string(buf[:size])
+		values[f[1]] = v
+	}
+}
+
+func TestMemcached_SetGet(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	assert.Empty(t, c.get(t, "get k1"))
+
+	assert.Equal(t, "STORED", c.do(t, "set k1 42 0 2", "v1"))
+	assert.Equal(t, "STORED", c.do(t, "set k2 0 0 6", "a\r\nb c"))
+	assert.Equal(t, "STORED", c.do(t, "set empty 0 0 0", ""))
+
+	values := c.get(t, "get k1 k2 k3 empty")
+	assert.Equal(t, map[string]mcValue{
+		"k1":    {flags: 42, data: "v1"},
+		"k2":    {data: "a\r\nb c"},
+		"empty": {},
+	}, values)
+
+	assert.Equal(t, "VERSION kival", c.do(t, "version"))
+}
+
+func TestMemcached_AddReplace(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	assert.Equal(t, "NOT_STORED", c.do(t, "replace k1 0 0 2", "v0"))
+	assert.Equal(t, "STORED", c.do(t, "add k1 0 0 2", "v1"))
+	assert.Equal(t, "NOT_STORED", c.do(t, "add k1 0 0 2", "v2"))
+	assert.Equal(t, "STORED", c.do(t, "replace k1 7 0 2", "v3"))
+	assert.Equal(t, mcValue{flags: 7, data: "v3"}, c.get(t, "get k1")["k1"])
+}
+
+func TestMemcached_CAS(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	assert.Equal(t, "NOT_FOUND", c.do(t, "cas k1 0 0 2 1", "v1"))
+
+	c.do(t, "set k1 0 0 2", "v1")
+	first := c.get(t, "gets k1")["k1"]
+	require.NotZero(t, first.cas)
+
+	assert.Equal(t, "STORED", c.do(t, fmt.Sprintf("cas k1 0 0 2 %d", first.cas), "v2"))
+	assert.Equal(t, "EXISTS", c.do(t, fmt.Sprintf("cas k1 0 0 2 %d", first.cas), "v3"), "the cas changed with the value")
+
+	second := c.get(t, "gets k1")["k1"]
+	assert.Equal(t, "v2", second.data)
+	assert.Greater(t, second.cas, first.cas)
+
+	c.do(t, "set k1 0 0 2", "v2")
+	assert.NotEqual(t, second.cas, c.get(t, "gets k1")["k1"].cas, "every write changes the cas")
+}
+
+func TestMemcached_ForeignValues(t *testing.T) {
+	db, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("10")))
+	v := c.get(t, "gets k1")["k1"]
+	assert.Equal(t, mcValue{cas: v.cas, data: "10"}, v, "values written without memcached have no flags")
+
+	assert.Equal(t, "STORED", c.do(t, fmt.Sprintf("cas k1 3 0 2 %d", v.cas), "11"))
+	assert.Equal(t, "12", c.do(t, "incr k1 1"))
+	assert.Equal(t, mcValue{flags: 3, data: "12"}, c.get(t, "get k1")["k1"])
+
+	// values are told apart from items by the db, not by what they hold
+	val := "\x00MC" + strings.Repeat("\x01", 16) + "data"
+	require.NoError(t, db.Put([]byte("k2"), []byte(val)))
+	assert.Equal(t, mcValue{data: val}, c.get(t, "get k2")["k2"])
+}
+
+func TestMemcached_Delete(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	c.do(t, "set k1 0 0 2", "v1")
+	assert.Equal(t, "DELETED", c.do(t, "delete k1"))
+	assert.Equal(t, "NOT_FOUND", c.do(t, "delete k1"))
+	assert.Empty(t, c.get(t, "get k1"))
+}
+
+func TestMemcached_IncrDecr(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	assert.Equal(t, "NOT_FOUND", c.do(t, "incr n 1"))
+
+	c.do(t, "set n 5 100 2", "10")
+	assert.Equal(t, "15", c.do(t, "incr n 5"))
+	assert.Equal(t, "3", c.do(t, "decr n 12"))
+	assert.Equal(t, "0", c.do(t, "decr n 10"), "decr stops at 0")
+	assert.Equal(t, mcValue{flags: 5, data: "0"}, c.get(t, "get n")["n"], "flags are kept")
+
+	c.do(t, "set max 0 0 20", "18446744073709551615")
+	assert.Equal(t, "1", c.do(t, "incr max 2"), "incr wraps around")
+
+	c.do(t, "set s 0 0 1", "x")
+	assert.Equal(t, "CLIENT_ERROR cannot increment or decrement non-numeric value", c.do(t, "incr s 1"))
+	assert.Equal(t, "CLIENT_ERROR invalid numeric delta argument", c.do(t, "incr n x"))
+}
+
+func TestMemcached_Exptime(t *testing.T) {
+	db, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	assert.Equal(t, "STORED", c.do(t, "set short 0 1 1", "v"))
+	assert.Equal(t, "STORED", c.do(t, "set long 0 1000 1", "v"))
+	assert.Equal(t, "STORED", c.do(t, fmt.Sprintf("set abs 0 %d 1", time.Now().Add(time.Hour).Unix()), "v"))
+	assert.Equal(t, "STORED", c.do(t, "set gone 0 -1 1", "v"))
+	assert.Equal(t, "STORED", c.do(t, fmt.Sprintf("set past 0 %d 1", time.Now().Add(-time.Hour).Unix()), "v"))
+
+	assert.Len(t, c.get(t, "get short long abs gone past"), 3)
+	_, err := db.Get([]byte("gone"))
+	assert.ErrorIs(t, err, kv.ErrKeyNotFound)
+
+	c.do(t, "set n 0 1 1", "1")
+	assert.Equal(t, "2", c.do(t, "incr n 1"))
+
+	time.Sleep(2100 * time.Millisecond) // expiry has a one second resolution
+	values := c.get(t, "get short long abs n")
+	assert.Contains(t, values, "long")
+	assert.Contains(t, values, "abs")
+	assert.NotContains(t, values, "short")
+	assert.NotContains(t, values, "n", "incr keeps the expiry")
+}
+
+func TestMemcached_Noreply(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	c.send(t, "set k1 0 0 2 noreply\r\nv1\r\nadd k2 0 0 2 noreply\r\nv2\r\nincr k3 1 noreply\r\ndelete k2 noreply\r\n")
+	assert.Equal(t, map[string]mcValue{"k1": {data: "v1"}}, c.get(t, "get k1 k2"))
+}
+
+func TestMemcached_Errors(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	assert.Equal(t, "ERROR", c.do(t, "flush_all"))
+	assert.Equal(t, "ERROR", c.do(t, ""))
+	assert.Equal(t, "ERROR", c.do(t, "get"))
+	assert.Equal(t, "ERROR", c.do(t, "set k1 0 0"))
+	assert.Equal(t, "CLIENT_ERROR bad command line format", c.do(t, "set k1 x 0 2", "v1"), "the data is skipped")
+	assert.Equal(t, "CLIENT_ERROR bad command line format", c.do(t, "get "+strings.Repeat("k", 251)))
+	assert.Equal(t, "CLIENT_ERROR bad command line format", c.do(t, "set "+strings.Repeat("k", 251)+" 0 0 2", "v1"))
+	assert.Equal(t, "STORED", c.do(t, "set "+strings.Repeat("k", 250)+" 0 0 2", "v1"))
+
+	assert.Equal(t, "CLIENT_ERROR bad data chunk", c.do(t, "set k1 0 0 2", "v1x"))
+	_, err := c.r.ReadByte()
+	assert.Error(t, err, "the connection is closed")
+}
+
+func TestMemcached_Quit(t *testing.T) {
+	_, addr := newTestMemcached(t)
+	c := dialMemcached(t, addr)
+
+	c.send(t, "quit\r\nversion\r\n")
+	_, err := c.r.ReadByte()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestMemcached_SharedWithRESP(t *testing.T) {
+	db, err := kv.New(t.TempDir())
+	require.NoError(t, err)
+	defer db.Close()
+
+	srv, err := server.New(db, server.WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	redis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	memcached, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = srv.Serve(redis) }()
+	go func() { _ = srv.ServeMemcached(memcached) }()
+
+	r := dial(t, redis.Addr().String())
+	m := dialMemcached(t, memcached.Addr().String())
+
+	assert.Equal(t, "OK", r.do(t, "SET", "k1", "v1"))
+	assert.Equal(t, "v1", m.get(t, "get k1")["k1"].data)
+
+	assert.Equal(t, "STORED", m.do(t, "set k2 5 0 2", "v2"))
+	assert.Equal(t, "v2", r.do(t, "GET", "k2"), "Redis clients get the data without the item header")
+
+	require.NoError(t, srv.Close())
+	_, err = m.r.ReadByte()
+	assert.Error(t, err, "Close stops both protocols")
+}
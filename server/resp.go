@@ -0,0 +1,203 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+)
+
+const (
+	// maxInlineSize bounds the lines the reader takes, inline commands included.
+	maxInlineSize = 64 << 10
+	// maxBulkSize is the largest argument a command takes, as in Redis.
+	maxBulkSize = 512 << 20
+	// maxArgs is the most arguments a command takes.
+	maxArgs = 1 << 20
+	// argsPrealloc and blockChunk bound what is allocated ahead of the
+	// arguments and bytes a client announces, the rest is allocated as they
+	// arrive.
+	argsPrealloc = 1 << 10
+	blockChunk   = 64 << 10
+)
+
+// errProtocol is returned by respReader for requests that are not RESP, the
+// connection cannot be read any further.
+var errProtocol = errors.New("Protocol error")
+
+// respReader reads the commands sent by a client: RESP arrays of bulk strings,
+// or inline commands, a line of words separated by spaces as typed in telnet.
+type respReader struct {
+	r *bufio.Reader
+}
+
+func newRESPReader(r io.Reader) *respReader {
+	return &respReader{r: bufio.NewReaderSize(r, maxInlineSize)}
+}
+
+// buffered reports whether a command was already received, as when a client
+// pipelines them.
+func (r *respReader) buffered() bool {
+	return r.r.Buffered() > 0
+}
+
+// readCommand returns the next command, name first. It returns an empty
+// command for an empty line or array, which should be ignored.
+func (r *respReader) readCommand() ([][]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+
+	n, err := parseLength(line[1:], maxArgs)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid multibulk length", errProtocol)
+	}
+
+	args := make([][]byte, 0, min(n, argsPrealloc))
+	for range n {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%s'", errProtocol, truncate(line))
+		}
+
+		size, err := parseLength(line[1:], maxBulkSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid bulk length", errProtocol)
+		}
+
+		buf, err := readBlock(r.r, size+2)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(buf, []byte("\r\n")) {
+			return nil, fmt.Errorf("%w: bulk string not followed by CRLF", errProtocol)
+		}
+		args = append(args, buf[:size])
+	}
+
+	return args, nil
+}
+
+// readBlock reads the n bytes of a bulk string or a data block from r. The
+// buffer grows by blockChunk as the bytes arrive rather than to n upfront, so
+// a client announcing a large block it never sends costs little memory.
+func readBlock(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, 0, min(n, blockChunk))
+	for len(buf) < n {
+		m := min(n-len(buf), blockChunk)
+		buf = slices.Grow(buf, m)
+		if _, err := io.ReadFull(r, buf[len(buf):len(buf)+m]); err != nil {
+			if errors.Is(err, io.EOF) && len(buf) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		buf = buf[:len(buf)+m]
+	}
+
+	return buf, nil
+}
+
+// readLine returns the next line without its line ending. Inline commands may
+// end with a bare LF, as in Redis.
+func (r *respReader) readLine() ([]byte, error) {
+	line, err := r.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return nil, fmt.Errorf("%w: too big request", errProtocol)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	line = bytes.TrimSuffix(line[:len(line)-1], []byte("\r"))
+	return bytes.Clone(line), nil
+}
+
+// parseLength parses the length of an array or a bulk string, in [0, limit].
+// A negative length, a null in RESP, reads as 0.
+func parseLength(b []byte, limit int) (int, error) {
+	n, err := strconv.Atoi(string(b))
+	if err != nil || n > limit {
+		return 0, errProtocol
+	}
+	return max(n, 0), nil
+}
+
+func truncate(b []byte) []byte {
+	if len(b) > 32 {
+		return b[:32]
+	}
+	return b
+}
+
+// respWriter writes replies in RESP2. Errors are kept until flush.
+type respWriter struct {
+	w *bufio.Writer
+}
+
+func newRESPWriter(w io.Writer) *respWriter {
+	return &respWriter{w: bufio.NewWriter(w)}
+}
+
+// simple writes a simple string, s must not hold CR or LF.
+func (w *respWriter) simple(s string) {
+	w.w.WriteByte('+')
+	w.w.WriteString(s)
+	w.w.WriteString("\r\n")
+}
+
+// writeError writes an error reply, msg starts with its kind like ERR.
+func (w *respWriter) writeError(msg string) {
+	w.w.WriteByte('-')
+	w.w.WriteString(msg)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) writeErrorf(format string, args ...any) {
+	w.writeError(fmt.Sprintf(format, args...))
+}
+
+func (w *respWriter) integer(n int64) {
+	w.w.WriteByte(':')
+	w.w.WriteString(strconv.FormatInt(n, 10))
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) bulk(b []byte) {
+	w.w.WriteByte('$')
+	w.w.WriteString(strconv.Itoa(len(b)))
+	w.w.WriteString("\r\n")
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) bulkString(s string) {
+	w.bulk([]byte(s))
+}
+
+// null writes a null bulk string, the reply for a missing key.
+func (w *respWriter) null() {
+	w.w.WriteString("$-1\r\n")
+}
+
+// array starts an array of n elements, written next.
+func (w *respWriter) array(n int) {
+	w.w.WriteByte('*')
+	w.w.WriteString(strconv.Itoa(n))
+	w.w.WriteString("\r\n")
+}
+
+func (w *respWriter) flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,256 @@
+// Package server exposes a kv.KV over the network.
+//
+// Server speaks RESP2, the protocol of Redis, so redis-cli and Redis client
+// libraries work against a Kival database:
+//
+//	srv, err := server.New(db)
+//	if err != nil {
+//		return err
+//	}
+//	go srv.ListenAndServe("127.0.0.1:6380")
+//	defer srv.Close()
+//
+// HTTPHandler serves the same db as a REST API, for clients with no Redis
+// library at hand, and ServeMemcached speaks the memcached text protocol.
+package server
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/1garo/kival/kv"
+)
+
+var ErrServerClosed = errors.New("server closed")
+
+// Server serves a kv.KV to Redis clients, see commands for what it supports.
+// The db is left open by Close, it belongs to the caller.
+type Server struct {
+	db      kv.KV
+	logger  *slog.Logger
+	started time.Time
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup // connections being served
+
+	mcMu sync.Mutex    // held by the memcached commands that read then write
+	cas  atomic.Uint64 // last cas given to a memcached item
+
+	scanCursors scanCursors
+}
+
+// Option configures a Server built by New.
+type Option func(*Server) error
+
+// WithLogger sets where the server reports connection errors. The default is
+// slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Server) error {
+		if logger == nil {
+			logger = slog.New(slog.DiscardHandler)
+		}
+
+		s.logger = logger
+		return nil
+	}
+}
+
+// New returns a server for db.
+func New(db kv.KV, opts ...Option) (*Server, error) {
+	s := &Server{
+		db:        db,
+		logger:    slog.Default(),
+		started:   time.Now(),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	// cas values keep growing across restarts, a client holding one from
+	// before cannot overwrite a newer item
+	s.cas.Store(uint64(s.started.UnixNano()))
+
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// ListenAndServe listens on the TCP address addr and serves the connections
+// it accepts, see Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve serves the connections accepted on l, each in its own goroutine. It
+// returns once l fails, with ErrServerClosed after Close.
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, s.serveConn)
+}
+
+// serve accepts connections on l and runs handle on each of them.
+func (s *Server) serve(l net.Listener, handle func(net.Conn)) error {
+	if !s.track(l) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	defer s.untrack(l)
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		if !s.trackConn(nc) {
+			_ = nc.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.untrackConn(nc)
+			defer nc.Close()
+
+			handle(nc)
+		}()
+	}
+}
+
+// Close stops the listeners, closes the connections and waits for the
+// commands running on them to finish.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+
+	var errs []error
+	for l := range s.listeners {
+		errs = append(errs, l.Close())
+	}
+	for nc := range s.conns {
+		errs = append(errs, nc.Close())
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+func (s *Server) track(l net.Listener) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.listeners[l] = struct{}{}
+	return true
+}
+
+func (s *Server) untrack(l net.Listener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.listeners, l)
+}
+
+func (s *Server) trackConn(nc net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.conns[nc] = struct{}{}
+	s.wg.Add(1)
+	return true
+}
+
+func (s *Server) untrackConn(nc net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, nc)
+	s.mu.Unlock()
+
+	s.wg.Done()
+}
+
+// clients returns how many connections are being served.
+func (s *Server) clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.conns)
+}
+
+// conn is a client connection.
+type conn struct {
+	server *Server
+	nc     net.Conn
+	r      *respReader
+	w      *respWriter
+	quit   bool // QUIT was received, the connection closes once the reply is sent
+}
+
+func (s *Server) serveConn(nc net.Conn) {
+	c := &conn{server: s, nc: nc, r: newRESPReader(nc), w: newRESPWriter(nc)}
+	for !c.quit {
+		args, err := c.r.readCommand()
+		if err != nil {
+			if errors.Is(err, errProtocol) {
+				c.w.writeErrorf("ERR %v", err)
+				_ = c.w.flush()
+			} else if !errors.Is(err, io.EOF) && !s.isClosed() {
+				s.logger.Debug("cannot read from client", "remote", nc.RemoteAddr(), "err", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		c.exec(args)
+
+		// pipelined commands are answered at once
+		if c.r.buffered() && !c.quit {
+			continue
+		}
+		if err := c.w.flush(); err != nil {
+			if !s.isClosed() {
+				s.logger.Debug("cannot write to client", "remote", nc.RemoteAddr(), "err", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package server_test
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1garo/kival/kv"
+	"github.com/1garo/kival/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer serves a fresh db on a loopback port and returns both, with
+// the address to dial. They are closed when the test ends.
+func newTestServer(t *testing.T) (kv.KV, *server.Server, string) {
+	t.Helper()
+
+	db, err := kv.New(t.TempDir())
+	require.NoError(t, err)
+
+	srv, err := server.New(db, server.WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- srv.Serve(l) }()
+	t.Cleanup(func() {
+		require.NoError(t, srv.Close())
+		require.ErrorIs(t, <-done, server.ErrServerClosed)
+		require.NoError(t, db.Close())
+	})
+
+	return db, srv, l.Addr().String()
+}
+
+// client is a minimal Redis client.
+type client struct {
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+// respError is an error reply.
+type respError string
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+
+	nc, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = nc.Close() })
+	require.NoError(t, nc.SetDeadline(time.Now().Add(10*time.Second)))
+
+	return &client{nc: nc, r: bufio.NewReader(nc)}
+}
+
+// send writes a command as a RESP array.
+func (c *client) send(t *testing.T, args ...string) {
+	t.Helper()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	_, err := io.WriteString(c.nc, b.String())
+	require.NoError(t, err)
+}
+
+// do sends a command and returns the reply, see read.
+func (c *client) do(t *testing.T, args ...string) any {
+	t.Helper()
+
+	c.send(t, args...)
+	return c.read(t)
+}
+
+// read returns the next reply: a string for simple and bulk strings, nil for a
+// null, an int64, a respError or a []any.
+func (c *client) read(t *testing.T) any {
+	t.Helper()
+
+	line, err := c.r.ReadString('\n')
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(line, "\r\n"), "reply %q", line)
+	line = strings.TrimSuffix(line, "\r\n")
+
+	switch line[0] {
+	case '+':
+		return line[1:]
+	case '-':
+		return respError(line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		require.NoError(t, err)
+		return n
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(t, err)
+		if n < 0 {
+			return nil
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(c.r, buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		require.NoError(t, err)
+		elems := make([]any, n)
+		for i := range elems {
+			elems[i] = c.read(t)
+		}
+		return elems
+	}
+
+	require.Failf(t, "invalid reply", "%q", line)
+	return nil
+}
+
+// closed reports whether the server closed the connection. Closing with
+// unread input resets the connection instead of ending it.
+func (c *client) closed() bool {
+	_, err := c.r.ReadByte()
+	return err != nil && !errors.Is(err, os.ErrDeadlineExceeded)
+}
+
+func TestServer_PingEcho(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	assert.Equal(t, "PONG", c.do(t, "PING"))
+	assert.Equal(t, "hello", c.do(t, "ping", "hello"))
+	assert.Equal(t, "hi", c.do(t, "ECHO", "hi"))
+}
+
+func TestServer_Pipelining(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	for i := range 100 {
+		c.send(t, "SET", fmt.Sprintf("k%d", i), fmt.Sprintf("v%d", i))
+	}
+	for i := range 100 {
+		c.send(t, "GET", fmt.Sprintf("k%d", i))
+	}
+
+	for range 100 {
+		assert.Equal(t, "OK", c.read(t))
+	}
+	for i := range 100 {
+		assert.Equal(t, fmt.Sprintf("v%d", i), c.read(t))
+	}
+}
+
+func TestServer_InlineCommands(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	_, err := io.WriteString(c.nc, "SET k1 v1\r\n\r\nGET k1\nEXISTS k1 k2\r\n")
+	require.NoError(t, err)
+
+	assert.Equal(t, "OK", c.read(t))
+	assert.Equal(t, "v1", c.read(t))
+	assert.Equal(t, int64(1), c.read(t))
+}
+
+func TestServer_ProtocolError(t *testing.T) {
+	for _, req := range []string{
+		"*x\r\n",
+		"*1\r\n+GET\r\n",
+		"*1\r\n$-x\r\n",
+		"*1\r\n$3\r\nGETXX",
+		"*1\r\n$999999999999\r\n",
+		"GET " + strings.Repeat("k", 70<<10) + "\r\n",
+	} {
+		_, _, addr := newTestServer(t)
+		c := dial(t, addr)
+
+		_, err := io.WriteString(c.nc, req)
+		require.NoError(t, err)
+
+		reply := c.read(t)
+		assert.IsType(t, respError(""), reply)
+		assert.Contains(t, reply, "ERR Protocol error", "request %.20q", req)
+		assert.True(t, c.closed(), "request %.20q", req)
+	}
+}
+
+func TestReadBlock_GrowsAsBytesArrive(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := server.ReadBlock(strings.NewReader("abc"), server.MaxBulkSize)
+	runtime.ReadMemStats(&after)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(1<<20), "the announced size is not allocated upfront")
+
+	data := strings.Repeat("0123456789", 30<<10)
+	got, err := server.ReadBlock(strings.NewReader(data+"rest"), len(data))
+	require.NoError(t, err)
+	assert.Equal(t, data, string(got))
+
+	_, err = server.ReadBlock(strings.NewReader(""), 10)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServer_BinaryValues(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	val := "a\r\nb\x00c"
+	assert.Equal(t, "OK", c.do(t, "SET", "bin", val))
+	assert.Equal(t, val, c.do(t, "GET", "bin"))
+}
+
+func TestServer_Quit(t *testing.T) {
+	_, _, addr := newTestServer(t)
+	c := dial(t, addr)
+
+	c.send(t, "QUIT")
+	c.send(t, "PING")
+	assert.Equal(t, "OK", c.read(t))
+	assert.True(t, c.closed(), "commands after QUIT are not run")
+}
+
+func TestServer_Close(t *testing.T) {
+	db, srv, addr := newTestServer(t)
+	c := dial(t, addr)
+	assert.Equal(t, "PONG", c.do(t, "PING"))
+
+	require.NoError(t, srv.Close())
+	assert.True(t, c.closed())
+
+	_, err := net.Dial("tcp", addr)
+	assert.Error(t, err, "the listener is closed")
+
+	require.NoError(t, db.Put([]byte("k1"), []byte("v1")), "the db stays open")
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	assert.ErrorIs(t, srv.Serve(l), server.ErrServerClosed)
+}
+
+func TestServer_ConcurrentClients(t *testing.T) {
+	db, _, addr := newTestServer(t)
+
+	done := make(chan struct{})
+	for i := range 8 {
+		c := dial(t, addr)
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := range 50 {
+				key := fmt.Sprintf("c%d:%d", i, j)
+				assert.Equal(t, "OK", c.do(t, "SET", key, key))
+			}
+		}()
+	}
+	for range 8 {
+		<-done
+	}
+
+	for i := range 8 {
+		val, err := db.Get(fmt.Appendf(nil, "c%d:49", i))
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("c%d:49", i), string(val))
+	}
+}